@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/palSagnik/uriel/internal/database"
+	"github.com/palSagnik/uriel/internal/models"
+)
+
+// usernames are at most 32 characters, see the username pattern in internal/auth
+const maxUsernameLength = 32
+
+// keeperFirst orders the users of a collision so the one that keeps the name comes first:
+// verified accounts before unverified ones, then the oldest account
+func keeperFirst(users []models.User) {
+	sort.SliceStable(users, func(i, j int) bool {
+		if users[i].Verified != users[j].Verified {
+			return users[i].Verified
+		}
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].ID.Hex() < users[j].ID.Hex()
+	})
+}
+
+// freeUsername appends the first free number to username, shortening it if the result gets too long
+func freeUsername(username string, taken func(string) (bool, error)) (string, error) {
+	for n := 2; n < 1000; n++ {
+		suffix := fmt.Sprintf("-%d", n)
+		base := username
+		if len(base)+len(suffix) > maxUsernameLength {
+			base = base[:maxUsernameLength-len(suffix)]
+		}
+
+		candidate := base + suffix
+		isTaken, err := taken(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !isTaken {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free username for %s", username)
+}
+
+// placeholderEmail cannot be delivered to (RFC 2606) and is unique per user
+func placeholderEmail(user models.User) string {
+	return user.ID.Hex() + "@duplicate.invalid"
+}
+
+// resolveUsernames keeps the name of the first user of every collision and renames the others.
+// Without fix the renames are only reported.
+func resolveUsernames(ctx context.Context, repo *database.MigrationRepository, collisions []database.CaseCollision, fix bool) error {
+	// names handed out in a dry run are not in the database yet
+	planned := map[string]bool{}
+	taken := func(username string) (bool, error) {
+		if planned[strings.ToLower(username)] {
+			return true, nil
+		}
+		return repo.UsernameTaken(ctx, username)
+	}
+
+	for _, collision := range collisions {
+		keeperFirst(collision.Users)
+		log.Printf("username %q is used by %d accounts, %s keeps it", collision.Key, len(collision.Users), collision.Users[0].ID.Hex())
+
+		for _, user := range collision.Users[1:] {
+			username, err := freeUsername(user.Username, taken)
+			if err != nil {
+				return err
+			}
+			planned[strings.ToLower(username)] = true
+
+			log.Printf("  %s: %s -> %s", user.ID.Hex(), user.Username, username)
+			if !fix {
+				continue
+			}
+			if err := repo.RenameUser(ctx, user, username); err != nil {
+				return fmt.Errorf("renaming user %s: %w", user.ID.Hex(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveEmails keeps the email of the first user of every collision,
+// the others get a placeholder and have to set a new email
+func resolveEmails(ctx context.Context, repo *database.MigrationRepository, collisions []database.CaseCollision, fix bool) error {
+	for _, collision := range collisions {
+		keeperFirst(collision.Users)
+		log.Printf("email %q is used by %d accounts, %s keeps it", collision.Key, len(collision.Users), collision.Users[0].ID.Hex())
+
+		for _, user := range collision.Users[1:] {
+			email := placeholderEmail(user)
+
+			log.Printf("  %s (%s): %s -> %s", user.ID.Hex(), user.Username, user.Email, email)
+			if !fix {
+				continue
+			}
+			if err := repo.ReplaceUserEmail(ctx, user, email); err != nil {
+				return fmt.Errorf("replacing email of user %s: %w", user.ID.Hex(), err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestKeeperFirst(t *testing.T) {
+	now := time.Now()
+	oldest := models.User{ID: primitive.NewObjectID(), Username: "alice", CreatedAt: now.Add(-2 * time.Hour)}
+	verified := models.User{ID: primitive.NewObjectID(), Username: "Alice", Verified: true, CreatedAt: now.Add(-time.Hour)}
+	newest := models.User{ID: primitive.NewObjectID(), Username: "ALICE", CreatedAt: now}
+
+	users := []models.User{newest, oldest, verified}
+	keeperFirst(users)
+
+	assert.Equal(t, []string{"Alice", "alice", "ALICE"}, []string{users[0].Username, users[1].Username, users[2].Username})
+}
+
+func TestFreeUsername(t *testing.T) {
+	existing := map[string]bool{"alice-2": true, "alice-3": true}
+	taken := func(username string) (bool, error) { return existing[strings.ToLower(username)], nil }
+
+	username, err := freeUsername("Alice", taken)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice-4", username)
+
+	// the suffix has to fit into 32 characters
+	long := strings.Repeat("a", 32)
+	username, err = freeUsername(long, taken)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 30)+"-2", username)
+}
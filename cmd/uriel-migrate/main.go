@@ -0,0 +1,70 @@
+// uriel-migrate finds users whose username or email only differ in case.
+// They keep the server from creating the case insensitive unique indexes.
+// It also reports usernames that registration would refuse today.
+//
+//	go run ./cmd/uriel-migrate        reports the collisions and how they would be resolved
+//	go run ./cmd/uriel-migrate -fix   resolves them and creates the indexes
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/database"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "rename colliding accounts instead of only reporting them")
+	flag.Parse()
+
+	cfg := config.LoadConfig()
+
+	mongodb, err := database.NewMongoClient(cfg.MongoDBURI)
+	if err != nil {
+		log.Fatalf("Failed to connect to mongo: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	repo := database.NewMigrationRepository(mongodb)
+
+	usernames, err := repo.FindCaseCollisions(ctx, "username")
+	if err != nil {
+		log.Fatalf("Failed to find username collisions: %v", err)
+	}
+	emails, err := repo.FindCaseCollisions(ctx, "email")
+	if err != nil {
+		log.Fatalf("Failed to find email collisions: %v", err)
+	}
+
+	users, err := repo.ListUsernames(ctx)
+	if err != nil {
+		log.Fatalf("Failed to list usernames: %v", err)
+	}
+	reportUsernames(invalidUsernames(users))
+
+	if len(usernames) == 0 && len(emails) == 0 {
+		log.Println("No users differ only in case.")
+	}
+	if err := resolveUsernames(ctx, repo, usernames, *fix); err != nil {
+		log.Fatalf("Failed to resolve username collisions: %v", err)
+	}
+	if err := resolveEmails(ctx, repo, emails, *fix); err != nil {
+		log.Fatalf("Failed to resolve email collisions: %v", err)
+	}
+
+	if !*fix {
+		if len(usernames) > 0 || len(emails) > 0 {
+			log.Println("Nothing was changed, run again with -fix to apply.")
+		}
+		return
+	}
+
+	// the auth repository creates the indexes, the same as on server start
+	database.NewAuthRepository(mongodb)
+	log.Println("Done.")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+
+	"github.com/palSagnik/uriel/internal/auth"
+	"github.com/palSagnik/uriel/internal/models"
+)
+
+// invalidUsernames returns the users whose username registration would refuse today
+func invalidUsernames(users []models.User) []models.User {
+	var invalid []models.User
+	for _, user := range users {
+		if !auth.ValidUsername(user.Username) {
+			invalid = append(invalid, user)
+		}
+	}
+	return invalid
+}
+
+// reportUsernames lists the invalid usernames. They still log in, renaming them is left to
+// the users since the name has to change in a way they recognise.
+func reportUsernames(users []models.User) {
+	for _, user := range users {
+		log.Printf("username %q of %s is not valid anymore", user.Username, user.ID.Hex())
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/palSagnik/uriel/internal/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestInvalidUsernames(t *testing.T) {
+	var users []models.User
+	for _, username := range []string{"alice", "old@name", "bo", "_bob", "carol.smith", "with space"} {
+		users = append(users, models.User{ID: primitive.NewObjectID(), Username: username})
+	}
+
+	var usernames []string
+	for _, user := range invalidUsernames(users) {
+		usernames = append(usernames, user.Username)
+	}
+	assert.Equal(t, []string{"old@name", "bo", "_bob", "with space"}, usernames)
+}
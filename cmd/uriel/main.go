@@ -1,12 +1,22 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/apperr"
 	"github.com/palSagnik/uriel/internal/auth"
+	"github.com/palSagnik/uriel/internal/blob"
 	"github.com/palSagnik/uriel/internal/config"
 	"github.com/palSagnik/uriel/internal/database"
+	"github.com/palSagnik/uriel/internal/mail"
+	"github.com/palSagnik/uriel/internal/oidc"
+	"github.com/palSagnik/uriel/internal/password"
+	"github.com/palSagnik/uriel/internal/presence"
+	"github.com/palSagnik/uriel/internal/ratelimit"
 	"github.com/palSagnik/uriel/internal/user"
 )
 
@@ -14,6 +24,9 @@ func main() {
 
 	// Loading config
 	cfg := config.LoadConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
 
 	mongodb, err := database.NewMongoClient(cfg.MongoDBURI)
 	if err != nil {
@@ -22,15 +35,142 @@ func main() {
 
 	router := gin.Default()
 	router.Use(gin.Logger(), gin.Recovery())
+	router.Use(apperr.Middleware())
+	router.NoRoute(func(c *gin.Context) {
+		c.Error(apperr.NotFound("route not found"))
+	})
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// --- Initialise Repositories ---
 	authRepo := database.NewAuthRepository(mongodb)
 	userRepo := database.NewUserRepository(mongodb)
 	avatarRepo := database.NewAvatarRepository(mongodb)
+	refreshTokenRepo := database.NewRefreshTokenRepository(mongodb)
+	revocationRepo := database.NewRevocationRepository(mongodb)
+	personalAccessTokenRepo := database.NewPersonalAccessTokenRepository(mongodb)
+	sessionRepo := database.NewSessionRepository(mongodb)
+	guestRepo := database.NewGuestRepository(mongodb)
+	usernameAliasRepo := database.NewUsernameAliasRepository(mongodb)
+	auditLog := database.NewAuditLog(mongodb)
+
+	// --- Initialise Mailer ---
+	var mailer mail.Mailer
+	if cfg.SMTPHost != "" {
+		mailer = mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	} else if cfg.MailLogFile != "" {
+		mailLog, err := os.OpenFile(cfg.MailLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Fatalf("Failed to open mail log file: %v", err)
+		}
+		defer mailLog.Close()
+		mailer = mail.NewLogMailer(mailLog)
+	} else {
+		mailer = mail.NewLogMailer(os.Stdout)
+	}
+
+	// --- Initialise Rate Limiting ---
+	// in memory limits and lockouts are per instance, swap in ratelimit.NewRedisStore
+	// and ratelimit.NewRedisLockoutStore when running several
+	rateLimitStore := ratelimit.NewMemoryStore()
+	lockoutStore := ratelimit.NewMemoryLockoutStore()
+
+	// --- Initialise Presence ---
+	// like the rate limits presence events only reach the clients of this instance
+	presenceBroker := presence.NewBroker()
+
+	// the first rule that applies decides, a meeting shows as busy even after hours.
+	// There is no calendar integration yet, presence.Busy joins the rules once there is a source.
+	var presenceRules []presence.Rule
+	if cfg.WorkingHoursStart != "" || cfg.WorkingHoursEnd != "" {
+		workingHours, err := presence.ParseWorkingHours(cfg.WorkingHoursTimezone, cfg.WorkingHoursStart, cfg.WorkingHoursEnd, cfg.WorkingHoursDays)
+		if err != nil {
+			log.Fatalf("Invalid WORKING_HOURS: %v", err)
+		}
+		presenceRules = append(presenceRules, presence.OffHours(workingHours))
+	}
+	if cfg.AwayAfterMinutes > 0 {
+		presenceRules = append(presenceRules, presence.Idle(time.Duration(cfg.AwayAfterMinutes)*time.Minute))
+	}
+	presenceEngine := presence.NewEngine(presenceRules...)
+
+	// --- Initialise Media ---
+	// files on local disk only reach the clients of this instance, shared object storage
+	// for several instances is a blob.Store of its own
+	mediaStore := blob.NewLocalStore(cfg.MediaDir, cfg.MediaURL)
+	router.Static("/media", cfg.MediaDir)
+
+	// --- Initialise Password Policy ---
+	passwordPolicy := password.DefaultPolicy()
+	passwordPolicy.MinLength = cfg.PasswordMinLength
+	passwordPolicy.MinClasses = cfg.PasswordMinClasses
+	if cfg.BreachedPasswordsFile != "" {
+		breached, err := password.LoadBreachedFile(cfg.BreachedPasswordsFile)
+		if err != nil {
+			log.Fatalf("Failed to load breached passwords: %v", err)
+		}
+		passwordPolicy.Breached = breached
+	}
 
 	// --- Initialise Services ---
-	authService := auth.NewService(authRepo, []byte(cfg.JWTSecret))
-	userService := user.NewService(userRepo, avatarRepo)
+	authOptions := []auth.Option{
+		auth.WithRefreshTokenRepository(refreshTokenRepo),
+		auth.WithRevocationRepository(revocationRepo),
+		auth.WithPersonalAccessTokenRepository(personalAccessTokenRepo),
+		auth.WithSessionRepository(sessionRepo),
+		auth.WithGuestRepository(guestRepo),
+		auth.WithUsernameAliasRepository(usernameAliasRepo),
+		auth.WithAuditLog(auditLog),
+		auth.WithPresenceBroker(presenceBroker),
+		auth.WithMailer(mailer),
+		auth.WithPublicURL(cfg.PublicURL),
+		auth.WithUnverifiedLoginPolicy(cfg.UnverifiedLogin),
+		auth.WithAdminMFARequired(cfg.RequireAdminMFA),
+		auth.WithPasswordPolicy(passwordPolicy),
+		auth.WithRateLimitStore(rateLimitStore),
+		auth.WithLockout(ratelimit.NewLockout(lockoutStore, config.LOCKOUT_THRESHOLD, config.LOCKOUT_DURATION, config.MAX_LOCKOUT_DURATION)),
+	}
+
+	// --- Initialise Signing Keys ---
+	if cfg.SigningKeysDir != "" {
+		keys, err := auth.LoadSigningKeys(cfg.SigningKeysDir)
+		if err != nil {
+			log.Fatalf("Failed to load signing keys: %v", err)
+		}
+		keyring, err := auth.NewKeyring(config.SIGNING_KEY_OVERLAP, keys...)
+		if err != nil {
+			log.Fatalf("Invalid signing keys: %v", err)
+		}
+		if _, err := keyring.SigningKey(); err != nil {
+			log.Fatalf("Invalid signing keys: %v", err)
+		}
+		authOptions = append(authOptions, auth.WithKeyring(keyring))
+	}
+
+	// --- Initialise Single Sign-On ---
+	if cfg.OIDCIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := oidc.NewProvider(ctx, oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+		}, nil)
+		cancel()
+		if err != nil {
+			log.Fatalf("Failed to set up OIDC provider: %v", err)
+		}
+		authOptions = append(authOptions, auth.WithOIDCProvider(provider))
+	}
+
+	authService := auth.NewService(authRepo, []byte(cfg.JWTSecret), authOptions...)
+	userService := user.NewService(userRepo, avatarRepo,
+		user.WithPresenceBroker(presenceBroker),
+		user.WithPresenceEngine(presenceEngine),
+		user.WithBlobStore(mediaStore),
+		user.WithMediaURL(cfg.MediaURL),
+	)
 
 	// --- Initialise Handlers ---
 	authHandler := auth.NewHandler(authService)
@@ -38,13 +178,21 @@ func main() {
 
 	// --- Initialise Middleware ---
 	authMiddleware := authService.AuthMiddleware()
+	avatarAdminMiddleware := auth.RequirePermission(auth.PermManageAvatars)
+	directoryMiddleware := auth.RequirePermission(auth.PermReadWorkspace)
+	profileMiddleware := auth.RequirePermission(auth.PermUpdateProfile)
+
+	auth.RegisterWellKnownRoutes(router, authHandler)
 
 	v1 := router.Group("/api/v1")
 	{
-		auth.RegisterRoutes(v1, authHandler)
-		user.RegisterRoutes(v1, userHandler, authMiddleware)
+		auth.RegisterRoutes(v1, authHandler, authMiddleware, rateLimitStore)
+		user.RegisterRoutes(v1, userHandler, authMiddleware, avatarAdminMiddleware, directoryMiddleware, profileMiddleware)
 	}
 
+	// --- Background Jobs ---
+	go userService.RunPresenceScheduler(context.Background(), config.PRESENCE_TICK_INTERVAL)
+
 	// --- Running the server ---
 	router.Run(cfg.ServerPort)
 }
@@ -0,0 +1,141 @@
+// Package apperr has the errors handlers report and the middleware that renders them
+// as the standard error response described in docs/Discussion.md.
+//
+// Handlers hand the error to gin with c.Error and return, middleware that stops a request
+// calls c.Abort as well. Errors that are not typed here are answered with INTERNAL_ERROR
+// and logged, their text never reaches the client.
+package apperr
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// the codes of the error responses, see docs/Discussion.md
+const (
+	CodeValidation     = "VALIDATION_ERROR"
+	CodeAuthentication = "AUTHENTICATION_FAILED"
+	CodeAuthorization  = "AUTHORIZATION_FAILED"
+	CodeNotFound       = "RESOURCE_NOT_FOUND"
+	CodeConflict       = "RESOURCE_CONFLICT"
+	CodeRateLimit      = "RATE_LIMIT_EXCEEDED"
+	CodeUploadSize     = "UPLOAD_SIZE_EXCEEDED"
+	CodeFileType       = "INVALID_FILE_TYPE"
+	CodeInternal       = "INTERNAL_ERROR"
+)
+
+// Error is an error with the status, code and message it is answered with.
+// Package level values of it work as sentinels with errors.Is.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Details map[string]any
+
+	// RetryAfter is sent as the Retry-After header when set
+	RetryAfter time.Duration
+
+	// Err is the cause, it is logged but not sent
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Invalid is a request that cannot be processed as sent
+func Invalid(message string) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeValidation, Message: message}
+}
+
+// Unauthorized is a failed authentication
+func Unauthorized(message string) *Error {
+	return &Error{Status: http.StatusUnauthorized, Code: CodeAuthentication, Message: message}
+}
+
+// Forbidden is an authenticated request that is not allowed
+func Forbidden(message string) *Error {
+	return &Error{Status: http.StatusForbidden, Code: CodeAuthorization, Message: message}
+}
+
+func NotFound(message string) *Error {
+	return &Error{Status: http.StatusNotFound, Code: CodeNotFound, Message: message}
+}
+
+// Conflict is a resource that already exists or is in a state that does not allow the request
+func Conflict(message string) *Error {
+	return &Error{Status: http.StatusConflict, Code: CodeConflict, Message: message}
+}
+
+// TooLarge is an upload over the size allowed for it
+func TooLarge(message string) *Error {
+	return &Error{Status: http.StatusRequestEntityTooLarge, Code: CodeUploadSize, Message: message}
+}
+
+// UnsupportedType is an upload that is not one of the file types accepted for it
+func UnsupportedType(message string) *Error {
+	return &Error{Status: http.StatusUnsupportedMediaType, Code: CodeFileType, Message: message}
+}
+
+// TooManyRequests asks the client to wait retryAfter before trying again, at least a second
+func TooManyRequests(message string, retryAfter time.Duration) *Error {
+	return &Error{Status: http.StatusTooManyRequests, Code: CodeRateLimit, Message: message, RetryAfter: max(retryAfter, time.Second)}
+}
+
+// Wrap gives err the message it is answered with when it is not one of the typed errors.
+// Typed errors are returned as they are.
+func Wrap(err error, message string) error {
+	if err == nil || resolve(err) != nil {
+		return err
+	}
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Err: err}
+}
+
+// ValidationError lists what is wrong with each field of a request
+type ValidationError struct {
+	Fields map[string][]string
+}
+
+func (e *ValidationError) Error() string {
+	return "input validation failed"
+}
+
+// Add records a problem with field
+func (e *ValidationError) Add(field string, problems ...string) {
+	if e.Fields == nil {
+		e.Fields = make(map[string][]string)
+	}
+	e.Fields[field] = append(e.Fields[field], problems...)
+}
+
+// ErrOrNil returns e only when a problem was added, so callers can return it as is
+func (e *ValidationError) ErrOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// resolve returns the typed error in the chain of err, or nil if there is none
+func resolve(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		return &Error{
+			Status:  http.StatusBadRequest,
+			Code:    CodeValidation,
+			Message: "Input validation failed",
+			Details: map[string]any{"fields": invalid.Fields},
+		}
+	}
+	return nil
+}
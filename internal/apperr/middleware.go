@@ -0,0 +1,98 @@
+package apperr
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/models"
+)
+
+// RequestIDHeader carries the request id in both directions,
+// an id set by a proxy in front of the server is kept
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Middleware gives every request an id and renders the error reported by the handlers.
+// It has to be the first middleware so it sees the errors of all the others.
+// A panic is rendered as an internal error, the stack only goes to the log.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set("requestID", requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		defer func() {
+			if r := recover(); r != nil {
+				// the client went away, there is nobody to answer
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+				c.Error(fmt.Errorf("panic: %v\n%s", r, debug.Stack()))
+				c.Abort()
+				Flush(c)
+			}
+		}()
+
+		c.Next()
+		Flush(c)
+	}
+}
+
+// Flush renders the last error reported on c unless a response was written already.
+// Middleware does this once the chain is done, it only has to be called by code that
+// needs the final status before that.
+func Flush(c *gin.Context) {
+	if c.Writer.Written() || len(c.Errors) == 0 {
+		return
+	}
+
+	err := c.Errors.Last().Err
+	appErr := resolve(err)
+	if appErr == nil {
+		appErr = &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Internal server error", Err: err}
+	}
+	if appErr.Status >= http.StatusInternalServerError {
+		log.Printf("Error: %s %s failed (request %s): %v", c.Request.Method, c.Request.URL.Path, c.GetString("requestID"), err)
+	}
+
+	details := appErr.Details
+	if appErr.RetryAfter > 0 {
+		seconds := int(math.Ceil(appErr.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		details = map[string]any{"retry_after": seconds}
+		for key, value := range appErr.Details {
+			details[key] = value
+		}
+	}
+
+	c.JSON(appErr.Status, models.ErrorResponse{
+		Error: models.ErrorDetail{
+			Code:      appErr.Code,
+			Message:   appErr.Message,
+			Details:   details,
+			RequestID: c.GetString("requestID"),
+			Timestamp: time.Now().UTC(),
+		},
+	})
+}
+
+// newRequestID returns a random version 4 uuid with the req_ prefix of the documented ids
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("req_%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
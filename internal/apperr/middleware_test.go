@@ -0,0 +1,139 @@
+package apperr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var errThingNotFound = NotFound("thing not found")
+
+func TestMiddleware_RendersErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/sentinel", func(c *gin.Context) {
+		c.Error(fmt.Errorf("lookup: %w", errThingNotFound))
+	})
+	router.GET("/validation", func(c *gin.Context) {
+		invalid := &ValidationError{}
+		invalid.Add("email", "is required")
+		c.Error(invalid.ErrOrNil())
+	})
+	router.GET("/internal", func(c *gin.Context) {
+		c.Error(Wrap(errors.New("connection refused"), "Failed to load thing"))
+	})
+	router.GET("/untyped", func(c *gin.Context) {
+		c.Error(errors.New("connection refused"))
+	})
+	router.GET("/limited", func(c *gin.Context) {
+		c.Error(TooManyRequests("Too many requests, please try again later", 1500*time.Millisecond))
+		c.Abort()
+	})
+
+	router.GET("/upload", func(c *gin.Context) {
+		c.Error(TooLarge("file must be at most 1 MB"))
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		var thing *struct{ name string }
+		c.String(http.StatusOK, thing.name)
+	})
+
+	tests := []struct {
+		path        string
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+	}{
+		{"/sentinel", http.StatusNotFound, CodeNotFound, "thing not found"},
+		{"/validation", http.StatusBadRequest, CodeValidation, "Input validation failed"},
+		{"/internal", http.StatusInternalServerError, CodeInternal, "Failed to load thing"},
+		{"/untyped", http.StatusInternalServerError, CodeInternal, "Internal server error"},
+		{"/limited", http.StatusTooManyRequests, CodeRateLimit, "Too many requests, please try again later"},
+		{"/upload", http.StatusRequestEntityTooLarge, CodeUploadSize, "file must be at most 1 MB"},
+		{"/panic", http.StatusInternalServerError, CodeInternal, "Internal server error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+
+			var res models.ErrorResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, tt.wantCode, res.Error.Code)
+			assert.Equal(t, tt.wantMessage, res.Error.Message)
+			assert.Equal(t, w.Header().Get(RequestIDHeader), res.Error.RequestID)
+			assert.False(t, res.Error.Timestamp.IsZero())
+
+			// the cause of an internal error stays in the log
+			assert.NotContains(t, w.Body.String(), "connection refused")
+		})
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/validation", nil)
+	router.ServeHTTP(w, req)
+	var res models.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, map[string]any{"email": []any{"is required"}}, res.Error.Details["fields"])
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/limited", nil)
+	router.ServeHTTP(w, req)
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, float64(2), res.Error.Details["retry_after"])
+}
+
+func TestMiddleware_RequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/ok", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"request_id": c.GetString("requestID")})
+	})
+
+	// an id from a proxy in front is kept
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/ok", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "abc-123", w.Header().Get(RequestIDHeader))
+
+	// anything else gets a new one
+	for _, sent := range []string{"", "bad id\n", strings.Repeat("a", 200)} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest(http.MethodGet, "/ok", nil)
+		req.Header.Set(RequestIDHeader, sent)
+		router.ServeHTTP(w, req)
+
+		id := w.Header().Get(RequestIDHeader)
+		assert.Regexp(t, `^req_[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, id)
+		assert.Contains(t, w.Body.String(), id)
+	}
+}
+
+func TestWrap_KeepsTypedErrors(t *testing.T) {
+	assert.Same(t, errThingNotFound, Wrap(errThingNotFound, "Failed to load thing"))
+	assert.Nil(t, Wrap(nil, "Failed to load thing"))
+
+	wrapped := Wrap(errors.New("connection refused"), "Failed to load thing")
+	var appErr *Error
+	assert.True(t, errors.As(wrapped, &appErr))
+	assert.Equal(t, http.StatusInternalServerError, appErr.Status)
+	assert.EqualError(t, errors.Unwrap(wrapped), "connection refused")
+}
@@ -0,0 +1,37 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionImpersonationStart  = "impersonation.start"
+	ActionImpersonatedRequest = "impersonation.request"
+)
+
+// Entry is one thing somebody did, ActorID is who did it and SubjectID who it was done as or to
+type Entry struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Action    string             `bson:"action"`
+	ActorID   string             `bson:"actor_id"`
+	SubjectID string             `bson:"subject_id,omitempty"`
+	SessionID string             `bson:"session_id,omitempty"`
+	// the request, for entries recorded by a middleware
+	Method    string `bson:"method,omitempty"`
+	Path      string `bson:"path,omitempty"`
+	Status    int    `bson:"status,omitempty"`
+	IP        string `bson:"ip,omitempty"`
+	UserAgent string `bson:"user_agent,omitempty"`
+	// Details holds what else is worth keeping, such as the reason for an impersonation
+	Details   map[string]string `bson:"details,omitempty"`
+	CreatedAt time.Time         `bson:"created_at"`
+}
+
+// Log keeps entries for good, there is no way to change or remove one
+type Log interface {
+	Record(ctx context.Context, entry Entry) error
+}
@@ -0,0 +1,32 @@
+package audit
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryLog keeps entries in memory, for tests and for running without a database
+type MemoryLog struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{}
+}
+
+func (l *MemoryLog) Record(ctx context.Context, entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+// Entries returns a copy of everything recorded so far, oldest first
+func (l *MemoryLog) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Entry(nil), l.entries...)
+}
@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/mail"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ChangePasswordService sets a new password and signs the user out of every other session
+func (s *Service) ChangePasswordService(ctx context.Context, userId string, sessionID string, req *models.ChangePasswordRequest) error {
+	user, err := s.getUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	// checked first so a weak password does not use up a two factor code
+	invalid := &apperr.ValidationError{}
+	if err := s.checkPassword(ctx, invalid, req.Password); err != nil {
+		return err
+	}
+	if err := invalid.ErrOrNil(); err != nil {
+		return err
+	}
+
+	if err := s.reauthenticate(ctx, user, req.Reauthentication); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hashPassword(req.Password)
+	if err != nil {
+		return fmt.Errorf("service: error hashing password %v", err)
+	}
+	if err := s.repo.UpdateUserPassword(ctx, userId, hashedPassword); err != nil {
+		return fmt.Errorf("service: error updating password %v", err)
+	}
+
+	return s.revokeOtherSessions(ctx, userId, sessionID)
+}
+
+// ChangeEmailService mails a confirmation link to the new address,
+// the email only changes once ConfirmEmailChangeService sees that link
+func (s *Service) ChangeEmailService(ctx context.Context, userId string, req *models.ChangeEmailRequest) error {
+	user, err := s.getUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	invalid := &apperr.ValidationError{}
+	validateEmail(invalid, req.Email)
+	if strings.EqualFold(req.Email, user.Email) {
+		invalid.Add("email", "must differ from the current email")
+	}
+	if err := invalid.ErrOrNil(); err != nil {
+		return err
+	}
+
+	if err := s.reauthenticate(ctx, user, req.Reauthentication); err != nil {
+		return err
+	}
+
+	existing, err := s.repo.GetUserByEmail(ctx, req.Email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("service: error checking existing email %v", err)
+	}
+	if existing != nil {
+		return ErrEmailTaken
+	}
+
+	// over the limit nothing is sent, the user can ask again in a minute
+	if !s.allowMail(ctx, "change-email", req.Email) {
+		return nil
+	}
+
+	claims := models.EmailChangeClaims{
+		UserID:   userId,
+		OldEmail: user.Email,
+		Email:    req.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.EMAIL_CHANGE_DURATION)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audienceEmailChange},
+		},
+	}
+	token, err := s.signToken(claims)
+	if err != nil {
+		return err
+	}
+
+	// like a login link it opens the web app, which posts the token
+	link := fmt.Sprintf("%s/confirm-email-change?token=%s", s.publicURL, url.QueryEscape(token))
+	msg := mail.Message{
+		To:      req.Email,
+		Subject: "Confirm your new Uriel email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below within the next 24 hours to use this address for your Uriel account:\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this mail.", user.Username, link),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("service: error sending email change mail %v", err)
+	}
+
+	return nil
+}
+
+// ConfirmEmailChangeService changes the email to the address the link was mailed to.
+// The old address is told about the change.
+func (s *Service) ConfirmEmailChangeService(ctx context.Context, token string) error {
+	claims := &models.EmailChangeClaims{}
+	if err := s.parseToken(token, claims, audienceEmailChange); err != nil {
+		return ErrInvalidEmailChange
+	}
+
+	// somebody may have registered the address since the link was sent
+	existing, err := s.repo.GetUserByEmail(ctx, claims.Email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("service: error checking existing email %v", err)
+	}
+	if existing != nil && existing.ID.Hex() != claims.UserID {
+		return ErrEmailTaken
+	}
+
+	changed, err := s.repo.UpdateUserEmail(ctx, claims.UserID, claims.OldEmail, claims.Email)
+	if err != nil {
+		return fmt.Errorf("service: error updating email %v", err)
+	}
+	if !changed {
+		return ErrInvalidEmailChange
+	}
+
+	msg := mail.Message{
+		To:      claims.OldEmail,
+		Subject: "Your Uriel email address was changed",
+		Body: fmt.Sprintf("Hi,\n\nThe email address of your Uriel account was changed to %s. "+
+			"If you did not do this, reset your password and contact your workspace admin.", claims.Email),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		log.Printf("Warning: could not tell %s about the email change of user %s: %v", claims.OldEmail, claims.UserID, err)
+	}
+
+	return nil
+}
+
+// ChangeUsernameService renames the user. The previous username stays an alias
+// for config.USERNAME_ALIAS_DURATION, it still logs in and nobody else can take it.
+func (s *Service) ChangeUsernameService(ctx context.Context, userId string, req *models.ChangeUsernameRequest) error {
+	user, err := s.getUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	invalid := &apperr.ValidationError{}
+	validateUsername(invalid, req.Username)
+	if req.Username == user.Username {
+		invalid.Add("username", "must differ from the current username")
+	}
+	if err := invalid.ErrOrNil(); err != nil {
+		return err
+	}
+
+	if err := s.reauthenticate(ctx, user, req.Reauthentication); err != nil {
+		return err
+	}
+
+	taken, err := s.usernameTaken(ctx, req.Username, userId)
+	if err != nil {
+		return fmt.Errorf("service: error checking existing username %v", err)
+	}
+	if taken {
+		return ErrUsernameTaken
+	}
+
+	// only changing the case keeps the name, so there is nothing to hold on to
+	renamed := !strings.EqualFold(req.Username, user.Username)
+	if renamed {
+		// saved ahead of the rename so the old name is never free in between
+		now := time.Now().UTC()
+		alias := models.UsernameAlias{
+			ID:        primitive.NewObjectID(),
+			Username:  user.Username,
+			UserID:    userId,
+			CreatedAt: now,
+			ExpiresAt: now.Add(config.USERNAME_ALIAS_DURATION),
+		}
+		if err := s.aliasRepo.SaveUsernameAlias(ctx, alias); err != nil {
+			return fmt.Errorf("service: error saving username alias %v", err)
+		}
+	}
+
+	if err := s.repo.UpdateUsername(ctx, userId, req.Username); err != nil {
+		// the user keeps the old name, it must not stay reserved for them twice
+		if renamed {
+			if err := s.aliasRepo.DeleteUsernameAlias(ctx, user.Username); err != nil {
+				log.Printf("Warning: could not remove username alias %s of user %s: %v", user.Username, userId, err)
+			}
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrUsernameTaken
+		}
+		return fmt.Errorf("service: error updating username %v", err)
+	}
+
+	// taking back a previous username ends its alias
+	if renamed {
+		if err := s.aliasRepo.DeleteUsernameAlias(ctx, req.Username); err != nil {
+			return fmt.Errorf("service: error removing username alias %v", err)
+		}
+	}
+
+	return nil
+}
+
+// usernameTaken tells whether the username belongs to someone other than userId,
+// either as their username or as an alias that has not expired
+func (s *Service) usernameTaken(ctx context.Context, username string, userId string) (bool, error) {
+	existing, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, err
+	}
+	if existing != nil && existing.ID.Hex() != userId {
+		return true, nil
+	}
+
+	alias, err := s.aliasRepo.GetUsernameAlias(ctx, username)
+	if err != nil {
+		return false, err
+	}
+	return alias != nil && alias.UserID != userId, nil
+}
+
+// userByAlias returns the user a previous username belongs to, or nil
+func (s *Service) userByAlias(ctx context.Context, username string) (*models.User, error) {
+	alias, err := s.aliasRepo.GetUsernameAlias(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving username alias %v", err)
+	}
+	if alias == nil {
+		return nil, nil
+	}
+
+	user, err := s.repo.GetUserById(ctx, alias.UserID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	return user, nil
+}
+
+// reauthenticate checks the current password or a two factor code before a credential is changed.
+// Failures count towards a lockout like failed logins do.
+func (s *Service) reauthenticate(ctx context.Context, user *models.User, req models.Reauthentication) error {
+	lockoutKey := "reauth:" + user.ID.Hex()
+	if err := s.checkLockout(ctx, lockoutKey); err != nil {
+		return err
+	}
+
+	switch {
+	case req.Code != "" && user.MFA.Enabled:
+		used, err := s.useMFACode(ctx, user, req.Code)
+		if err != nil {
+			return err
+		}
+		if !used {
+			s.failLockout(ctx, lockoutKey)
+			return ErrReauthenticationFailed
+		}
+
+	// accounts created by single sign-on have no password
+	case req.CurrentPassword != "" && user.Password != "":
+		match, err := s.hasher.Verify(req.CurrentPassword, user.Password)
+		if err != nil {
+			return fmt.Errorf("service: error verifying password %v", err)
+		}
+		if !match {
+			s.failLockout(ctx, lockoutKey)
+			return ErrReauthenticationFailed
+		}
+
+	default:
+		return ErrReauthenticationRequired
+	}
+
+	s.resetLockout(ctx, lockoutKey)
+	return nil
+}
+
+// revokeOtherSessions ends every session of the user except currentSessionID
+func (s *Service) revokeOtherSessions(ctx context.Context, userId string, currentSessionID string) error {
+	sessions, err := s.sessionRepo.ListSessions(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("service: error listing sessions %v", err)
+	}
+
+	for _, session := range sessions {
+		if session.ID == currentSessionID {
+			continue
+		}
+		if err := s.revokeSession(ctx, session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) getUser(ctx context.Context, userId string) (*models.User, error) {
+	user, err := s.repo.GetUserById(ctx, userId)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
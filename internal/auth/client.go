@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/models"
+)
+
+// clientInfo describes where a request came from, it is recorded on the session of a login
+type clientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+type clientInfoKey struct{}
+
+// withClient adds the client of a gin request to ctx
+func withClient(ctx context.Context, c *gin.Context) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, clientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+}
+
+func clientFrom(ctx context.Context) clientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(clientInfo)
+	return info
+}
+
+// browsers in the order they have to be checked, most user agents claim to be several of them
+var browserPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/([\d.]+)`)},
+	{"Opera", regexp.MustCompile(`OPR/([\d.]+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/([\d.]+)`)},
+	{"Safari", regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+}
+
+var osPatterns = []struct {
+	name   string
+	prefix string
+}{
+	{"Android", "Android"},
+	{"iOS", "iPhone"},
+	{"iOS", "iPad"},
+	{"Windows", "Windows"},
+	{"macOS", "Mac OS X"},
+	{"ChromeOS", "CrOS"},
+	{"Linux", "Linux"},
+}
+
+// parseUserAgent makes a best guess at the device of a user agent, it is only shown to the user
+func parseUserAgent(userAgent string) models.DeviceInfo {
+	info := models.DeviceInfo{Platform: "api", OS: "unknown", Browser: "unknown"}
+
+	for _, os := range osPatterns {
+		if strings.Contains(userAgent, os.prefix) {
+			info.OS = os.name
+			break
+		}
+	}
+
+	for _, browser := range browserPatterns {
+		if match := browser.pattern.FindStringSubmatch(userAgent); match != nil {
+			info.Platform = "web"
+			info.Browser = browser.name
+			info.Version = match[1]
+			break
+		}
+	}
+
+	return info
+}
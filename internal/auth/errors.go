@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/palSagnik/uriel/internal/apperr"
+)
+
+var (
+	ErrInvalidCredentials  = apperr.Unauthorized("invalid username or password")
+	ErrInvalidRefreshToken = apperr.Unauthorized("invalid or expired refresh token")
+	ErrRefreshTokenReused  = apperr.Unauthorized("refresh token has already been used")
+	ErrTokenRevoked        = apperr.Unauthorized("token has been revoked")
+	ErrInvalidResetToken   = apperr.Invalid("invalid or expired password reset token")
+	ErrInvalidMagicLink    = apperr.Unauthorized("invalid or expired login link")
+	ErrInvalidVerification = apperr.Invalid("invalid or expired email verification link")
+	ErrEmailNotVerified    = apperr.Forbidden("email address has not been verified")
+	ErrUserNotFound        = apperr.NotFound("user not found")
+	ErrUsernameTaken       = apperr.Conflict("username already exists")
+	ErrEmailTaken          = apperr.Conflict("email already exists")
+	ErrMFAAlreadyEnabled   = apperr.Conflict("two factor authentication is already enabled")
+	ErrMFANotEnrolled      = apperr.Invalid("two factor authentication has not been enrolled")
+	ErrInvalidMFACode      = apperr.Invalid("invalid two factor authentication code")
+	ErrInvalidMFAToken     = apperr.Unauthorized("invalid or expired mfa token")
+	ErrOIDCNotConfigured   = apperr.NotFound("single sign-on is not configured")
+	ErrOIDCEmailRequired   = apperr.Forbidden("identity provider did not share an email address")
+	ErrOIDCAccountConflict = apperr.Forbidden("an account with this email already exists")
+
+	ErrInvalidPersonalAccessToken  = apperr.Unauthorized("invalid or expired personal access token")
+	ErrPersonalAccessTokenNotFound = apperr.NotFound("personal access token not found")
+	ErrInvalidTokenName            = apperr.Invalid("token name must be between 1 and 100 characters")
+	ErrInvalidScope                = apperr.Invalid("scopes must be permissions granted to your role")
+	ErrInvalidTokenExpiry          = apperr.Invalid("expires_in_days must be between 1 and 365")
+
+	ErrSessionNotFound = apperr.NotFound("session not found")
+
+	ErrReauthenticationRequired = apperr.Forbidden("current password or a two factor code is required")
+	ErrReauthenticationFailed   = apperr.Forbidden("current password or two factor code is incorrect")
+	ErrInvalidEmailChange       = apperr.Invalid("invalid or expired email change link")
+
+	ErrInvalidGuestInvite   = apperr.Invalid("invalid or expired invite")
+	ErrGuestInviteNotFound  = apperr.NotFound("invite not found")
+	ErrInvalidInviteRooms   = apperr.Invalid("rooms must list between 1 and 50 room ids")
+	ErrInvalidInviteExpiry  = apperr.Invalid("expires_in_hours must be between 1 and 720")
+	ErrInvalidInviteMaxUses = apperr.Invalid("max_uses cannot be negative")
+
+	ErrCannotImpersonate = apperr.Forbidden("this user cannot be impersonated")
+
+	// not logged in, or the auth middleware did not run
+	errLoginRequired = apperr.Unauthorized("Please login")
+)
+
+// accountLocked is returned while an account is locked after too many failed attempts
+func accountLocked(retryAfter time.Duration) error {
+	return apperr.TooManyRequests("too many failed login attempts, account is temporarily locked", retryAfter)
+}
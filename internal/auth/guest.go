@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	guestIDPrefix      = "guest_"
+	maxInviteRooms     = 50
+	maxRoomIDLength    = 64
+	maxDisplayNameSize = 50
+)
+
+// CreateGuestInviteService creates a link for visitors to join the rooms of the request without an account
+func (s *Service) CreateGuestInviteService(ctx context.Context, userId string, req *models.CreateGuestInviteRequest) (*models.GuestInviteResponse, error) {
+	rooms := []string{}
+	for _, room := range req.Rooms {
+		room = strings.TrimSpace(room)
+		if room == "" || len(room) > maxRoomIDLength {
+			return nil, ErrInvalidInviteRooms
+		}
+		if !slices.Contains(rooms, room) {
+			rooms = append(rooms, room)
+		}
+	}
+	if len(rooms) == 0 || len(rooms) > maxInviteRooms {
+		return nil, ErrInvalidInviteRooms
+	}
+
+	duration := config.DEFAULT_GUEST_INVITE_DURATION
+	if req.ExpiresInHours != 0 {
+		duration = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if duration <= 0 || duration > config.MAX_GUEST_INVITE_DURATION {
+		return nil, ErrInvalidInviteExpiry
+	}
+
+	if req.MaxUses < 0 {
+		return nil, ErrInvalidInviteMaxUses
+	}
+
+	token, err := generateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	invite := models.GuestInvite{
+		ID:        primitive.NewObjectID(),
+		TokenHash: hashToken(token),
+		CreatedBy: userId,
+		Rooms:     rooms,
+		MaxUses:   req.MaxUses,
+		ExpiresAt: now.Add(duration),
+		CreatedAt: now,
+	}
+	if err := s.guestRepo.CreateGuestInvite(ctx, invite); err != nil {
+		return nil, fmt.Errorf("service: error storing guest invite %v", err)
+	}
+
+	return &models.GuestInviteResponse{
+		ID:        invite.ID.Hex(),
+		Rooms:     invite.Rooms,
+		MaxUses:   invite.MaxUses,
+		ExpiresAt: invite.ExpiresAt,
+		Token:     token,
+		URL:       fmt.Sprintf("%s/join?invite=%s", s.publicURL, url.QueryEscape(token)),
+	}, nil
+}
+
+// DeleteGuestInviteService stops the invite from being used, guests that already joined lose access as well
+func (s *Service) DeleteGuestInviteService(ctx context.Context, id string) error {
+	deleted, err := s.guestRepo.DeleteGuestInvite(ctx, id)
+	if err != nil {
+		return fmt.Errorf("service: error deleting guest invite %v", err)
+	}
+	if !deleted {
+		return ErrGuestInviteNotFound
+	}
+	return nil
+}
+
+// JoinAsGuestService lets a visitor in through an invite. The guest gets a single access token
+// limited to the rooms of the invite, there is no refresh token and no user record.
+func (s *Service) JoinAsGuestService(ctx context.Context, inviteToken string, displayName string) (*models.GuestJoinResponse, error) {
+	displayName = strings.TrimSpace(displayName)
+	if n := utf8.RuneCountInString(displayName); n == 0 || n > maxDisplayNameSize || strings.ContainsFunc(displayName, unicode.IsControl) {
+		return nil, &apperr.ValidationError{Fields: map[string][]string{
+			"display_name": {fmt.Sprintf("must be between 1 and %d characters", maxDisplayNameSize)},
+		}}
+	}
+
+	now := time.Now().UTC()
+	invite, err := s.guestRepo.UseGuestInvite(ctx, hashToken(inviteToken), now)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving guest invite %v", err)
+	}
+	if invite == nil {
+		return nil, ErrInvalidGuestInvite
+	}
+
+	id, err := generateOpaqueToken(12)
+	if err != nil {
+		return nil, err
+	}
+
+	guest := models.Guest{
+		ID:          guestIDPrefix + id,
+		DisplayName: displayName,
+		InviteID:    invite.ID.Hex(),
+		Rooms:       invite.Rooms,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(config.GUEST_TOKEN_DURATION),
+	}
+	if err := s.guestRepo.CreateGuest(ctx, guest); err != nil {
+		return nil, fmt.Errorf("service: error storing guest %v", err)
+	}
+
+	token, err := s.generateGuestToken(&guest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.GuestJoinResponse{
+		Message:     "Joined as guest",
+		GuestID:     guest.ID,
+		DisplayName: guest.DisplayName,
+		Rooms:       guest.Rooms,
+		Token:       token,
+		ExpiresIn:   int64(config.GUEST_TOKEN_DURATION.Seconds()),
+	}, nil
+}
+
+// generateGuestToken is an access token with the guest role that only lasts as long as the guest
+func (s *Service) generateGuestToken(guest *models.Guest) (string, error) {
+	jti, err := generateOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := models.Claims{
+		UserID:   guest.ID,
+		Username: guest.DisplayName,
+		Role:     config.GUEST,
+		Rooms:    guest.Rooms,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(guest.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(guest.CreatedAt),
+			NotBefore: jwt.NewNumericDate(guest.CreatedAt),
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audienceAccess},
+			ID:        jti,
+		},
+	}
+
+	return s.signToken(claims)
+}
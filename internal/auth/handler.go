@@ -2,12 +2,16 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/apperr"
 	"github.com/palSagnik/uriel/internal/models"
+	"github.com/palSagnik/uriel/internal/oidc"
 )
 
 type Handler struct {
@@ -21,34 +25,27 @@ func NewHandler(authService *Service) *Handler {
 // RegisterUser is a Gin handler for User registration.
 // It works between the HTTP request and the AuthService.
 func (h *Handler) RegisterUser(c *gin.Context) {
-	var req *models.RegisterRequest
+	var req models.RegisterRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Invalid(err.Error()))
 		return
 	}
 
 	if req.Password != req.Confirm {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "password and confirm password fields do not match"})
+		c.Error(&apperr.ValidationError{Fields: map[string][]string{
+			"confirm": {"does not match password"},
+		}})
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(c, 10*time.Second)
 	defer cancel()
 
-	newUser, err := h.service.RegisterUserService(ctx, req)
+	newUser, err := h.service.RegisterUserService(ctx, &req)
 
 	if err != nil {
-		if err.Error() == "email already exists" || err.Error() == "username already exists" {
-			c.JSON(http.StatusConflict, models.FailedResponse{
-				Error: err.Error(),
-			})
-			return
-		}
-    
-		c.JSON(http.StatusInternalServerError, models.FailedResponse{
-			Error: "Failed to register user",
-		})
+		c.Error(apperr.Wrap(err, "Failed to register user"))
 		return
 	}
 
@@ -60,37 +57,763 @@ func (h *Handler) RegisterUser(c *gin.Context) {
 }
 
 func (h *Handler) LoginUser(c *gin.Context) {
-	var req *models.LoginRequest
+	var req models.LoginRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Invalid(err.Error()))
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(c, 10*time.Second)
 	defer cancel()
+	ctx = withClient(ctx, c)
 
-	token, userId, err := h.service.LoginUserService(ctx, req.Username, req.Password)
+	identifier := req.Username
+	if identifier == "" {
+		identifier = req.Email
+	}
+	if identifier == "" || req.Password == "" {
+		c.Error(apperr.Invalid("username or email and password are required"))
+		return
+	}
+
+	result, err := h.service.LoginUserService(ctx, identifier, req.Password)
 	if err != nil {
-		if err.Error() == "invalid username or password" {
-			c.JSON(http.StatusUnauthorized, models.FailedResponse{
-				Error: err.Error(),
-			})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, models.FailedResponse{
-			Error: "Login failed due to internal server error",
-		})
+		c.Error(apperr.Wrap(err, "Login failed due to internal server error"))
 		return
 	}
 
 	// send it back
-	authHeader := fmt.Sprintf("Bearer %v", token)
+	writeLoginResult(c, result)
+}
+
+// writeLoginResult sends the tokens of a login, or the MFA token when the second factor is still missing
+func writeLoginResult(c *gin.Context, result *models.LoginResult) {
+	if result.Tokens == nil {
+		c.JSON(http.StatusOK, models.LoginResponse{
+			Message:     "Two factor authentication required",
+			UserID:      result.UserID,
+			MFARequired: true,
+			MFAToken:    result.MFAToken,
+		})
+		return
+	}
+
+	writeTokens(c, "User login successful", result.Tokens)
+}
+
+// writeTokens sends a token pair as the response of a login or refresh
+func writeTokens(c *gin.Context, message string, tokens *models.TokenPair) {
+	authHeader := fmt.Sprintf("Bearer %v", tokens.AccessToken)
 	c.Header("Authorization", authHeader)
 
 	c.JSON(http.StatusOK, models.LoginResponse{
-    Message: "User login successful",
-		Token:   token,
-		UserID:  userId,
+		Message:               message,
+		Token:                 tokens.AccessToken,
+		RefreshToken:          tokens.RefreshToken,
+		ExpiresIn:             int64(time.Until(tokens.ExpiresAt).Seconds()),
+		UserID:                tokens.UserID,
+		Role:                  tokens.Role,
+		MFAEnrollmentRequired: tokens.MFAEnrollmentRequired,
+	})
+}
+
+// RefreshToken rotates a refresh token and returns a new token pair
+func (h *Handler) RefreshToken(c *gin.Context) {
+	var req models.RefreshRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	if req.RefreshToken == "" {
+		c.Error(apperr.Invalid("refresh_token is required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+	ctx = withClient(ctx, c)
+
+	tokens, err := h.service.RefreshTokens(ctx, req.RefreshToken)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Token refresh failed due to internal server error"))
+		return
+	}
+
+	writeTokens(c, "Token refreshed successfully", tokens)
+}
+
+// LogoutUser revokes the access token used for the request.
+// The refresh token can be sent in the body to end the session for good.
+func (h *Handler) LogoutUser(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	// the body is optional
+	var req models.LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	err := h.service.LogoutService(ctx, userID.(string), c.GetString("jti"), c.GetString("sessionID"), c.GetTime("tokenExpiresAt"), req.RefreshToken)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Logout failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User logout successful",
+	})
+}
+
+// ForgotPassword always answers the same way so it cannot be used to find out
+// which emails have an account
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	if req.Email == "" {
+		c.Error(apperr.Invalid("email is required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.ForgotPasswordService(ctx, req.Email); err != nil {
+		c.Error(apperr.Wrap(err, "Password reset failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "If an account exists for this email, a reset link has been sent",
+	})
+}
+
+// MagicLink mails a login link, it answers the same way whether the account exists or not
+func (h *Handler) MagicLink(c *gin.Context) {
+	var req models.MagicLinkRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	if req.Email == "" {
+		c.Error(apperr.Invalid("email is required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.MagicLinkService(ctx, req.Email); err != nil {
+		c.Error(apperr.Wrap(err, "Sending login link failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "If an account exists for this email, a login link has been sent",
+	})
+}
+
+// MagicLinkLogin exchanges the token of a login link for the same response as LoginUser
+func (h *Handler) MagicLinkLogin(c *gin.Context) {
+	var req models.MagicLinkLoginRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	if req.Token == "" {
+		c.Error(apperr.Invalid("token is required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+	ctx = withClient(ctx, c)
+
+	result, err := h.service.MagicLinkLoginService(ctx, req.Token)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Login failed due to internal server error"))
+		return
+	}
+
+	writeLoginResult(c, result)
+}
+
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	if req.Token == "" || req.Password == "" {
+		c.Error(apperr.Invalid("token and password are required"))
+		return
+	}
+
+	if req.Password != req.Confirm {
+		c.Error(&apperr.ValidationError{Fields: map[string][]string{
+			"confirm": {"does not match password"},
+		}})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.ResetPasswordService(ctx, req.Token, req.Password); err != nil {
+		c.Error(apperr.Wrap(err, "Password reset failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password has been reset, please login again",
+	})
+}
+
+// VerifyEmail is the target of the link mailed at registration
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.Error(apperr.Invalid("token is required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.VerifyEmailService(ctx, token); err != nil {
+		c.Error(apperr.Wrap(err, "Email verification failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email verified successfully",
+	})
+}
+
+func (h *Handler) ResendVerification(c *gin.Context) {
+	var req models.ResendVerificationRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	if req.Email == "" {
+		c.Error(apperr.Invalid("email is required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.ResendVerificationService(ctx, req.Email); err != nil {
+		c.Error(apperr.Wrap(err, "Sending verification mail failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "If this email belongs to an unverified account, a new link has been sent",
+	})
+}
+
+// EnrollMFA starts a TOTP enrollment for the logged in user
+func (h *Handler) EnrollMFA(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	enrollment, err := h.service.EnrollMFAService(ctx, userID.(string))
+	if err != nil {
+		c.Error(apperr.Wrap(err, "MFA enrollment failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMFA enables two factor authentication and returns the recovery codes
+func (h *Handler) ConfirmMFA(c *gin.Context) {
+	h.recoveryCodes(c, h.service.ConfirmMFAService)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the logged in user
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	h.recoveryCodes(c, h.service.RegenerateRecoveryCodesService)
+}
+
+func (h *Handler) recoveryCodes(c *gin.Context, generate func(context.Context, string, string) ([]string, error)) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	var req models.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	if req.Code == "" {
+		c.Error(apperr.Invalid("code is required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	codes, err := generate(ctx, userID.(string), req.Code)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Generating recovery codes failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// VerifyMFA is the second step of a login with two factor authentication
+func (h *Handler) VerifyMFA(c *gin.Context) {
+	var req models.MFAVerifyRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	if req.MFAToken == "" || req.Code == "" {
+		c.Error(apperr.Invalid("mfa_token and code are required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+	ctx = withClient(ctx, c)
+
+	tokens, err := h.service.VerifyMFAService(ctx, req.MFAToken, req.Code)
+	if err != nil {
+		// a wrong code fails the login, anywhere else it is a bad request
+		if errors.Is(err, ErrInvalidMFACode) {
+			err = apperr.Unauthorized(err.Error())
+		}
+		c.Error(apperr.Wrap(err, "Login failed due to internal server error"))
+		return
+	}
+
+	writeTokens(c, "User login successful", tokens)
+}
+
+// OIDCLogin sends the browser to the identity provider
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	authURL, err := h.service.OIDCLoginService()
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Single sign-on failed due to internal server error"))
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback is where the identity provider sends the browser back to
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	// the provider reports a denied or failed login in the query
+	if providerErr := c.Query("error"); providerErr != "" {
+		message := providerErr
+		if description := c.Query("error_description"); description != "" {
+			message = fmt.Sprintf("%v: %v", providerErr, description)
+		}
+		c.Error(apperr.Unauthorized(message))
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		c.Error(apperr.Invalid("state and code are required"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+	ctx = withClient(ctx, c)
+
+	result, err := h.service.OIDCCallbackService(ctx, state, code)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidState) || errors.Is(err, oidc.ErrInvalidIDToken) {
+			err = apperr.Unauthorized(err.Error())
+		}
+		c.Error(apperr.Wrap(err, "Single sign-on failed due to internal server error"))
+		return
+	}
+
+	writeLoginResult(c, result)
+}
+
+// JWKS publishes the public keys uriel tokens can be verified with.
+// It is empty while tokens are signed with the shared secret.
+func (h *Handler) JWKS(c *gin.Context) {
+	jwks := JWKS{Keys: []JWK{}}
+	if h.service.keyring != nil {
+		jwks = h.service.keyring.JWKS()
+	}
+
+	// verifiers may cache the keys, new keys are published well before they sign
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
+
+// CreatePersonalAccessToken creates a token for scripts, it is shown only in this response
+func (h *Handler) CreatePersonalAccessToken(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	var req models.CreatePersonalAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	token, err := h.service.CreatePersonalAccessTokenService(ctx, userID.(string), c.GetString("role"), c.GetBool("mfa"), &req)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Creating token failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusCreated, token)
+}
+
+func (h *Handler) ListPersonalAccessTokens(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	tokens, err := h.service.ListPersonalAccessTokensService(ctx, userID.(string))
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Listing tokens failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+	})
+}
+
+func (h *Handler) RevokePersonalAccessToken(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.RevokePersonalAccessTokenService(ctx, userID.(string), c.Param("id")); err != nil {
+		c.Error(apperr.Wrap(err, "Revoking token failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Token revoked",
+	})
+}
+
+// ListSessions lists the devices the user is signed in on, the one making the request is marked as current
+func (h *Handler) ListSessions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	sessions, err := h.service.ListSessionsService(ctx, userID.(string), c.GetString("sessionID"))
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Listing sessions failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+	})
+}
+
+// RevokeSession signs the user out on another device
+func (h *Handler) RevokeSession(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.RevokeSessionService(ctx, userID.(string), c.Param("id")); err != nil {
+		c.Error(apperr.Wrap(err, "Revoking session failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Session revoked",
+	})
+}
+
+// SignOutEverywhere ends every session of the user, including the one making the request
+func (h *Handler) SignOutEverywhere(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.SignOutEverywhereService(ctx, userID.(string)); err != nil {
+		c.Error(apperr.Wrap(err, "Sign out failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Signed out of all sessions",
+	})
+}
+
+// CreateGuestInvite creates a link visitors can join the given rooms with, the token is shown only in this response
+func (h *Handler) CreateGuestInvite(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	var req models.CreateGuestInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	invite, err := h.service.CreateGuestInviteService(ctx, userID.(string), &req)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Creating invite failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusCreated, invite)
+}
+
+func (h *Handler) DeleteGuestInvite(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.DeleteGuestInviteService(ctx, c.Param("id")); err != nil {
+		c.Error(apperr.Wrap(err, "Deleting invite failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Invite deleted",
+	})
+}
+
+// JoinAsGuest exchanges an invite and a display name for a guest token, no account is needed
+func (h *Handler) JoinAsGuest(c *gin.Context) {
+	var req models.GuestJoinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	joined, err := h.service.JoinAsGuestService(ctx, req.Invite, req.DisplayName)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Joining failed due to internal server error"))
+		return
+	}
+
+	c.Header("Authorization", fmt.Sprintf("Bearer %v", joined.Token))
+	c.JSON(http.StatusOK, joined)
+}
+
+// ChangePassword sets a new password, every other session of the user is signed out
+func (h *Handler) ChangePassword(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	if req.Password != req.Confirm {
+		c.Error(&apperr.ValidationError{Fields: map[string][]string{
+			"confirm": {"does not match password"},
+		}})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.ChangePasswordService(ctx, userID.(string), c.GetString("sessionID"), &req); err != nil {
+		c.Error(apperr.Wrap(err, "Password change failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password changed, other sessions have been signed out",
 	})
 }
+
+// ChangeEmail mails a confirmation link to the new address
+func (h *Handler) ChangeEmail(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	var req models.ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.ChangeEmailService(ctx, userID.(string), &req); err != nil {
+		c.Error(apperr.Wrap(err, "Email change failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "A confirmation link has been sent to the new email address",
+	})
+}
+
+// ConfirmEmailChange takes the token of the link mailed by ChangeEmail, it needs no login
+// since the link may be opened on another device
+func (h *Handler) ConfirmEmailChange(c *gin.Context) {
+	var req models.ConfirmEmailChangeRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.ConfirmEmailChangeService(ctx, req.Token); err != nil {
+		c.Error(apperr.Wrap(err, "Email change failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email address changed",
+	})
+}
+
+// ChangeUsername renames the user, the previous username keeps working for a while
+func (h *Handler) ChangeUsername(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	var req models.ChangeUsernameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.ChangeUsernameService(ctx, userID.(string), &req); err != nil {
+		c.Error(apperr.Wrap(err, "Username change failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Username changed",
+		"username": req.Username,
+	})
+}
+
+// Impersonate hands an admin a short lived token to act as another user with
+func (h *Handler) Impersonate(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(errLoginRequired)
+		return
+	}
+
+	var req models.ImpersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+	ctx = withClient(ctx, c)
+
+	actor := models.Actor{Subject: userID.(string), Username: c.GetString("username")}
+	res, err := h.service.ImpersonateService(ctx, actor, c.GetString("sessionID"), &req)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "Impersonation failed due to internal server error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
@@ -2,17 +2,40 @@ package auth
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/audit"
 	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/mail"
 	"github.com/palSagnik/uriel/internal/models"
+	"github.com/palSagnik/uriel/internal/oidc"
+	"github.com/palSagnik/uriel/internal/oidc/oidctest"
+	"github.com/palSagnik/uriel/internal/password"
+	"github.com/palSagnik/uriel/internal/ratelimit"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
@@ -30,6 +53,7 @@ func TestRegisterUser_Success(t *testing.T) {
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.POST("/auth/register", handler.RegisterUser)
 
 	// making the post request
@@ -67,6 +91,7 @@ func TestRegisterUser_UsernameExists(t *testing.T) {
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.POST("/auth/register", handler.RegisterUser)
 
 	// making the post request
@@ -86,9 +111,9 @@ func TestRegisterUser_UsernameExists(t *testing.T) {
 	assert.Equal(t, http.StatusConflict, w.Code)
 
 	// parsing the response
-	var res models.FailedResponse
+	var res models.ErrorResponse
 	json.Unmarshal(w.Body.Bytes(), &res)
-	assert.Equal(t, "username already exists", res.Error)
+	assert.Equal(t, "username already exists", res.Error.Message)
 
 	mockRepo.AssertExpectations(t)
 }
@@ -105,6 +130,7 @@ func TestRegisterUser_EmailExists(t *testing.T) {
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.POST("/auth/register", handler.RegisterUser)
 
 	// making the post request
@@ -124,9 +150,9 @@ func TestRegisterUser_EmailExists(t *testing.T) {
 	assert.Equal(t, http.StatusConflict, w.Code)
 
 	// parsing the response
-	var res models.FailedResponse
+	var res models.ErrorResponse
 	json.Unmarshal(w.Body.Bytes(), &res)
-	assert.Equal(t, "email already exists", res.Error)
+	assert.Equal(t, "email already exists", res.Error.Message)
 
 	mockRepo.AssertExpectations(t)
 }
@@ -143,6 +169,7 @@ func TestRegisterUser_Failure(t *testing.T) {
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.POST("/auth/register", handler.RegisterUser)
 
 	payload := models.RegisterRequest{
@@ -160,13 +187,96 @@ func TestRegisterUser_Failure(t *testing.T) {
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 
-	var res models.FailedResponse
+	var res models.ErrorResponse
 	json.Unmarshal(w.Body.Bytes(), &res)
-	assert.Equal(t, "Failed to register user", res.Error)
+	assert.Equal(t, "Failed to register user", res.Error.Message)
 	
 	mockRepo.AssertExpectations(t)
 }
 
+func TestRegisterUser_LostRace(t *testing.T) {
+	tests := []struct {
+		err     error
+		message string
+	}{
+		{ErrUsernameTaken, "username already exists"},
+		{ErrEmailTaken, "email already exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			mockRepo := new(MockAuthRepository)
+
+			// both were free when checked, somebody else registered them before the insert
+			mockRepo.On("GetUserByUsername", mock.Anything, "user").Return(nil, nil)
+			mockRepo.On("GetUserByEmail", mock.Anything, "user@example.com").Return(nil, nil)
+			mockRepo.On("CreateUser", mock.Anything, mock.AnythingOfType("models.User")).Return(tt.err)
+
+			service := NewService(mockRepo, []byte("test_jwt_here"))
+			handler := NewHandler(service)
+
+			router := gin.New()
+			router.Use(apperr.Middleware())
+			router.POST("/auth/register", handler.RegisterUser)
+
+			jsonPayload, _ := json.Marshal(models.RegisterRequest{
+				Username: "user",
+				Email:    "user@example.com",
+				Password: "password",
+				Confirm:  "password",
+			})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/auth/register", bytes.NewBuffer(jsonPayload))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusConflict, w.Code)
+
+			var res models.ErrorResponse
+			json.Unmarshal(w.Body.Bytes(), &res)
+			assert.Equal(t, tt.message, res.Error.Message)
+
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandlers_NullBody(t *testing.T) {
+	service := NewService(new(MockAuthRepository), []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	routes := map[string]gin.HandlerFunc{
+		"/auth/register":         handler.RegisterUser,
+		"/auth/login":            handler.LoginUser,
+		"/auth/refresh":          handler.RefreshToken,
+		"/auth/forgot-password":  handler.ForgotPassword,
+		"/auth/magic-link":       handler.MagicLink,
+		"/auth/magic-link/login": handler.MagicLinkLogin,
+		"/auth/reset-password":   handler.ResetPassword,
+		"/auth/mfa/verify":       handler.VerifyMFA,
+		"/auth/guest/join":       handler.JoinAsGuest,
+	}
+	for path, h := range routes {
+		router.POST(path, h)
+	}
+
+	// null is valid JSON, it leaves every field empty
+	for path := range routes {
+		t.Run(path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader("null"))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var res models.ErrorResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, apperr.CodeValidation, res.Error.Code)
+		})
+	}
+}
+
 func TestLoginPlayer_Success(t *testing.T) {
 	mockRepo := new(MockAuthRepository)
 
@@ -184,11 +294,14 @@ func TestLoginPlayer_Success(t *testing.T) {
 		IsOnline: false,
 	}
 	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).Return(nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
 
 	service := NewService(mockRepo, []byte("test_jwt_here"))
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.POST("/auth/login", handler.LoginUser)
 
 	payload := models.LoginRequest{
@@ -213,6 +326,137 @@ func TestLoginPlayer_Success(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
+func TestLoginPlayer_RehashesBcrypt(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	// accounts created before argon2id have bcrypt hashes
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Password: string(hashed_password),
+		Role:     config.USER,
+		Verified: true,
+	}
+	var upgraded string
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+	mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).
+		Run(func(args mock.Arguments) { upgraded = args.String(3) }).
+		Return(nil).Once()
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+
+	_, err := service.LoginUserService(context.Background(), "test", "correctpassword")
+	assert.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(upgraded, "$argon2id$"))
+	match, err := password.DefaultHasher().Verify("correctpassword", upgraded)
+	assert.NoError(t, err)
+	assert.True(t, match)
+
+	// the next login finds the argon2id hash and leaves it alone
+	_, err = service.LoginUserService(context.Background(), "test", "correctpassword")
+	assert.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestLoginPlayer_WithEmail(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := password.DefaultHasher().Hash("correctpassword")
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Email:    "test@test.com",
+		Password: hashed_password,
+		Role:     config.USER,
+		Verified: true,
+	}
+	// matching the case is left to the collation of the email index
+	mockRepo.On("GetUserByEmail", mock.Anything, "Test@Test.com").Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/login", handler.LoginUser)
+
+	// an email in the username field, and the email field on its own
+	for _, payload := range []models.LoginRequest{
+		{Username: " Test@Test.com ", Password: "correctpassword"},
+		{Email: "Test@Test.com", Password: "correctpassword"},
+	} {
+		jsonPayload, _ := json.Marshal(payload)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var res models.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		assert.NotEmpty(t, res.Token)
+		assert.Equal(t, parsedID.Hex(), res.UserID)
+	}
+
+	mockRepo.AssertNotCalled(t, "GetUserByUsername", mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestLoginPlayer_UsernameWithAt(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := password.DefaultHasher().Hash("correctpassword")
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	// taken before usernames were validated
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "old@name",
+		Email:    "test@test.com",
+		Password: hashed_password,
+		Role:     config.USER,
+		Verified: true,
+	}
+	mockRepo.On("GetUserByEmail", mock.Anything, "old@name").Return(nil, mongo.ErrNoDocuments)
+	mockRepo.On("GetUserByUsername", mock.Anything, "old@name").Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/login", handler.LoginUser)
+
+	jsonPayload, _ := json.Marshal(models.LoginRequest{Username: "old@name", Password: "correctpassword"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res models.LoginResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, parsedID.Hex(), res.UserID)
+	mockRepo.AssertExpectations(t)
+}
+
 func TestLoginPlayer_WrongPassword(t *testing.T) {
 	mockRepo := new(MockAuthRepository)
 
@@ -235,6 +479,7 @@ func TestLoginPlayer_WrongPassword(t *testing.T) {
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.POST("/auth/login", handler.LoginUser)
 
 	payload := models.LoginRequest{
@@ -250,9 +495,9 @@ func TestLoginPlayer_WrongPassword(t *testing.T) {
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 
-	var res models.FailedResponse
+	var res models.ErrorResponse
 	json.Unmarshal(w.Body.Bytes(), &res)
-	assert.Equal(t, "invalid username or password", res.Error)
+	assert.Equal(t, "invalid username or password", res.Error.Message)
 	
 	mockRepo.AssertExpectations(t)
 }
@@ -266,6 +511,7 @@ func TestLoginPlayer_UserDoesnotExist(t *testing.T) {
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.POST("/auth/login", handler.LoginUser)
 
 	payload := models.LoginRequest{
@@ -281,9 +527,2247 @@ func TestLoginPlayer_UserDoesnotExist(t *testing.T) {
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 
-	var res models.FailedResponse
+	var res models.ErrorResponse
 	json.Unmarshal(w.Body.Bytes(), &res)
-	assert.Equal(t, "invalid username or password", res.Error)
+	assert.Equal(t, "invalid username or password", res.Error.Message)
 	
 	mockRepo.AssertExpectations(t)
 }
+
+func TestRefreshToken_Success(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Password: string(hashed_password),
+		Role:     config.USER,
+	}
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).Return(nil)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/refresh", handler.RefreshToken)
+
+	// login first to get a refresh token
+	result, err := service.LoginUserService(context.Background(), "test", "correctpassword")
+	assert.NoError(t, err)
+	tokens := result.Tokens
+
+	payload := models.RefreshRequest{
+		RefreshToken: tokens.RefreshToken,
+	}
+	jsonPayload, _ := json.Marshal(payload)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res models.LoginResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.NotEmpty(t, res.Token)
+	assert.NotEmpty(t, res.RefreshToken)
+	assert.NotEqual(t, tokens.RefreshToken, res.RefreshToken)
+	assert.Equal(t, testId, res.UserID)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRefreshToken_ReuseRevokesFamily(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Password: string(hashed_password),
+		Role:     config.USER,
+	}
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).Return(nil)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/refresh", handler.RefreshToken)
+
+	result, err := service.LoginUserService(context.Background(), "test", "correctpassword")
+	assert.NoError(t, err)
+	tokens := result.Tokens
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(models.RefreshRequest{RefreshToken: token})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// first rotation is fine
+	w := refresh(tokens.RefreshToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var rotated models.LoginResponse
+	json.Unmarshal(w.Body.Bytes(), &rotated)
+
+	// replaying the old token is detected
+	w = refresh(tokens.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var res models.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, ErrRefreshTokenReused.Error(), res.Error.Message)
+
+	// and the token handed out by the first rotation is revoked with the family
+	w = refresh(rotated.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRefreshToken_Invalid(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/refresh", handler.RefreshToken)
+
+	jsonPayload, _ := json.Marshal(models.RefreshRequest{RefreshToken: "not-a-real-token"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var res models.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, ErrInvalidRefreshToken.Error(), res.Error.Message)
+}
+
+func TestLogoutUser_RevokesToken(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Password: string(hashed_password),
+		Role:     config.USER,
+	}
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).Return(nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, false).Return(nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/logout", service.AuthMiddleware(), handler.LogoutUser)
+	router.POST("/auth/refresh", handler.RefreshToken)
+
+	result, err := service.LoginUserService(context.Background(), "test", "correctpassword")
+	assert.NoError(t, err)
+	// the access token from before the refresh is still valid, it ends with the session too
+	earlier := result.Tokens.AccessToken
+	tokens, err := service.RefreshTokens(context.Background(), result.Tokens.RefreshToken)
+	assert.NoError(t, err)
+
+	jsonPayload, _ := json.Marshal(models.LogoutRequest{RefreshToken: tokens.RefreshToken})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/logout", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the access token no longer passes the middleware
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var res models.ErrorResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, "AUTHENTICATION_FAILED", res.Error.Code)
+	assert.Equal(t, ErrTokenRevoked.Error(), res.Error.Message)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+earlier)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// and neither does the refresh token
+	jsonPayload, _ = json.Marshal(models.RefreshRequest{RefreshToken: tokens.RefreshToken})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string
+		wantStatus int
+	}{
+		{name: "admin is allowed", role: config.ADMIN, wantStatus: http.StatusOK},
+		{name: "owner is allowed", role: config.OWNER, wantStatus: http.StatusOK},
+		{name: "user is forbidden", role: config.USER, wantStatus: http.StatusForbidden},
+		{name: "guest is forbidden", role: config.GUEST, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRole := func(c *gin.Context) {
+				c.Set("role", tt.role)
+				c.Next()
+			}
+
+			router := gin.New()
+			router.Use(apperr.Middleware())
+			router.POST("/admin", setRole, RequirePermission(PermManageAvatars), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/admin", nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+
+			if tt.wantStatus == http.StatusForbidden {
+				var res models.ErrorResponse
+				json.Unmarshal(w.Body.Bytes(), &res)
+				assert.Equal(t, "AUTHORIZATION_FAILED", res.Error.Code)
+				assert.NotEmpty(t, res.Error.Message)
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	setRole := func(c *gin.Context) {
+		c.Set("role", config.USER)
+		c.Next()
+	}
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.GET("/admin", setRole, RequireRole(config.ADMIN, config.OWNER), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/member", setRole, RequireRole(config.USER), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/admin", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/member", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestForgotPassword_UnknownEmail(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+	mockRepo.On("GetUserByEmail", mock.Anything, "nobody@example.com").Return(nil, nil)
+
+	var mailbox bytes.Buffer
+	service := NewService(mockRepo, []byte("test_jwt_here"), WithMailer(mail.NewLogMailer(&mailbox)))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/forgot-password", handler.ForgotPassword)
+
+	jsonPayload, _ := json.Marshal(models.ForgotPasswordRequest{Email: "nobody@example.com"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/forgot-password", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	// same answer as for a known email, but nothing is sent
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, mailbox.String())
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestResetPassword_Success(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("oldpassword"), bcrypt.DefaultCost)
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Email:    "test@test.com",
+		Password: string(hashed_password),
+		Role:     config.USER,
+	}
+	mockRepo.On("GetUserByEmail", mock.Anything, "test@test.com").Return(mockUser, nil)
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).Return(nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+	// capture the stored token so that consuming it can be mocked
+	var stored models.OneTimeToken
+	mockRepo.On("CreateOneTimeToken", mock.Anything, mock.AnythingOfType("models.OneTimeToken")).
+		Run(func(args mock.Arguments) { stored = args.Get(1).(models.OneTimeToken) }).
+		Return(nil)
+	mockRepo.On("ConsumeOneTimeToken", mock.Anything, models.TokenPurposePasswordReset, mock.MatchedBy(func(tokenHash string) bool {
+		return tokenHash == stored.TokenHash
+	})).Return(&stored, nil)
+	mockRepo.On("UpdateUserPassword", mock.Anything, testId, mock.MatchedBy(func(hash string) bool {
+		match, err := password.DefaultHasher().Verify("newpassword", hash)
+		return err == nil && match && strings.HasPrefix(hash, "$argon2id$")
+	})).Run(func(args mock.Arguments) { mockUser.Password = args.String(2) }).Return(nil)
+
+	var mailbox bytes.Buffer
+	service := NewService(mockRepo, []byte("test_jwt_here"), WithMailer(mail.NewLogMailer(&mailbox)))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/forgot-password", handler.ForgotPassword)
+	router.POST("/auth/reset-password", handler.ResetPassword)
+	router.GET("/protected", service.AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// an existing session that must not survive the reset
+	result, err := service.LoginUserService(context.Background(), "test", "oldpassword")
+	assert.NoError(t, err)
+	tokens := result.Tokens
+
+	jsonPayload, _ := json.Marshal(models.ForgotPasswordRequest{Email: "test@test.com"})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/forgot-password", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// pull the token out of the mailed link
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(mailbox.String())
+	assert.Len(t, match, 2)
+	resetToken, _ := url.QueryUnescape(match[1])
+
+	// a token outside any session, issued within the second of the reset
+	sessionless, err := service.GenerateToken(testId, "test", config.USER)
+	assert.NoError(t, err)
+
+	jsonPayload, _ = json.Marshal(models.ResetPasswordRequest{Token: resetToken, Password: "newpassword", Confirm: "newpassword"})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/auth/reset-password", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the old access token is revoked
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+sessionless)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// and so is the refresh token
+	_, err = service.RefreshTokens(context.Background(), tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	// logging in with the new password right away works, most likely within the second of the reset
+	result, err = service.LoginUserService(context.Background(), "test", "newpassword")
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+result.Tokens.AccessToken)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestResetPassword_InvalidToken(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+	mockRepo.On("ConsumeOneTimeToken", mock.Anything, models.TokenPurposePasswordReset, hashToken("used-token")).Return(nil, nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/reset-password", handler.ResetPassword)
+
+	jsonPayload, _ := json.Marshal(models.ResetPasswordRequest{Token: "used-token", Password: "newpassword", Confirm: "newpassword"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/reset-password", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var res models.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, ErrInvalidResetToken.Error(), res.Error.Message)
+
+	mockRepo.AssertNotCalled(t, "UpdateUserPassword", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRegisterUser_InvalidEmail(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/register", handler.RegisterUser)
+
+	payload := models.RegisterRequest{
+		Username: "testuser",
+		Email:    "not an email",
+		Password: "password@123",
+		Confirm:  "password@123",
+	}
+	jsonBody, _ := json.Marshal(payload)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/register", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var res models.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, "VALIDATION_ERROR", res.Error.Code)
+	assert.Equal(t, map[string]any{"email": []any{"must be a valid email address"}}, res.Error.Details["fields"])
+
+	mockRepo.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything)
+}
+
+func TestRegisterUser_ValidationErrors(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	// "password" is on every breached list
+	policy := password.DefaultPolicy()
+	policy.Breached = password.NewRangeChecker(password.FileRanges{
+		"5BAA6": {"1E4C9B93F3F0682250B6CF8331B7EE68FD8"},
+	})
+
+	service := NewService(mockRepo, []byte("test_jwt_here"), WithPasswordPolicy(policy))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/register", handler.RegisterUser)
+
+	tests := []struct {
+		name    string
+		payload models.RegisterRequest
+		fields  map[string][]string
+	}{
+		{
+			name:    "every field",
+			payload: models.RegisterRequest{Username: "a", Email: "a@", Password: "short", Confirm: "short"},
+			fields: map[string][]string{
+				"username": {"must be 3 to 32 letters, digits, dots, dashes or underscores and start with a letter or digit"},
+				"email":    {"must be a valid email address"},
+				"password": {"must be at least 8 characters"},
+			},
+		},
+		{
+			name:    "breached password",
+			payload: models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password", Confirm: "password"},
+			fields:  map[string][]string{"password": {"has appeared in a data breach, please choose another"}},
+		},
+		{
+			name:    "truncated password",
+			payload: models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: strings.Repeat("x", 80), Confirm: strings.Repeat("x", 80)},
+			fields:  map[string][]string{"password": {"must be at most 72 bytes"}},
+		},
+		{
+			name:    "confirm",
+			payload: models.RegisterRequest{Username: "testuser", Email: "test@example.com", Password: "password@123", Confirm: "password@321"},
+			fields:  map[string][]string{"confirm": {"does not match password"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonBody, _ := json.Marshal(tt.payload)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/auth/register", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var res struct {
+				Error struct {
+					Code    string `json:"code"`
+					Details struct {
+						Fields map[string][]string `json:"fields"`
+					} `json:"details"`
+				} `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &res)
+			assert.Equal(t, "VALIDATION_ERROR", res.Error.Code)
+			assert.Equal(t, tt.fields, res.Error.Details.Fields)
+		})
+	}
+
+	// nothing is looked up or stored for an invalid registration
+	mockRepo.AssertNotCalled(t, "GetUserByUsername", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything)
+}
+
+func TestResetPassword_WeakPasswordKeepsToken(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/reset-password", handler.ResetPassword)
+
+	jsonPayload, _ := json.Marshal(models.ResetPasswordRequest{Token: "reset-token", Password: "short", Confirm: "short"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/reset-password", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "VALIDATION_ERROR")
+
+	mockRepo.AssertNotCalled(t, "ConsumeOneTimeToken", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestVerifyEmail_Success(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	mockRepo.On("GetUserByUsername", mock.Anything, "testuser").Return(nil, nil)
+	mockRepo.On("GetUserByEmail", mock.Anything, "test@example.com").Return(nil, nil)
+	mockRepo.On("CreateUser", mock.Anything, mock.AnythingOfType("models.User")).Return(nil)
+	mockRepo.On("MarkEmailVerified", mock.Anything, mock.Anything, "test@example.com").Return(true, nil)
+
+	var mailbox bytes.Buffer
+	service := NewService(mockRepo, []byte("test_jwt_here"), WithMailer(mail.NewLogMailer(&mailbox)))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/register", handler.RegisterUser)
+	router.GET("/auth/verify-email", handler.VerifyEmail)
+	router.GET("/protected", service.AuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	payload := models.RegisterRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password@123",
+		Confirm:  "password@123",
+	}
+	jsonBody, _ := json.Marshal(payload)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/register", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// registration mailed a verification link
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(mailbox.String())
+	assert.Len(t, match, 2)
+	verificationToken, _ := url.QueryUnescape(match[1])
+
+	// the link is not an access token
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+verificationToken)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/auth/verify-email?token="+url.QueryEscape(verificationToken), nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestLoginPlayer_Unverified(t *testing.T) {
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Email:    "test@test.com",
+		Password: string(hashed_password),
+		Role:     config.USER,
+		Verified: false,
+	}
+
+	t.Run("deny policy refuses the login", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+		mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).Return(nil)
+
+		service := NewService(mockRepo, []byte("test_jwt_here"), WithUnverifiedLoginPolicy(config.UNVERIFIED_LOGIN_DENY))
+		handler := NewHandler(service)
+
+		router := gin.New()
+		router.Use(apperr.Middleware())
+		router.POST("/auth/login", handler.LoginUser)
+
+		jsonPayload, _ := json.Marshal(models.LoginRequest{Username: "test", Password: "correctpassword"})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		var res models.ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		assert.Equal(t, ErrEmailNotVerified.Error(), res.Error.Message)
+	})
+
+	t.Run("limited policy issues guest claims", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+		mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+		service := NewService(mockRepo, []byte("test_jwt_here"), WithUnverifiedLoginPolicy(config.UNVERIFIED_LOGIN_LIMITED))
+
+		result, err := service.LoginUserService(context.Background(), "test", "correctpassword")
+		assert.NoError(t, err)
+		tokens := result.Tokens
+
+		claims, err := service.ValidateToken(tokens.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, config.GUEST, claims.Role)
+	})
+}
+
+func TestLoginPlayer_AccountFromBeforeVerification(t *testing.T) {
+	hashed_password, _ := password.DefaultHasher().Hash("correctpassword")
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	// stored before email verification existed, there is no verified field
+	stored, _ := bson.Marshal(bson.M{
+		"_id":      parsedID,
+		"username": "test",
+		"email":    "test@test.com",
+		"password": hashed_password,
+		"role":     config.USER,
+	})
+	var mockUser models.User
+	assert.NoError(t, bson.Unmarshal(stored, &mockUser))
+
+	mockRepo := new(MockAuthRepository)
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(&mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"), WithUnverifiedLoginPolicy(config.UNVERIFIED_LOGIN_DENY))
+
+	result, err := service.LoginUserService(context.Background(), "test", "correctpassword")
+	assert.NoError(t, err)
+
+	claims, err := service.ValidateToken(result.Tokens.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, config.USER, claims.Role)
+}
+
+func TestLoginPlayer_LockedOut(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Password: string(hashed_password),
+		Role:     config.USER,
+	}
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).Return(nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"), WithLockout(ratelimit.NewLockout(ratelimit.NewMemoryLockoutStore(), 3, time.Minute, time.Hour)))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/login", handler.LoginUser)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(models.LoginRequest{Username: "test", Password: password})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("wrongpassword").Code)
+	}
+
+	// even the right password is refused while locked
+	w := login("correctpassword")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	var res models.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, "RATE_LIMIT_EXCEEDED", res.Error.Code)
+
+	mockRepo.AssertNotCalled(t, "UpdateUserStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// test vector from RFC 6238 appendix B, the secret is "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	code, err := totpCode(secret, totpStep(time.Unix(59, 0)))
+	assert.NoError(t, err)
+	assert.Equal(t, "287082", code)
+
+	code, err = totpCode(secret, totpStep(time.Unix(1111111109, 0)))
+	assert.NoError(t, err)
+	assert.Equal(t, "081804", code)
+
+	// one step of drift either way is accepted, two are not
+	now := time.Unix(1111111109, 0)
+	_, ok := verifyTOTP(secret, "081804", now.Add(totpPeriod*time.Second))
+	assert.True(t, ok)
+	_, ok = verifyTOTP(secret, "081804", now.Add(2*totpPeriod*time.Second))
+	assert.False(t, ok)
+}
+
+func TestEnrollAndConfirmMFA(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Role:     config.USER,
+		Verified: true,
+	}
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserMFA", mock.Anything, testId, mock.AnythingOfType("models.MFASettings")).Run(func(args mock.Arguments) {
+		mockUser.MFA = args.Get(2).(models.MFASettings)
+	}).Return(nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	setUser := func(c *gin.Context) { c.Set("userID", testId) }
+	router.POST("/auth/mfa/enroll", setUser, handler.EnrollMFA)
+	router.POST("/auth/mfa/confirm", setUser, handler.ConfirmMFA)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/mfa/enroll", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var enrollment models.MFAEnrollResponse
+	json.Unmarshal(w.Body.Bytes(), &enrollment)
+	assert.NotEmpty(t, enrollment.Secret)
+	assert.Contains(t, enrollment.ProvisioningURI, "otpauth://totp/Uriel:test?")
+	assert.False(t, mockUser.MFA.Enabled)
+
+	confirm := func(code string) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(models.MFACodeRequest{Code: code})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/mfa/confirm", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, confirm("000000").Code)
+
+	code, _ := totpCode(enrollment.Secret, totpStep(time.Now()))
+	w = confirm(code)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res models.RecoveryCodesResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Len(t, res.RecoveryCodes, recoveryCodeCount)
+	assert.True(t, mockUser.MFA.Enabled)
+	assert.Equal(t, enrollment.Secret, mockUser.MFA.Secret)
+	assert.Empty(t, mockUser.MFA.PendingSecret)
+
+	// only hashes are stored
+	assert.NotContains(t, mockUser.MFA.RecoveryCodes, res.RecoveryCodes[0])
+	assert.Contains(t, mockUser.MFA.RecoveryCodes, hashToken(res.RecoveryCodes[0]))
+}
+
+func TestLoginPlayer_MFA(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	secret, _ := generateTOTPSecret()
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Password: string(hashed_password),
+		Role:     config.ADMIN,
+		Verified: true,
+		MFA: models.MFASettings{
+			Enabled:       true,
+			Secret:        secret,
+			RecoveryCodes: []string{hashToken("abcde-fghij"), hashToken("klmno-pqrst")},
+		},
+	}
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).Return(nil)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+	mockRepo.On("UseTOTPStep", mock.Anything, testId, mock.AnythingOfType("int64")).Run(func(args mock.Arguments) {
+		mockUser.MFA.LastUsedStep = args.Get(2).(int64)
+	}).Return(true, nil)
+	mockRepo.On("UseRecoveryCode", mock.Anything, testId, mock.AnythingOfType("string")).Run(func(args mock.Arguments) {
+		mockUser.MFA.RecoveryCodes = slices.DeleteFunc(mockUser.MFA.RecoveryCodes, func(hash string) bool { return hash == args.String(2) })
+	}).Return(true, nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/login", handler.LoginUser)
+	router.POST("/auth/mfa/verify", handler.VerifyMFA)
+
+	login := func() models.LoginResponse {
+		jsonPayload, _ := json.Marshal(models.LoginRequest{Username: "test", Password: "correctpassword"})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var res models.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return res
+	}
+	verify := func(mfaToken string, code string) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(models.MFAVerifyRequest{MFAToken: mfaToken, Code: code})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/mfa/verify", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// the password alone gives no session
+	res := login()
+	assert.True(t, res.MFARequired)
+	assert.NotEmpty(t, res.MFAToken)
+	assert.Empty(t, res.Token)
+	assert.Empty(t, res.RefreshToken)
+
+	// the mfa token is not an access token
+	_, err := service.ValidateToken(res.MFAToken)
+	assert.Error(t, err)
+
+	assert.Equal(t, http.StatusUnauthorized, verify(res.MFAToken, "000000").Code)
+
+	code, _ := totpCode(secret, totpStep(time.Now()))
+	w := verify(res.MFAToken, code)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var tokens models.LoginResponse
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	claims, err := service.ValidateToken(tokens.Token)
+	assert.NoError(t, err)
+	assert.True(t, claims.MFA)
+	assert.Equal(t, config.ADMIN, claims.Role)
+
+	// a code cannot be replayed
+	assert.Equal(t, http.StatusUnauthorized, verify(login().MFAToken, code).Code)
+
+	// recovery codes work once
+	assert.Equal(t, http.StatusOK, verify(login().MFAToken, "abcde-fghij").Code)
+	assert.Equal(t, http.StatusUnauthorized, verify(login().MFAToken, "abcde-fghij").Code)
+
+	// and so does the mfa token, even with another valid code
+	assert.Equal(t, http.StatusUnauthorized, verify(res.MFAToken, "klmno-pqrst").Code)
+}
+
+func TestVerifyMFA_ConcurrentRequestsUseACodeOnce(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := password.DefaultHasher().Hash("correctpassword")
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	secret, _ := generateTOTPSecret()
+	// both requests read the user before either used the code
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Password: hashed_password,
+		Role:     config.USER,
+		Verified: true,
+		MFA: models.MFASettings{
+			Enabled:       true,
+			Secret:        secret,
+			RecoveryCodes: []string{hashToken("abcde-fghij")},
+		},
+	}
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+	mockRepo.On("UseRecoveryCode", mock.Anything, testId, hashToken("abcde-fghij")).Return(true, nil).Once()
+	mockRepo.On("UseRecoveryCode", mock.Anything, testId, hashToken("abcde-fghij")).Return(false, nil)
+	mockRepo.On("UseTOTPStep", mock.Anything, testId, mock.AnythingOfType("int64")).Return(true, nil).Once()
+	mockRepo.On("UseTOTPStep", mock.Anything, testId, mock.AnythingOfType("int64")).Return(false, nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+
+	for _, code := range []string{"abcde-fghij", func() string { code, _ := totpCode(secret, totpStep(time.Now())); return code }()} {
+		first, err := service.LoginUserService(context.Background(), "test", "correctpassword")
+		assert.NoError(t, err)
+		second, err := service.LoginUserService(context.Background(), "test", "correctpassword")
+		assert.NoError(t, err)
+
+		_, err = service.VerifyMFAService(context.Background(), first.MFAToken, code)
+		assert.NoError(t, err)
+		_, err = service.VerifyMFAService(context.Background(), second.MFAToken, code)
+		assert.ErrorIs(t, err, ErrInvalidMFACode)
+	}
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestLoginPlayer_AdminWithoutMFA(t *testing.T) {
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Password: string(hashed_password),
+		Role:     config.ADMIN,
+		Verified: true,
+	}
+
+	t.Run("admin role is withheld", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+		mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).Return(nil)
+		mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+		service := NewService(mockRepo, []byte("test_jwt_here"))
+
+		result, err := service.LoginUserService(context.Background(), "test", "correctpassword")
+		assert.NoError(t, err)
+		assert.True(t, result.Tokens.MFAEnrollmentRequired)
+
+		claims, err := service.ValidateToken(result.Tokens.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, config.USER, claims.Role)
+	})
+
+	t.Run("admin role is kept when not required", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+		mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+		service := NewService(mockRepo, []byte("test_jwt_here"), WithAdminMFARequired(false))
+
+		result, err := service.LoginUserService(context.Background(), "test", "correctpassword")
+		assert.NoError(t, err)
+		assert.False(t, result.Tokens.MFAEnrollmentRequired)
+
+		claims, err := service.ValidateToken(result.Tokens.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, config.ADMIN, claims.Role)
+	})
+}
+
+// newOIDCRouter sets up single sign-on against a fake identity provider
+func newOIDCRouter(t *testing.T, mockRepo *MockAuthRepository, user oidctest.User) (*gin.Engine, *Service, *oidctest.Server) {
+	idp := oidctest.NewServer("uriel", "secret")
+	t.Cleanup(idp.Close)
+	idp.SetUser(user)
+
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer:       idp.URL,
+		ClientID:     "uriel",
+		ClientSecret: "secret",
+		RedirectURL:  "http://uriel.test/auth/oidc/callback",
+	}, idp.Client())
+	assert.NoError(t, err)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"), WithOIDCProvider(provider))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.GET("/auth/oidc/login", handler.OIDCLogin)
+	router.GET("/auth/oidc/callback", handler.OIDCCallback)
+
+	return router, service, idp
+}
+
+// signInWithOIDC goes through login, the provider and the callback like a browser would
+func signInWithOIDC(t *testing.T, router *gin.Engine, idp *oidctest.Server) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/auth/oidc/login", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	client := idp.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/auth/oidc/callback?"+callback.RawQuery, nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestOIDCCallback_CreatesUser(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	var created models.User
+	mockRepo.On("GetUserByIdentity", mock.Anything, mock.Anything, "sub-42").Return(nil, nil)
+	mockRepo.On("GetUserByEmail", mock.Anything, "jane@company.com").Return(nil, nil)
+	mockRepo.On("GetUserByUsername", mock.Anything, "jane.doe").Return(nil, nil)
+	mockRepo.On("CreateUser", mock.Anything, mock.AnythingOfType("models.User")).Run(func(args mock.Arguments) {
+		created = args.Get(1).(models.User)
+	}).Return(nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, mock.Anything, true).Return(nil)
+
+	router, service, idp := newOIDCRouter(t, mockRepo, oidctest.User{
+		Subject:           "sub-42",
+		Email:             "jane@company.com",
+		EmailVerified:     true,
+		PreferredUsername: "Jane.Doe",
+	})
+
+	w := signInWithOIDC(t, router, idp)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, "jane.doe", created.Username)
+	assert.Empty(t, created.Password)
+	assert.True(t, created.Verified)
+	assert.Equal(t, []models.Identity{{Issuer: idp.URL, Subject: "sub-42", Email: "jane@company.com", LinkedAt: created.Identities[0].LinkedAt}}, created.Identities)
+
+	var res models.LoginResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	claims, err := service.ValidateToken(res.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, created.ID.Hex(), claims.UserID)
+	assert.Equal(t, config.USER, claims.Role)
+
+	// the new account cannot be used with an empty password
+	_, err = service.LoginUserService(context.Background(), "jane.doe", "")
+	assert.Error(t, err)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOIDCCallback_LinksExistingUser(t *testing.T) {
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	existing := func(verified bool) *models.User {
+		return &models.User{
+			ID:       parsedID,
+			Email:    "jane@company.com",
+			Username: "jane",
+			Password: "hash",
+			Role:     config.USER,
+			Verified: verified,
+		}
+	}
+
+	t.Run("verified email is linked", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		mockRepo.On("GetUserByIdentity", mock.Anything, mock.Anything, "sub-42").Return(nil, nil)
+		mockRepo.On("GetUserByEmail", mock.Anything, "jane@company.com").Return(existing(true), nil)
+		mockRepo.On("AddUserIdentity", mock.Anything, testId, mock.AnythingOfType("models.Identity")).Return(nil)
+		mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+		router, _, idp := newOIDCRouter(t, mockRepo, oidctest.User{Subject: "sub-42", Email: "jane@company.com", EmailVerified: true})
+
+		w := signInWithOIDC(t, router, idp)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		mockRepo.AssertExpectations(t)
+		mockRepo.AssertNotCalled(t, "UpdateUserPassword", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("unverified local account loses its password", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		mockRepo.On("GetUserByIdentity", mock.Anything, mock.Anything, "sub-42").Return(nil, nil)
+		mockRepo.On("GetUserByEmail", mock.Anything, "jane@company.com").Return(existing(false), nil)
+		mockRepo.On("UpdateUserPassword", mock.Anything, testId, "").Return(nil)
+		mockRepo.On("MarkEmailVerified", mock.Anything, testId, "jane@company.com").Return(true, nil)
+		mockRepo.On("AddUserIdentity", mock.Anything, testId, mock.AnythingOfType("models.Identity")).Return(nil)
+		mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+		router, _, idp := newOIDCRouter(t, mockRepo, oidctest.User{Subject: "sub-42", Email: "jane@company.com", EmailVerified: true})
+
+		w := signInWithOIDC(t, router, idp)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("unverified provider email is refused", func(t *testing.T) {
+		mockRepo := new(MockAuthRepository)
+		mockRepo.On("GetUserByIdentity", mock.Anything, mock.Anything, "sub-42").Return(nil, nil)
+		mockRepo.On("GetUserByEmail", mock.Anything, "jane@company.com").Return(existing(true), nil)
+
+		router, _, idp := newOIDCRouter(t, mockRepo, oidctest.User{Subject: "sub-42", Email: "jane@company.com", EmailVerified: false})
+
+		w := signInWithOIDC(t, router, idp)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		mockRepo.AssertNotCalled(t, "AddUserIdentity", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestSanitizeUsername(t *testing.T) {
+	tests := map[string]string{
+		"Jane.Doe":              "jane.doe",
+		"jo":                    "userjo",
+		"":                      "user",
+		"_jane":                 "jane",
+		"Jöhn Smith":            "jhnsmith",
+		strings.Repeat("a", 40): strings.Repeat("a", 28),
+	}
+
+	for name, want := range tests {
+		username := sanitizeUsername(name)
+		assert.Equal(t, want, username)
+		// with the number added when the username is taken
+		assert.True(t, ValidUsername(username+"0000"), username)
+		assert.True(t, ValidUsername(username), username)
+	}
+}
+
+func TestOIDCCallback_InvalidState(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+	router, _, _ := newOIDCRouter(t, mockRepo, oidctest.User{Subject: "sub-42"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/auth/oidc/callback?state=forged&code=abc", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestOIDCLogin_NotConfigured(t *testing.T) {
+	service := NewService(new(MockAuthRepository), []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.GET("/auth/oidc/login", handler.OIDCLogin)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/auth/oidc/login", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	current, _ := GenerateSigningKey("2026-01", AlgorithmEdDSA, start)
+	next, _ := GenerateSigningKey("2026-02", AlgorithmRS256, start.Add(time.Hour))
+
+	keyring, err := NewKeyring(24*time.Hour, current, next)
+	assert.NoError(t, err)
+
+	now := start.Add(30 * time.Minute)
+	keyring.now = func() time.Time { return now }
+
+	service := NewService(new(MockAuthRepository), []byte("test_jwt_here"), WithKeyring(keyring))
+
+	kids := func() []string {
+		var kids []string
+		for _, key := range keyring.JWKS().Keys {
+			kids = append(kids, key.Kid)
+		}
+		return kids
+	}
+
+	// the next key is published before it signs
+	key, _ := keyring.SigningKey()
+	assert.Equal(t, "2026-01", key.ID)
+	assert.Equal(t, []string{"2026-01", "2026-02"}, kids())
+
+	oldToken, err := service.GenerateToken("user", "test", config.USER)
+	assert.NoError(t, err)
+
+	// after the switch the old key still verifies for the overlap
+	now = start.Add(2 * time.Hour)
+	key, _ = keyring.SigningKey()
+	assert.Equal(t, "2026-02", key.ID)
+	assert.Equal(t, []string{"2026-01", "2026-02"}, kids())
+
+	_, err = service.ValidateToken(oldToken)
+	assert.NoError(t, err)
+
+	// and is retired after it
+	now = start.Add(26 * time.Hour)
+	assert.Equal(t, []string{"2026-02"}, kids())
+	_, err = service.ValidateToken(oldToken)
+	assert.Error(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := GenerateSigningKey("rsa", AlgorithmRS256, time.Now().Add(-time.Minute))
+	keyring, _ := NewKeyring(time.Hour, rsaKey)
+
+	service := NewService(new(MockAuthRepository), []byte("test_jwt_here"), WithKeyring(keyring))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	RegisterWellKnownRoutes(router, handler)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var jwks JWKS
+	json.Unmarshal(w.Body.Bytes(), &jwks)
+	assert.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "RSA", jwks.Keys[0].Kty)
+	assert.Equal(t, "RS256", jwks.Keys[0].Alg)
+
+	// another service can verify a token with nothing but the published key
+	token, err := service.GenerateToken("user", "test", config.USER)
+	assert.NoError(t, err)
+
+	n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		assert.Equal(t, "rsa", token.Header["kid"])
+		return public, nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	assert.NoError(t, err)
+	assert.True(t, parsed.Valid)
+}
+
+func TestValidateToken_AlgorithmFromKey(t *testing.T) {
+	key, _ := GenerateSigningKey("ed", AlgorithmEdDSA, time.Now().Add(-time.Minute))
+	keyring, _ := NewKeyring(time.Hour, key)
+
+	service := NewService(new(MockAuthRepository), []byte("test_jwt_here"), WithKeyring(keyring))
+
+	// a token made with the public key as HMAC secret must not pass
+	claims := models.Claims{
+		UserID: "user",
+		Role:   config.ADMIN,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audienceAccess},
+			ID:        "jti",
+		},
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "ed"
+	forgedString, _ := forged.SignedString([]byte(key.Private.Public().(ed25519.PublicKey)))
+
+	_, err := service.ValidateToken(forgedString)
+	assert.Error(t, err)
+
+	// nor one signed with the shared secret
+	secretSigned, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test_jwt_here"))
+	_, err = service.ValidateToken(secretSigned)
+	assert.Error(t, err)
+}
+
+func TestLoadSigningKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	_, private, _ := ed25519.GenerateKey(nil)
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{"Not-Before": "2026-03-01T00:00:00Z"},
+		Bytes:   der,
+	})
+	os.WriteFile(filepath.Join(dir, "2026-03.pem"), data, 0o600)
+
+	keys, err := LoadSigningKeys(dir)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, "2026-03", keys[0].ID)
+	assert.Equal(t, AlgorithmEdDSA, keys[0].Algorithm)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), keys[0].NotBefore)
+}
+
+func TestPersonalAccessToken_Lifecycle(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Role:     config.USER,
+		Verified: true,
+	}
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	sessionToken, _ := service.GenerateToken(testId, "test", config.USER)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/tokens", service.AuthMiddleware(), RequireSession(), handler.CreatePersonalAccessToken)
+	router.GET("/auth/tokens", service.AuthMiddleware(), RequireSession(), handler.ListPersonalAccessTokens)
+	router.DELETE("/auth/tokens/:id", service.AuthMiddleware(), RequireSession(), handler.RevokePersonalAccessToken)
+	router.GET("/rooms", service.AuthMiddleware(), RequirePermission(PermJoinRooms), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetString("userID"), "role": c.GetString("role")})
+	})
+	router.POST("/rooms", service.AuthMiddleware(), RequirePermission(PermCreateRooms), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	request := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodPost, "/auth/tokens", sessionToken, models.CreatePersonalAccessTokenRequest{
+		Name:   "ci",
+		Scopes: []string{PermJoinRooms},
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created models.CreatePersonalAccessTokenResponse
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.True(t, strings.HasPrefix(created.Token, "uriel_pat_"))
+	assert.True(t, strings.HasPrefix(created.Token, created.Prefix))
+	assert.WithinDuration(t, time.Now().Add(config.DEFAULT_PAT_DURATION), created.ExpiresAt, time.Minute)
+
+	// the token resolves to the same context as a login
+	w = request(http.MethodGet, "/rooms", created.Token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"user_id":"`+testId+`","role":"user"}`, w.Body.String())
+
+	// but only within its scopes
+	w = request(http.MethodPost, "/rooms", created.Token, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// and it cannot manage tokens
+	w = request(http.MethodGet, "/auth/tokens", created.Token, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(http.MethodGet, "/auth/tokens", sessionToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var list struct {
+		Tokens []models.PersonalAccessTokenResponse `json:"tokens"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Len(t, list.Tokens, 1)
+	assert.Equal(t, "ci", list.Tokens[0].Name)
+	assert.NotNil(t, list.Tokens[0].LastUsedAt)
+	assert.NotContains(t, w.Body.String(), created.Token)
+
+	w = request(http.MethodDelete, "/auth/tokens/"+created.ID, sessionToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(http.MethodGet, "/rooms", created.Token, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = request(http.MethodDelete, "/auth/tokens/"+created.ID, sessionToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPersonalAccessToken_KeepsTheMFAOfTheSession(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "admin",
+		Role:     config.ADMIN,
+		Verified: true,
+		MFA:      models.MFASettings{Enabled: true, Secret: "TOTPSECRET"},
+	}
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/tokens", service.AuthMiddleware(), RequireSession(), handler.CreatePersonalAccessToken)
+	router.GET("/whoami", service.AuthMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"role": c.GetString("role")})
+	})
+
+	roleOfTokenFrom := func(sessionToken string) string {
+		jsonPayload, _ := json.Marshal(models.CreatePersonalAccessTokenRequest{Name: "ci", Scopes: []string{PermJoinRooms}})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/tokens", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+sessionToken)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var created models.CreatePersonalAccessTokenResponse
+		json.Unmarshal(w.Body.Bytes(), &created)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest(http.MethodGet, "/whoami", nil)
+		req.Header.Set("Authorization", "Bearer "+created.Token)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Role string `json:"role"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return res.Role
+	}
+
+	// an admin that stopped at the password only had the member role, and so has the token
+	withoutMFA, _ := service.generateToken(testId, "admin", config.USER, false, "")
+	assert.Equal(t, config.USER, roleOfTokenFrom(withoutMFA))
+
+	withMFA, _ := service.generateToken(testId, "admin", config.ADMIN, true, "")
+	assert.Equal(t, config.ADMIN, roleOfTokenFrom(withMFA))
+}
+
+func TestCreatePersonalAccessToken_Invalid(t *testing.T) {
+	service := NewService(new(MockAuthRepository), []byte("test_jwt_here"))
+
+	tests := []struct {
+		name string
+		req  models.CreatePersonalAccessTokenRequest
+		err  error
+	}{
+		{"no name", models.CreatePersonalAccessTokenRequest{Scopes: []string{PermJoinRooms}}, ErrInvalidTokenName},
+		{"no scopes", models.CreatePersonalAccessTokenRequest{Name: "ci"}, ErrInvalidScope},
+		{"scope beyond role", models.CreatePersonalAccessTokenRequest{Name: "ci", Scopes: []string{PermManageUsers}}, ErrInvalidScope},
+		{"unknown scope", models.CreatePersonalAccessTokenRequest{Name: "ci", Scopes: []string{"everything"}}, ErrInvalidScope},
+		{"too long", models.CreatePersonalAccessTokenRequest{Name: "ci", Scopes: []string{PermJoinRooms}, ExpiresInDays: 400}, ErrInvalidTokenExpiry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.CreatePersonalAccessTokenService(context.Background(), "user", config.USER, false, &tt.req)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestSessions_ListAndRevoke(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Password: string(hashed_password),
+		Role:     config.USER,
+		Verified: true,
+	}
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("UpgradeUserPassword", mock.Anything, testId, string(hashed_password), mock.AnythingOfType("string")).Return(nil)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, false).Return(nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/login", handler.LoginUser)
+	router.POST("/auth/refresh", handler.RefreshToken)
+	router.GET("/users/sessions", service.AuthMiddleware(), RequireSession(), handler.ListSessions)
+	router.DELETE("/users/sessions", service.AuthMiddleware(), RequireSession(), handler.SignOutEverywhere)
+	router.DELETE("/users/sessions/:id", service.AuthMiddleware(), RequireSession(), handler.RevokeSession)
+
+	login := func(userAgent string) models.LoginResponse {
+		jsonPayload, _ := json.Marshal(models.LoginRequest{Username: "test", Password: "correctpassword"})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var res models.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return res
+	}
+	request := func(method string, path string, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	refresh := func(refreshToken string) int {
+		jsonPayload, _ := json.Marshal(models.RefreshRequest{RefreshToken: refreshToken})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	laptop := login("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15")
+	phone := login("Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.43 Mobile Safari/537.36")
+
+	w := request(http.MethodGet, "/users/sessions", laptop.Token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var list struct {
+		Sessions []models.SessionResponse `json:"sessions"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Len(t, list.Sessions, 2)
+
+	var phoneSession models.SessionResponse
+	for _, session := range list.Sessions {
+		if session.Current {
+			assert.Equal(t, models.DeviceInfo{Platform: "web", OS: "macOS", Browser: "Safari", Version: "17.1"}, session.DeviceInfo)
+		} else {
+			phoneSession = session
+		}
+	}
+	assert.Equal(t, models.DeviceInfo{Platform: "web", OS: "Android", Browser: "Chrome", Version: "120.0.6099.43"}, phoneSession.DeviceInfo)
+	assert.NotZero(t, phoneSession.LastSeenAt)
+
+	// sign the phone out from the laptop
+	w = request(http.MethodDelete, "/users/sessions/"+phoneSession.ID, laptop.Token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(http.MethodGet, "/users/sessions", phone.Token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, refresh(phone.RefreshToken))
+
+	w = request(http.MethodDelete, "/users/sessions/"+phoneSession.ID, laptop.Token)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// the laptop is not affected, and refreshing keeps the session
+	newTokens := httptest.NewRecorder()
+	jsonPayload, _ := json.Marshal(models.RefreshRequest{RefreshToken: laptop.RefreshToken})
+	req, _ := http.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(newTokens, req)
+	assert.Equal(t, http.StatusOK, newTokens.Code)
+
+	var refreshed models.LoginResponse
+	json.Unmarshal(newTokens.Body.Bytes(), &refreshed)
+
+	w = request(http.MethodGet, "/users/sessions", refreshed.Token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &list)
+	assert.Len(t, list.Sessions, 1)
+	assert.True(t, list.Sessions[0].Current)
+
+	// sign out everywhere ends the laptop session as well
+	w = request(http.MethodDelete, "/users/sessions", refreshed.Token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(http.MethodGet, "/users/sessions", refreshed.Token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, refresh(refreshed.RefreshToken))
+
+	sessions, err := service.ListSessionsService(context.Background(), testId, "")
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestRevokeSession_OtherUser(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+
+	tokens, err := service.IssueTokens(context.Background(), &models.User{ID: parsedID, Username: "test", Role: config.USER, Verified: true}, "family", false)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	err = service.RevokeSessionService(context.Background(), "someone-else", "family")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	sessions, err := service.ListSessionsService(context.Background(), testId, "family")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, models.DeviceInfo{Platform: "api", OS: "unknown", Browser: "unknown"}, sessions[0].DeviceInfo)
+}
+
+func TestRoutes_TokenRedemptionIsRateLimited(t *testing.T) {
+	service := NewService(new(MockAuthRepository), []byte("test_jwt_here"))
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	RegisterRoutes(router.Group(""), NewHandler(service), service.AuthMiddleware(), ratelimit.NewMemoryStore())
+
+	limits := map[string]int{
+		"/auth/refresh":        config.REFRESH_RATE_LIMIT,
+		"/auth/reset-password": config.LOGIN_RATE_LIMIT,
+	}
+	for path, limit := range limits {
+		t.Run(path, func(t *testing.T) {
+			post := func() int {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader("{}"))
+				req.Header.Set("Content-Type", "application/json")
+				router.ServeHTTP(w, req)
+				return w.Code
+			}
+			for i := 0; i < limit; i++ {
+				assert.Equal(t, http.StatusBadRequest, post())
+			}
+			assert.Equal(t, http.StatusTooManyRequests, post())
+		})
+	}
+}
+
+func TestGuestJoin_Lifecycle(t *testing.T) {
+	service := NewService(new(MockAuthRepository), []byte("test_jwt_here"))
+	handler := NewHandler(service)
+
+	adminToken, _ := service.GenerateToken("6592008029c8c3e4dc76256c", "admin", config.ADMIN)
+	memberToken, _ := service.GenerateToken("6592008029c8c3e4dc76256d", "member", config.USER)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	RegisterRoutes(router.Group(""), handler, service.AuthMiddleware(), ratelimit.NewMemoryStore())
+	router.GET("/rooms/:room", service.AuthMiddleware(), RequirePermission(PermJoinRooms), RequireRoomAccess("room"), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetString("userID"), "username": c.GetString("username")})
+	})
+	router.GET("/users/user", service.AuthMiddleware(), RequirePermission(PermReadWorkspace), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// only admins invite
+	w := request(http.MethodPost, "/auth/guest-invites", memberToken, models.CreateGuestInviteRequest{Rooms: []string{"lobby"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(http.MethodPost, "/auth/guest-invites", adminToken, models.CreateGuestInviteRequest{Rooms: []string{"lobby", "lobby"}, MaxUses: 1})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var invite models.GuestInviteResponse
+	json.Unmarshal(w.Body.Bytes(), &invite)
+	assert.Equal(t, []string{"lobby"}, invite.Rooms)
+	assert.Equal(t, "http://localhost:8080/join?invite="+url.QueryEscape(invite.Token), invite.URL)
+	assert.WithinDuration(t, time.Now().Add(config.DEFAULT_GUEST_INVITE_DURATION), invite.ExpiresAt, time.Minute)
+
+	w = request(http.MethodPost, "/auth/guest/join", "", models.GuestJoinRequest{Invite: invite.Token, DisplayName: "  "})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "display_name")
+
+	w = request(http.MethodPost, "/auth/guest/join", "", models.GuestJoinRequest{Invite: invite.Token, DisplayName: " Visitor "})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var joined models.GuestJoinResponse
+	json.Unmarshal(w.Body.Bytes(), &joined)
+	assert.Equal(t, "Visitor", joined.DisplayName)
+	assert.Equal(t, int64(config.GUEST_TOKEN_DURATION.Seconds()), joined.ExpiresIn)
+
+	claims, err := service.ValidateToken(joined.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, config.GUEST, claims.Role)
+	assert.Equal(t, []string{"lobby"}, claims.Rooms)
+	assert.WithinDuration(t, time.Now().Add(config.GUEST_TOKEN_DURATION), claims.ExpiresAt.Time, time.Minute)
+
+	// the invite allowed a single guest
+	w = request(http.MethodPost, "/auth/guest/join", "", models.GuestJoinRequest{Invite: invite.Token, DisplayName: "Another"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the guest gets into the rooms of the invite and nowhere else
+	w = request(http.MethodGet, "/rooms/lobby", joined.Token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"user_id":"`+joined.GuestID+`","username":"Visitor"}`, w.Body.String())
+
+	w = request(http.MethodGet, "/rooms/boardroom", joined.Token, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(http.MethodGet, "/rooms/boardroom", memberToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(http.MethodGet, "/users/user", joined.Token, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(http.MethodGet, "/users/sessions", joined.Token, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// deleting the invite removes the guest
+	w = request(http.MethodDelete, "/auth/guest-invites/"+invite.ID, adminToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(http.MethodGet, "/rooms/lobby", joined.Token, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = request(http.MethodDelete, "/auth/guest-invites/"+invite.ID, adminToken, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestMagicLink_Login(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Email:    "test@test.com",
+		Role:     config.USER,
+		Verified: true,
+	}
+	mockRepo.On("GetUserByEmail", mock.Anything, "test@test.com").Return(mockUser, nil)
+	mockRepo.On("GetUserByEmail", mock.Anything, "nobody@test.com").Return(nil, mongo.ErrNoDocuments)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+
+	// the link works once
+	var stored models.OneTimeToken
+	mockRepo.On("CreateOneTimeToken", mock.Anything, mock.AnythingOfType("models.OneTimeToken")).
+		Run(func(args mock.Arguments) { stored = args.Get(1).(models.OneTimeToken) }).
+		Return(nil).Once()
+	mockRepo.On("ConsumeOneTimeToken", mock.Anything, models.TokenPurposeMagicLink, mock.MatchedBy(func(tokenHash string) bool {
+		return tokenHash == stored.TokenHash
+	})).Return(&stored, nil).Once()
+	mockRepo.On("ConsumeOneTimeToken", mock.Anything, models.TokenPurposeMagicLink, mock.Anything).Return(nil, nil)
+
+	var mailbox bytes.Buffer
+	service := NewService(mockRepo, []byte("test_jwt_here"), WithMailer(mail.NewLogMailer(&mailbox)))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/magic-link", handler.MagicLink)
+	router.POST("/auth/magic-link/verify", handler.MagicLinkLogin)
+
+	post := func(path string, body interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// unknown emails get the same answer and no mail
+	unknown := post("/auth/magic-link", models.MagicLinkRequest{Email: "nobody@test.com"})
+	assert.Equal(t, http.StatusAccepted, unknown.Code)
+	assert.Empty(t, mailbox.String())
+
+	known := post("/auth/magic-link", models.MagicLinkRequest{Email: "test@test.com"})
+	assert.Equal(t, http.StatusAccepted, known.Code)
+	assert.Equal(t, unknown.Body.String(), known.Body.String())
+	assert.WithinDuration(t, time.Now().Add(config.MAGIC_LINK_DURATION), stored.ExpiresAt, time.Minute)
+
+	// asking again right away is rate limited per email, still without telling
+	mails := mailbox.Len()
+	w := post("/auth/magic-link", models.MagicLinkRequest{Email: "test@test.com"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, mails, mailbox.Len())
+
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(mailbox.String())
+	assert.Len(t, match, 2)
+	loginToken, _ := url.QueryUnescape(match[1])
+
+	w = post("/auth/magic-link/verify", models.MagicLinkLoginRequest{Token: loginToken})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res models.LoginResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, "User login successful", res.Message)
+	assert.NotEmpty(t, res.Token)
+	assert.NotEmpty(t, res.RefreshToken)
+
+	w = post("/auth/magic-link/verify", models.MagicLinkLoginRequest{Token: loginToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	mockRepo.AssertExpectations(t)
+}
+
+// accountTestRouter serves the routes the credential change tests need, logging in gives an access token
+func accountTestRouter(t *testing.T, service *Service) (func(username string, userAgent string) models.LoginResponse, func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder) {
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/login", handler.LoginUser)
+	router.POST("/auth/register", handler.RegisterUser)
+	router.GET("/users/sessions", service.AuthMiddleware(), RequireSession(), handler.ListSessions)
+	router.POST("/auth/change-password", service.AuthMiddleware(), RequireSession(), handler.ChangePassword)
+	router.POST("/auth/change-email", service.AuthMiddleware(), RequireSession(), handler.ChangeEmail)
+	router.POST("/auth/change-email/confirm", handler.ConfirmEmailChange)
+	router.POST("/auth/change-username", service.AuthMiddleware(), RequireSession(), handler.ChangeUsername)
+
+	request := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	login := func(username string, userAgent string) models.LoginResponse {
+		jsonPayload, _ := json.Marshal(models.LoginRequest{Username: username, Password: "correctpassword"})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var res models.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return res
+	}
+	return login, request
+}
+
+func TestChangePassword_RevokesOtherSessions(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := password.DefaultHasher().Hash("correctpassword")
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Email:    "test@test.com",
+		Password: hashed_password,
+		Role:     config.USER,
+		Verified: true,
+	}
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+	mockRepo.On("UpdateUserPassword", mock.Anything, testId, mock.MatchedBy(func(hash string) bool {
+		ok, _ := password.DefaultHasher().Verify("a much better password", hash)
+		return ok
+	})).Return(nil).Once()
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	login, request := accountTestRouter(t, service)
+
+	laptop := login("test", "laptop")
+	phone := login("test", "phone")
+
+	change := func(current string) *httptest.ResponseRecorder {
+		return request(http.MethodPost, "/auth/change-password", laptop.Token, models.ChangePasswordRequest{
+			Reauthentication: models.Reauthentication{CurrentPassword: current},
+			Password:         "a much better password",
+			Confirm:          "a much better password",
+		})
+	}
+
+	// the current password has to come along
+	assert.Equal(t, http.StatusForbidden, change("").Code)
+	assert.Equal(t, http.StatusForbidden, change("wrongpassword").Code)
+
+	w := request(http.MethodPost, "/auth/change-password", laptop.Token, models.ChangePasswordRequest{
+		Reauthentication: models.Reauthentication{CurrentPassword: "correctpassword"},
+		Password:         "short",
+		Confirm:          "short",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Equal(t, http.StatusOK, change("correctpassword").Code)
+
+	// the phone is signed out, the laptop that changed the password is not
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/users/sessions", phone.Token, nil).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/users/sessions", laptop.Token, nil).Code)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestChangeEmail_Confirm(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := password.DefaultHasher().Hash("correctpassword")
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Email:    "test@test.com",
+		Password: hashed_password,
+		Role:     config.USER,
+		Verified: true,
+	}
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+	mockRepo.On("GetUserByEmail", mock.Anything, "other@test.com").Return(&models.User{ID: primitive.NewObjectID()}, nil)
+	mockRepo.On("GetUserByEmail", mock.Anything, "new@test.com").Return(nil, nil)
+	// the link works while the user still has the old email
+	mockRepo.On("UpdateUserEmail", mock.Anything, testId, "test@test.com", "new@test.com").Return(true, nil).Once()
+	mockRepo.On("UpdateUserEmail", mock.Anything, testId, "test@test.com", "new@test.com").Return(false, nil)
+
+	var mailbox bytes.Buffer
+	service := NewService(mockRepo, []byte("test_jwt_here"), WithMailer(mail.NewLogMailer(&mailbox)))
+	login, request := accountTestRouter(t, service)
+
+	session := login("test", "laptop")
+	change := func(email string) *httptest.ResponseRecorder {
+		return request(http.MethodPost, "/auth/change-email", session.Token, models.ChangeEmailRequest{
+			Reauthentication: models.Reauthentication{CurrentPassword: "correctpassword"},
+			Email:            email,
+		})
+	}
+
+	assert.Equal(t, http.StatusBadRequest, change("not an email").Code)
+	assert.Equal(t, http.StatusConflict, change("other@test.com").Code)
+	assert.Empty(t, mailbox.String())
+
+	w := change("new@test.com")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, mailbox.String(), "new@test.com")
+
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(mailbox.String())
+	assert.Len(t, match, 2)
+	changeToken, _ := url.QueryUnescape(match[1])
+	mailbox.Reset()
+
+	// an access token is no email change link
+	w = request(http.MethodPost, "/auth/change-email/confirm", "", models.ConfirmEmailChangeRequest{Token: session.Token})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = request(http.MethodPost, "/auth/change-email/confirm", "", models.ConfirmEmailChangeRequest{Token: changeToken})
+	assert.Equal(t, http.StatusOK, w.Code)
+	// the old address hears about it
+	assert.Contains(t, mailbox.String(), "test@test.com")
+
+	w = request(http.MethodPost, "/auth/change-email/confirm", "", models.ConfirmEmailChangeRequest{Token: changeToken})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestChangeUsername_KeepsAlias(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := password.DefaultHasher().Hash("correctpassword")
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Email:    "test@test.com",
+		Password: hashed_password,
+		Role:     config.USER,
+		Verified: true,
+	}
+	// after the rename only the alias knows the old name
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil).Once()
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(nil, nil)
+	mockRepo.On("GetUserByUsername", mock.Anything, "renamed").Return(nil, nil)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+	mockRepo.On("UpdateUsername", mock.Anything, testId, "renamed").Return(nil).Once()
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	login, request := accountTestRouter(t, service)
+
+	session := login("test", "laptop")
+
+	w := request(http.MethodPost, "/auth/change-username", session.Token, models.ChangeUsernameRequest{
+		Reauthentication: models.Reauthentication{CurrentPassword: "correctpassword"},
+		Username:         "test",
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = request(http.MethodPost, "/auth/change-username", session.Token, models.ChangeUsernameRequest{
+		Reauthentication: models.Reauthentication{CurrentPassword: "correctpassword"},
+		Username:         "renamed",
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the old name still logs in during the grace period
+	login("test", "phone")
+
+	// and nobody else can register it
+	w = request(http.MethodPost, "/auth/register", "", models.RegisterRequest{
+		Username: "test",
+		Email:    "someone@test.com",
+		Password: "a long enough password",
+		Confirm:  "a long enough password",
+	})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestChangeUsername_LostRaceFreesAlias(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := password.DefaultHasher().Hash("correctpassword")
+	testId := "6592008029c8c3e4dc76256c"
+	parsedID, _ := primitive.ObjectIDFromHex(testId)
+
+	mockUser := &models.User{
+		ID:       parsedID,
+		Username: "test",
+		Email:    "test@test.com",
+		Password: hashed_password,
+		Role:     config.USER,
+		Verified: true,
+	}
+	mockRepo.On("GetUserByUsername", mock.Anything, "test").Return(mockUser, nil)
+	mockRepo.On("GetUserByUsername", mock.Anything, "renamed").Return(nil, nil)
+	mockRepo.On("GetUserById", mock.Anything, testId).Return(mockUser, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, testId, true).Return(nil)
+	// somebody else took the name between the check and the update
+	duplicate := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	mockRepo.On("UpdateUsername", mock.Anything, testId, "renamed").Return(duplicate).Once()
+
+	service := NewService(mockRepo, []byte("test_jwt_here"))
+	login, request := accountTestRouter(t, service)
+
+	session := login("test", "laptop")
+
+	w := request(http.MethodPost, "/auth/change-username", session.Token, models.ChangeUsernameRequest{
+		Reauthentication: models.Reauthentication{CurrentPassword: "correctpassword"},
+		Username:         "renamed",
+	})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// the user is still called test, there is no alias holding on to the name
+	alias, err := service.aliasRepo.GetUsernameAlias(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.Nil(t, alias)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestImpersonation_AuditTrail(t *testing.T) {
+	mockRepo := new(MockAuthRepository)
+
+	hashed_password, _ := password.DefaultHasher().Hash("correctpassword")
+	adminId := "6592008029c8c3e4dc76256c"
+	adminObjectID, _ := primitive.ObjectIDFromHex(adminId)
+	memberId := "6592008029c8c3e4dc76256d"
+	memberObjectID, _ := primitive.ObjectIDFromHex(memberId)
+	otherAdminId := "6592008029c8c3e4dc76256e"
+	otherAdminObjectID, _ := primitive.ObjectIDFromHex(otherAdminId)
+
+	admin := &models.User{ID: adminObjectID, Username: "admin", Password: hashed_password, Role: config.ADMIN, Verified: true}
+	member := &models.User{ID: memberObjectID, Username: "member", Role: config.USER, Verified: true}
+	otherAdmin := &models.User{ID: otherAdminObjectID, Username: "other", Role: config.ADMIN, Verified: true}
+	mockRepo.On("GetUserByUsername", mock.Anything, "admin").Return(admin, nil)
+	mockRepo.On("GetUserById", mock.Anything, memberId).Return(member, nil)
+	mockRepo.On("GetUserById", mock.Anything, otherAdminId).Return(otherAdmin, nil)
+	mockRepo.On("UpdateUserStatus", mock.Anything, adminId, true).Return(nil)
+
+	auditLog := audit.NewMemoryLog()
+	service := NewService(mockRepo, []byte("test_jwt_here"), WithAdminMFARequired(false), WithAuditLog(auditLog))
+	login, request := accountTestRouter(t, service)
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/impersonate", service.AuthMiddleware(), RequireSession(), RequirePermission(PermImpersonateUsers), handler.Impersonate)
+	router.GET("/whoami", service.AuthMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetString("userID"), "actor_id": c.GetString("actorID")})
+	})
+	serve := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	session := login("admin", "laptop")
+
+	// a reason is required and admins are off limits
+	w := serve(http.MethodPost, "/auth/impersonate", session.Token, models.ImpersonateRequest{UserID: memberId})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(http.MethodPost, "/auth/impersonate", session.Token, models.ImpersonateRequest{UserID: otherAdminId, Reason: "ticket 42"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, auditLog.Entries())
+
+	w = serve(http.MethodPost, "/auth/impersonate", session.Token, models.ImpersonateRequest{UserID: memberId, Reason: "ticket 42"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res models.ImpersonationResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, "member", res.Username)
+	assert.Equal(t, int64(config.IMPERSONATION_TOKEN_DURATION.Seconds()), res.ExpiresIn)
+
+	claims, err := service.ValidateToken(res.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, memberId, claims.UserID)
+	assert.Equal(t, &models.Actor{Subject: adminId, Username: "admin"}, claims.Act)
+
+	// requests are flagged and made as the member
+	w = serve(http.MethodGet, "/whoami", res.Token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "admin", w.Header().Get("X-Impersonated-By"))
+	assert.JSONEq(t, `{"user_id": "`+memberId+`", "actor_id": "`+adminId+`"}`, w.Body.String())
+
+	// the admin's own requests are not flagged
+	w = serve(http.MethodGet, "/whoami", session.Token, nil)
+	assert.Empty(t, w.Header().Get("X-Impersonated-By"))
+
+	// sensitive actions are refused
+	w = request(http.MethodPost, "/auth/change-password", res.Token, models.ChangePasswordRequest{Password: "a much better password", Confirm: "a much better password"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(http.MethodPost, "/auth/impersonate", res.Token, models.ImpersonateRequest{UserID: memberId, Reason: "again"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	entries := auditLog.Entries()
+	if assert.Len(t, entries, 4) {
+		assert.Equal(t, audit.ActionImpersonationStart, entries[0].Action)
+		assert.Equal(t, adminId, entries[0].ActorID)
+		assert.Equal(t, memberId, entries[0].SubjectID)
+		assert.Equal(t, "ticket 42", entries[0].Details["reason"])
+
+		for i, want := range []struct {
+			path   string
+			status int
+		}{
+			{"/whoami", http.StatusOK},
+			{"/auth/change-password", http.StatusForbidden},
+			{"/auth/impersonate", http.StatusForbidden},
+		} {
+			entry := entries[i+1]
+			assert.Equal(t, audit.ActionImpersonatedRequest, entry.Action)
+			assert.Equal(t, adminId, entry.ActorID)
+			assert.Equal(t, memberId, entry.SubjectID)
+			assert.Equal(t, want.path, entry.Path)
+			assert.Equal(t, want.status, entry.Status)
+		}
+	}
+
+	mockRepo.AssertExpectations(t)
+}
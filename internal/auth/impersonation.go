@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/audit"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// clients show a banner while this header is on the responses
+const impersonatedByHeader = "X-Impersonated-By"
+
+const maxImpersonationReasonLength = 500
+
+// ImpersonateService lets an admin act as another user for config.IMPERSONATION_TOKEN_DURATION.
+// The token carries the admin in its act claim and belongs to the session of the admin,
+// signing that session out ends the impersonation too.
+// Users that may impersonate others cannot be impersonated themselves.
+func (s *Service) ImpersonateService(ctx context.Context, actor models.Actor, sessionID string, req *models.ImpersonateRequest) (*models.ImpersonationResponse, error) {
+	invalid := &apperr.ValidationError{}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		invalid.Add("reason", "is required")
+	}
+	if len(reason) > maxImpersonationReasonLength {
+		invalid.Add("reason", fmt.Sprintf("must be at most %d characters", maxImpersonationReasonLength))
+	}
+	if err := invalid.ErrOrNil(); err != nil {
+		return nil, err
+	}
+
+	if _, err := primitive.ObjectIDFromHex(req.UserID); err != nil {
+		return nil, ErrUserNotFound
+	}
+	user, err := s.getUser(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID.Hex() == actor.Subject || HasPermission(user.Role, PermImpersonateUsers) {
+		return nil, ErrCannotImpersonate
+	}
+
+	jti, err := generateOpaqueToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(config.IMPERSONATION_TOKEN_DURATION)
+	claims := models.Claims{
+		UserID:    user.ID.Hex(),
+		Username:  user.Username,
+		Role:      user.Role,
+		SessionID: sessionID,
+		Act:       &actor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audienceAccess},
+			ID:        jti,
+		},
+	}
+
+	// no token is handed out that the audit log does not know about
+	client := clientFrom(ctx)
+	entry := audit.Entry{
+		ID:        primitive.NewObjectID(),
+		Action:    audit.ActionImpersonationStart,
+		ActorID:   actor.Subject,
+		SubjectID: user.ID.Hex(),
+		SessionID: sessionID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Details:   map[string]string{"reason": reason, "token_id": jti},
+		CreatedAt: now.UTC(),
+	}
+	if err := s.auditLog.Record(ctx, entry); err != nil {
+		return nil, fmt.Errorf("service: error recording impersonation %v", err)
+	}
+
+	token, err := s.signToken(claims)
+	if err != nil {
+		return nil, fmt.Errorf("service: error in generating token %v", err)
+	}
+
+	return &models.ImpersonationResponse{
+		Message:   "Impersonation started",
+		Token:     token,
+		ExpiresIn: int64(config.IMPERSONATION_TOKEN_DURATION.Seconds()),
+		UserID:    user.ID.Hex(),
+		Username:  user.Username,
+		Role:      user.Role,
+	}, nil
+}
+
+// serveImpersonated flags the response of a request made with an impersonation token,
+// runs the rest of the chain and records the request in the audit log
+func (s *Service) serveImpersonated(c *gin.Context, claims *models.Claims) {
+	c.Header(impersonatedByHeader, claims.Act.Username)
+	c.Next()
+	// errors are rendered on the way out, the audit log needs the status they get
+	apperr.Flush(c)
+
+	entry := audit.Entry{
+		ID:        primitive.NewObjectID(),
+		Action:    audit.ActionImpersonatedRequest,
+		ActorID:   claims.Act.Subject,
+		SubjectID: claims.UserID,
+		SessionID: claims.SessionID,
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Status:    c.Writer.Status(),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Details:   map[string]string{"token_id": claims.ID},
+		CreatedAt: time.Now().UTC(),
+	}
+	// the request has been answered, all that is left is to make the failure visible
+	if err := s.auditLog.Record(context.WithoutCancel(c.Request.Context()), entry); err != nil {
+		log.Printf("Error: could not record impersonated request %s %s of %s: %v", entry.Method, entry.Path, entry.ActorID, err)
+	}
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Every JWT issued by uriel carries an audience naming what it may be used for.
+// A token is only accepted where its audience is expected, so a mailed
+// verification link can never be used as an access token.
+const (
+	audienceAccess            = "uriel:access"
+	audienceEmailVerification = "uriel:verify-email"
+	audienceMFA               = "uriel:mfa"
+	audienceEmailChange       = "uriel:change-email"
+)
+
+const issuer = "uriel"
+
+// iat carries milliseconds, a revocation cut off in whole seconds would either miss the
+// tokens issued earlier in its second or revoke those of a login right after it
+func init() {
+	jwt.TimePrecision = time.Millisecond
+}
+
+// signToken signs with the active key of the keyring, or with the shared secret
+// when no keyring is configured
+func (s *Service) signToken(claims jwt.Claims) (string, error) {
+	var token *jwt.Token
+	var signingKey interface{}
+
+	if s.keyring != nil {
+		key, err := s.keyring.SigningKey()
+		if err != nil {
+			return "", fmt.Errorf("failed to sign token %v", err)
+		}
+		method, err := signingMethod(key)
+		if err != nil {
+			return "", fmt.Errorf("failed to sign token %v", err)
+		}
+		token = jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = key.ID
+		signingKey = key.Private
+	} else {
+		token = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		signingKey = s.jwtSecretKey
+	}
+
+	tokenString, err := token.SignedString(signingKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token %v", err)
+	}
+
+	return tokenString, nil
+}
+
+// parseToken verifies the signature, the dates, the issuer and the audience of a token
+// and decodes it into claims
+func (s *Service) parseToken(tokenString string, claims jwt.Claims, audience string) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if s.keyring != nil {
+			kid, _ := token.Header["kid"].(string)
+			return s.keyring.verificationKey(kid, token.Method.Alg())
+		}
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return s.jwtSecretKey, nil
+	}, jwt.WithAudience(audience), jwt.WithIssuer(issuer))
+
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenMalformed) {
+			return errors.New("token is malformed")
+		} else if errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet) {
+			return errors.New("token has expired or is not yet valid")
+		}
+		return fmt.Errorf("token parsing failed: %w", err)
+	}
+
+	if !token.Valid {
+		return errors.New("invalid token claims or token is not valid")
+	}
+
+	return nil
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithms a SigningKey can use
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// SigningKey is one key of a Keyring.
+// It signs from NotBefore until a newer key takes over, and is published
+// for verification from the moment it is added until it is retired.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	NotBefore time.Time
+	// NotAfter retires the key explicitly, zero means the keyring retires it
+	// once a newer key has been signing for the overlap
+	NotAfter time.Time
+}
+
+// Keyring holds the keys tokens are signed with.
+// Rotating is adding a key with a NotBefore in the future: it is published in the
+// JWKS right away so verifiers can cache it, takes over signing at NotBefore,
+// and the previous key stays valid for the overlap so its tokens do not break.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    []*SigningKey
+	overlap time.Duration
+	now     func() time.Time
+}
+
+// NewKeyring creates a keyring, overlap has to cover the lifetime of the longest lived token
+func NewKeyring(overlap time.Duration, keys ...*SigningKey) (*Keyring, error) {
+	k := &Keyring{overlap: overlap, now: time.Now}
+	for _, key := range keys {
+		if err := k.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Add validates key and adds it to the keyring
+func (k *Keyring) Add(key *SigningKey) error {
+	if key.ID == "" {
+		return errors.New("signing key needs an id")
+	}
+	if _, err := signingMethod(key); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, existing := range k.keys {
+		if existing.ID == key.ID {
+			return fmt.Errorf("duplicate signing key id %q", key.ID)
+		}
+	}
+	k.keys = append(k.keys, key)
+	sort.SliceStable(k.keys, func(i, j int) bool {
+		return k.keys[i].NotBefore.Before(k.keys[j].NotBefore)
+	})
+	return nil
+}
+
+// retiresAt is when key stops being valid, the zero time if that is not known yet.
+// keys must be sorted by NotBefore.
+func (k *Keyring) retiresAt(index int, now time.Time) time.Time {
+	key := k.keys[index]
+	if !key.NotAfter.IsZero() {
+		return key.NotAfter
+	}
+	for _, next := range k.keys[index+1:] {
+		if !next.NotBefore.After(now) && next.NotBefore.After(key.NotBefore) {
+			return next.NotBefore.Add(k.overlap)
+		}
+	}
+	return time.Time{}
+}
+
+func (k *Keyring) published(index int, now time.Time) bool {
+	retiresAt := k.retiresAt(index, now)
+	return retiresAt.IsZero() || now.Before(retiresAt)
+}
+
+// SigningKey returns the key new tokens are signed with
+func (k *Keyring) SigningKey() (*SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := k.now()
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		key := k.keys[i]
+		if key.NotBefore.After(now) || !k.published(i, now) {
+			continue
+		}
+		return key, nil
+	}
+	return nil, errors.New("no active signing key")
+}
+
+// verificationKey returns the public key for kid, if it may still be used with alg
+func (k *Keyring) verificationKey(kid string, alg string) (crypto.PublicKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := k.now()
+	for i, key := range k.keys {
+		if key.ID != kid {
+			continue
+		}
+		// the algorithm comes from the key, never from the token
+		if key.Algorithm != alg {
+			return nil, fmt.Errorf("unexpected signing method: %v", alg)
+		}
+		if !k.published(i, now) {
+			return nil, fmt.Errorf("signing key %q has been retired", kid)
+		}
+		return key.Private.Public(), nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys other services verify uriel tokens with
+func (k *Keyring) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := k.now()
+	set := JWKS{Keys: []JWK{}}
+	for i, key := range k.keys {
+		if !k.published(i, now) {
+			continue
+		}
+
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Algorithm}
+		switch public := key.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func signingMethod(key *SigningKey) (jwt.SigningMethod, error) {
+	switch key.Algorithm {
+	case AlgorithmRS256:
+		rsaKey, ok := key.Private.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key %q: RS256 needs an RSA key", key.ID)
+		}
+		if rsaKey.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("signing key %q: RSA keys need at least 2048 bits", key.ID)
+		}
+		return jwt.SigningMethodRS256, nil
+	case AlgorithmEdDSA:
+		if _, ok := key.Private.(ed25519.PrivateKey); !ok {
+			return nil, fmt.Errorf("signing key %q: EdDSA needs an Ed25519 key", key.ID)
+		}
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("signing key %q: unsupported algorithm %q", key.ID, key.Algorithm)
+}
+
+// GenerateSigningKey creates a new key, it is meant for development and tests
+func GenerateSigningKey(id string, algorithm string, notBefore time.Time) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key %v", err)
+	}
+
+	return &SigningKey{ID: id, Algorithm: algorithm, Private: private, NotBefore: notBefore}, nil
+}
+
+// LoadSigningKeys reads every *.pem file in dir as a private key.
+// The file name without extension is the key id. The optional PEM headers
+// Not-Before and Not-After (RFC 3339) schedule the key, without Not-Before
+// it is active from the modification time of the file.
+func LoadSigningKeys(dir string) ([]*SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*SigningKey
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func loadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		ID:        strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		NotBefore: info.ModTime(),
+	}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm = AlgorithmRS256
+		key.Private = private
+	case ed25519.PrivateKey:
+		key.Algorithm = AlgorithmEdDSA
+		key.Private = private
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	if value, ok := block.Headers["Not-Before"]; ok {
+		if key.NotBefore, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("invalid Not-Before %v", err)
+		}
+	}
+	if value, ok := block.Headers["Not-After"]; ok {
+		if key.NotAfter, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("invalid Not-After %v", err)
+		}
+	}
+
+	return key, nil
+}
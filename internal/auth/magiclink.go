@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/mail"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MagicLinkService mails a single use login link to the owner of email.
+// Like ForgotPasswordService nothing happens for an unknown email and callers must not tell the two apart.
+func (s *Service) MagicLinkService(ctx context.Context, email string) error {
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil {
+		return nil
+	}
+
+	// over the limit is treated like an unknown email
+	if !s.allowMail(ctx, "magic-link", user.Email) {
+		return nil
+	}
+
+	token, err := s.createOneTimeToken(ctx, user.ID.Hex(), models.TokenPurposeMagicLink, config.MAGIC_LINK_DURATION)
+	if err != nil {
+		return err
+	}
+
+	// the link opens the web app which posts the token, mail scanners that follow links cannot use it up
+	link := fmt.Sprintf("%s/magic-link?token=%s", s.publicURL, url.QueryEscape(token))
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Your Uriel login link",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below within the next 15 minutes to log in to Uriel:\n\n%s\n\n"+
+			"The link works once. If you did not ask for it, you can ignore this mail.", user.Username, link),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("service: error sending login link mail %v", err)
+	}
+
+	return nil
+}
+
+// MagicLinkLoginService logs in with the token of a mailed login link.
+// The link stands in for the password, a second factor is still asked for.
+func (s *Service) MagicLinkLoginService(ctx context.Context, token string) (*models.LoginResult, error) {
+	loginToken, err := s.repo.ConsumeOneTimeToken(ctx, models.TokenPurposeMagicLink, hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving login link %v", err)
+	}
+	if loginToken == nil {
+		return nil, ErrInvalidMagicLink
+	}
+
+	user, err := s.repo.GetUserById(ctx, loginToken.UserID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil {
+		return nil, ErrInvalidMagicLink
+	}
+
+	return s.completeLogin(ctx, user)
+}
@@ -0,0 +1,428 @@
+package auth
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/models"
+)
+
+// In-memory repositories.
+// These are used by the tests and as defaults when the service is not given a persistent store.
+// They do not survive restarts and are not shared between instances.
+
+type memoryRefreshTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]models.RefreshToken
+}
+
+func NewMemoryRefreshTokenRepository() RefreshTokenRepository {
+	return &memoryRefreshTokenRepository{tokens: make(map[string]models.RefreshToken)}
+}
+
+func (repo *memoryRefreshTokenRepository) CreateRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (repo *memoryRefreshTokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	token, ok := repo.tokens[tokenHash]
+	if !ok {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+func (repo *memoryRefreshTokenRepository) MarkRefreshTokenUsed(ctx context.Context, tokenHash string, usedAt time.Time) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	token, ok := repo.tokens[tokenHash]
+	if !ok || token.UsedAt != nil || token.RevokedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &usedAt
+	repo.tokens[tokenHash] = token
+	return true, nil
+}
+
+func (repo *memoryRefreshTokenRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	return repo.revokeWhere(func(token models.RefreshToken) bool {
+		return token.FamilyID == familyID
+	})
+}
+
+func (repo *memoryRefreshTokenRepository) RevokeUserTokens(ctx context.Context, userID string) error {
+	return repo.revokeWhere(func(token models.RefreshToken) bool {
+		return token.UserID == userID
+	})
+}
+
+func (repo *memoryRefreshTokenRepository) revokeWhere(match func(models.RefreshToken) bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now().UTC()
+	for hash, token := range repo.tokens {
+		if match(token) && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			repo.tokens[hash] = token
+		}
+	}
+	return nil
+}
+
+type memoryRevocationRepository struct {
+	mu       sync.Mutex
+	revoked  map[string]time.Time
+	sessions map[string]time.Time
+	cutoffs  map[string]time.Time
+}
+
+func NewMemoryRevocationRepository() RevocationRepository {
+	return &memoryRevocationRepository{
+		revoked:  make(map[string]time.Time),
+		sessions: make(map[string]time.Time),
+		cutoffs:  make(map[string]time.Time),
+	}
+}
+
+func (repo *memoryRevocationRepository) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.revoked[jti] = expiresAt
+	return nil
+}
+
+func (repo *memoryRevocationRepository) RevokeSession(ctx context.Context, sessionID string, expiresAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.sessions[sessionID] = expiresAt
+	return nil
+}
+
+func (repo *memoryRevocationRepository) RevokeUserTokens(ctx context.Context, userID string, issuedBefore time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.cutoffs[userID] = issuedBefore
+	return nil
+}
+
+func (repo *memoryRevocationRepository) ConsumeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.revoked[jti]; ok {
+		return false, nil
+	}
+	repo.revoked[jti] = expiresAt
+	return true, nil
+}
+
+func (repo *memoryRevocationRepository) IsTokenRevoked(ctx context.Context, jti string, sessionID string, userID string, issuedAt time.Time) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	// drop entries whose token has expired anyway, same as the TTL index does in mongo
+	now := time.Now()
+	for id, expiresAt := range repo.revoked {
+		if now.After(expiresAt) {
+			delete(repo.revoked, id)
+		}
+	}
+	for id, expiresAt := range repo.sessions {
+		if now.After(expiresAt) {
+			delete(repo.sessions, id)
+		}
+	}
+
+	if _, ok := repo.revoked[jti]; ok {
+		return true, nil
+	}
+	if _, ok := repo.sessions[sessionID]; ok && sessionID != "" {
+		return true, nil
+	}
+	if cutoff, ok := repo.cutoffs[userID]; ok && issuedAt.Before(cutoff) {
+		return true, nil
+	}
+	return false, nil
+}
+
+type memoryPersonalAccessTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]models.PersonalAccessToken
+}
+
+func NewMemoryPersonalAccessTokenRepository() PersonalAccessTokenRepository {
+	return &memoryPersonalAccessTokenRepository{tokens: make(map[string]models.PersonalAccessToken)}
+}
+
+func (repo *memoryPersonalAccessTokenRepository) CreatePersonalAccessToken(ctx context.Context, token models.PersonalAccessToken) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.tokens[token.ID.Hex()] = token
+	return nil
+}
+
+func (repo *memoryPersonalAccessTokenRepository) GetPersonalAccessToken(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, token := range repo.tokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, nil
+}
+
+func (repo *memoryPersonalAccessTokenRepository) ListPersonalAccessTokens(ctx context.Context, userID string) ([]models.PersonalAccessToken, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	tokens := []models.PersonalAccessToken{}
+	for _, token := range repo.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+func (repo *memoryPersonalAccessTokenRepository) DeletePersonalAccessToken(ctx context.Context, userID string, id string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	token, ok := repo.tokens[id]
+	if !ok || token.UserID != userID {
+		return false, nil
+	}
+	delete(repo.tokens, id)
+	return true, nil
+}
+
+func (repo *memoryPersonalAccessTokenRepository) TouchPersonalAccessToken(ctx context.Context, id string, usedAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if token, ok := repo.tokens[id]; ok {
+		token.LastUsedAt = &usedAt
+		repo.tokens[id] = token
+	}
+	return nil
+}
+
+type memorySessionRepository struct {
+	mu       sync.Mutex
+	sessions map[string]models.Session
+}
+
+func NewMemorySessionRepository() SessionRepository {
+	return &memorySessionRepository{sessions: make(map[string]models.Session)}
+}
+
+func (repo *memorySessionRepository) SaveSession(ctx context.Context, session models.Session) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if existing, ok := repo.sessions[session.ID]; ok {
+		existing.IP = session.IP
+		existing.UserAgent = session.UserAgent
+		existing.DeviceInfo = session.DeviceInfo
+		existing.LastSeenAt = session.LastSeenAt
+		existing.ExpiresAt = session.ExpiresAt
+		session = existing
+	}
+	repo.sessions[session.ID] = session
+	return nil
+}
+
+func (repo *memorySessionRepository) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	session, ok := repo.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (repo *memorySessionRepository) ListSessions(ctx context.Context, userID string) ([]models.Session, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now()
+	sessions := []models.Session{}
+	for _, session := range repo.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (repo *memorySessionRepository) RevokeSession(ctx context.Context, id string) error {
+	return repo.revokeWhere(func(session models.Session) bool {
+		return session.ID == id
+	})
+}
+
+func (repo *memorySessionRepository) RevokeUserSessions(ctx context.Context, userID string) error {
+	return repo.revokeWhere(func(session models.Session) bool {
+		return session.UserID == userID
+	})
+}
+
+func (repo *memorySessionRepository) revokeWhere(match func(models.Session) bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now().UTC()
+	for id, session := range repo.sessions {
+		if match(session) && session.RevokedAt == nil {
+			session.RevokedAt = &now
+			repo.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+type memoryGuestRepository struct {
+	mu      sync.Mutex
+	invites map[string]models.GuestInvite
+	guests  map[string]models.Guest
+}
+
+func NewMemoryGuestRepository() GuestRepository {
+	return &memoryGuestRepository{
+		invites: make(map[string]models.GuestInvite),
+		guests:  make(map[string]models.Guest),
+	}
+}
+
+func (repo *memoryGuestRepository) CreateGuestInvite(ctx context.Context, invite models.GuestInvite) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.invites[invite.ID.Hex()] = invite
+	return nil
+}
+
+func (repo *memoryGuestRepository) UseGuestInvite(ctx context.Context, tokenHash string, now time.Time) (*models.GuestInvite, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for id, invite := range repo.invites {
+		if invite.TokenHash != tokenHash {
+			continue
+		}
+		if !now.Before(invite.ExpiresAt) || (invite.MaxUses > 0 && invite.Uses >= invite.MaxUses) {
+			return nil, nil
+		}
+		invite.Uses++
+		repo.invites[id] = invite
+		return &invite, nil
+	}
+	return nil, nil
+}
+
+func (repo *memoryGuestRepository) DeleteGuestInvite(ctx context.Context, id string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.invites[id]; !ok {
+		return false, nil
+	}
+	delete(repo.invites, id)
+	for guestID, guest := range repo.guests {
+		if guest.InviteID == id {
+			delete(repo.guests, guestID)
+		}
+	}
+	return true, nil
+}
+
+func (repo *memoryGuestRepository) CreateGuest(ctx context.Context, guest models.Guest) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.guests[guest.ID] = guest
+	return nil
+}
+
+func (repo *memoryGuestRepository) GetGuest(ctx context.Context, id string) (*models.Guest, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	guest, ok := repo.guests[id]
+	if !ok {
+		return nil, nil
+	}
+	if !time.Now().Before(guest.ExpiresAt) {
+		delete(repo.guests, id)
+		return nil, nil
+	}
+	return &guest, nil
+}
+
+type memoryUsernameAliasRepository struct {
+	mu sync.Mutex
+	// keyed by the lower cased username
+	aliases map[string]models.UsernameAlias
+}
+
+func NewMemoryUsernameAliasRepository() UsernameAliasRepository {
+	return &memoryUsernameAliasRepository{aliases: make(map[string]models.UsernameAlias)}
+}
+
+func (repo *memoryUsernameAliasRepository) SaveUsernameAlias(ctx context.Context, alias models.UsernameAlias) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.aliases[strings.ToLower(alias.Username)] = alias
+	return nil
+}
+
+func (repo *memoryUsernameAliasRepository) GetUsernameAlias(ctx context.Context, username string) (*models.UsernameAlias, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	key := strings.ToLower(username)
+	alias, ok := repo.aliases[key]
+	if !ok {
+		return nil, nil
+	}
+	if !time.Now().Before(alias.ExpiresAt) {
+		delete(repo.aliases, key)
+		return nil, nil
+	}
+	return &alias, nil
+}
+
+func (repo *memoryUsernameAliasRepository) DeleteUsernameAlias(ctx context.Context, username string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	delete(repo.aliases, strings.ToLower(username))
+	return nil
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const recoveryCodeCount = 10
+
+// EnrollMFAService creates a new TOTP secret for the user.
+// The secret is only pending until ConfirmMFAService sees a valid code for it,
+// so an abandoned enrollment never locks anybody out.
+func (s *Service) EnrollMFAService(ctx context.Context, userId string) (*models.MFAEnrollResponse, error) {
+	user, err := s.repo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.MFA.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := user.MFA
+	settings.PendingSecret = secret
+	if err := s.repo.UpdateUserMFA(ctx, userId, settings); err != nil {
+		return nil, fmt.Errorf("service: error updating mfa settings %v", err)
+	}
+
+	return &models.MFAEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(user.Username, secret),
+	}, nil
+}
+
+// ConfirmMFAService enables two factor authentication once a code for the pending secret is presented.
+// It returns the recovery codes, they are only ever shown this once.
+func (s *Service) ConfirmMFAService(ctx context.Context, userId string, code string) ([]string, error) {
+	user, err := s.repo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.MFA.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFA.PendingSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+
+	step, ok := verifyTOTP(user.MFA.PendingSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	settings := models.MFASettings{
+		Enabled:       true,
+		Secret:        user.MFA.PendingSecret,
+		RecoveryCodes: hashes,
+		LastUsedStep:  step,
+		EnabledAt:     &now,
+	}
+	if err := s.repo.UpdateUserMFA(ctx, userId, settings); err != nil {
+		return nil, fmt.Errorf("service: error updating mfa settings %v", err)
+	}
+
+	return codes, nil
+}
+
+// RegenerateRecoveryCodesService replaces all recovery codes, a current TOTP code is required
+func (s *Service) RegenerateRecoveryCodesService(ctx context.Context, userId string, code string) ([]string, error) {
+	user, err := s.repo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if !user.MFA.Enabled {
+		return nil, ErrMFANotEnrolled
+	}
+
+	settings := user.MFA
+	step, ok := verifyTOTP(settings.Secret, code, time.Now())
+	if !ok || step <= settings.LastUsedStep {
+		return nil, ErrInvalidMFACode
+	}
+	used, err := s.repo.UseTOTPStep(ctx, userId, step)
+	if err != nil {
+		return nil, fmt.Errorf("service: error using totp code %v", err)
+	}
+	if !used {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	settings.RecoveryCodes = hashes
+	settings.LastUsedStep = step
+	if err := s.repo.UpdateUserMFA(ctx, userId, settings); err != nil {
+		return nil, fmt.Errorf("service: error updating mfa settings %v", err)
+	}
+
+	return codes, nil
+}
+
+// VerifyMFAService finishes a login that was held back by LoginUserService.
+// The code is either a TOTP code or an unused recovery code.
+func (s *Service) VerifyMFAService(ctx context.Context, mfaToken string, code string) (*models.TokenPair, error) {
+	claims := &models.MFAClaims{}
+	if err := s.parseToken(mfaToken, claims, audienceMFA); err != nil || claims.ID == "" {
+		return nil, ErrInvalidMFAToken
+	}
+
+	// guessing codes is bounded by the same lockout as guessing passwords
+	lockoutKey := "mfa:" + claims.UserID
+	if err := s.checkLockout(ctx, lockoutKey); err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetUserById(ctx, claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil || !user.MFA.Enabled {
+		return nil, ErrInvalidMFAToken
+	}
+
+	used, err := s.useMFACode(ctx, user, code)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		s.failLockout(ctx, lockoutKey)
+		return nil, ErrInvalidMFACode
+	}
+	s.resetLockout(ctx, lockoutKey)
+
+	// used up only once a code was accepted, a typo does not end the login
+	consumed, err := s.revocationRepo.ConsumeToken(ctx, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return nil, fmt.Errorf("service: error using mfa token %v", err)
+	}
+	if !consumed {
+		return nil, ErrInvalidMFAToken
+	}
+
+	if err := s.markOnline(ctx, user); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.IssueTokens(ctx, user, primitive.NewObjectID().Hex(), true)
+	if err != nil {
+		return nil, fmt.Errorf("service: error in generating token %v", err)
+	}
+
+	return tokens, nil
+}
+
+// useMFACode accepts a TOTP code or an unused recovery code and uses it up.
+// Using it up is a conditional update, of two requests with the same code only one gets through.
+func (s *Service) useMFACode(ctx context.Context, user *models.User, code string) (bool, error) {
+	settings := user.MFA
+	if step, ok := verifyTOTP(settings.Secret, code, time.Now()); ok && step > settings.LastUsedStep {
+		// a code is accepted only once, even inside its window
+		used, err := s.repo.UseTOTPStep(ctx, user.ID.Hex(), step)
+		if err != nil {
+			return false, fmt.Errorf("service: error using totp code %v", err)
+		}
+		return used, nil
+	}
+	if index := matchRecoveryCode(settings.RecoveryCodes, code); index >= 0 {
+		used, err := s.repo.UseRecoveryCode(ctx, user.ID.Hex(), settings.RecoveryCodes[index])
+		if err != nil {
+			return false, fmt.Errorf("service: error using recovery code %v", err)
+		}
+		return used, nil
+	}
+	return false, nil
+}
+
+// generateMFAToken creates the short lived token that stands in for a session
+// between the password check and the second factor, it starts one session at most
+func (s *Service) generateMFAToken(userId string) (string, error) {
+	jti, err := generateOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := models.MFAClaims{
+		UserID: userId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.MFA_TOKEN_DURATION)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audienceMFA},
+			Subject:   userId,
+		},
+	}
+
+	return s.signToken(claims)
+}
+
+// generateRecoveryCodes returns the codes to show to the user and the hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	// no 0/o and 1/l so codes can be copied from paper
+	const alphabet = "23456789abcdefghijkmnpqrstuvwxyz"
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code %v", err)
+		}
+		for j, b := range buf {
+			buf[j] = alphabet[int(b)%len(alphabet)]
+		}
+		codes[i] = string(buf[:5]) + "-" + string(buf[5:])
+		hashes[i] = hashToken(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// matchRecoveryCode returns the index of the stored hash matching code, or -1
+func matchRecoveryCode(hashes []string, code string) int {
+	hash := hashToken(strings.ToLower(strings.TrimSpace(code)))
+	for i, stored := range hashes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			return i
+		}
+	}
+	return -1
+}
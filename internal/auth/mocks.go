@@ -39,14 +39,98 @@ func (m *MockAuthRepository) GetUserByEmail(ctx context.Context, email string) (
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-// TODO: Mocks of this
 // GetUserById(ctx context.Context, id string) (*models.User, error)
 func (m *MockAuthRepository) GetUserById(ctx context.Context, id string) (*models.User, error) {
-	return nil, nil
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+// GetUserByIdentity(ctx context.Context, issuer string, subject string) (*models.User, error)
+func (m *MockAuthRepository) GetUserByIdentity(ctx context.Context, issuer string, subject string) (*models.User, error) {
+	args := m.Called(ctx, issuer, subject)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+// AddUserIdentity(ctx context.Context, id string, identity models.Identity) error
+func (m *MockAuthRepository) AddUserIdentity(ctx context.Context, id string, identity models.Identity) error {
+	args := m.Called(ctx, id, identity)
+	return args.Error(0)
+}
+
+// UpdateUserStatus(ctx context.Context, id string, isOnline bool) error
+func (m *MockAuthRepository) UpdateUserStatus(ctx context.Context, id string, isOnline bool) error {
+	args := m.Called(ctx, id, isOnline)
+	return args.Error(0)
+}
+
+// UpdateUserMFA(ctx context.Context, id string, mfa models.MFASettings) error
+func (m *MockAuthRepository) UpdateUserMFA(ctx context.Context, id string, mfa models.MFASettings) error {
+	args := m.Called(ctx, id, mfa)
+	return args.Error(0)
+}
+
+// UseTOTPStep(ctx context.Context, id string, step int64) (bool, error)
+func (m *MockAuthRepository) UseTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	args := m.Called(ctx, id, step)
+	return args.Bool(0), args.Error(1)
+}
+
+// UseRecoveryCode(ctx context.Context, id string, codeHash string) (bool, error)
+func (m *MockAuthRepository) UseRecoveryCode(ctx context.Context, id string, codeHash string) (bool, error) {
+	args := m.Called(ctx, id, codeHash)
+	return args.Bool(0), args.Error(1)
+}
+
+// UpdateUserPassword(ctx context.Context, id string, passwordHash string) error
+func (m *MockAuthRepository) UpdateUserPassword(ctx context.Context, id string, passwordHash string) error {
+	args := m.Called(ctx, id, passwordHash)
+	return args.Error(0)
+}
+
+// UpgradeUserPassword(ctx context.Context, id string, oldHash string, newHash string) error
+func (m *MockAuthRepository) UpgradeUserPassword(ctx context.Context, id string, oldHash string, newHash string) error {
+	args := m.Called(ctx, id, oldHash, newHash)
+	return args.Error(0)
+}
+
+// CreateOneTimeToken(ctx context.Context, token models.OneTimeToken) error
+func (m *MockAuthRepository) CreateOneTimeToken(ctx context.Context, token models.OneTimeToken) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
 }
 
-// UpdateUserStatus(ctx context.Context, id string) error
-func (m *MockAuthRepository) UpdateUserStatus(ctx context.Context, id string) error {
-	return nil
+// ConsumeOneTimeToken(ctx context.Context, purpose string, tokenHash string) (*models.OneTimeToken, error)
+func (m *MockAuthRepository) ConsumeOneTimeToken(ctx context.Context, purpose string, tokenHash string) (*models.OneTimeToken, error) {
+	args := m.Called(ctx, purpose, tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*models.OneTimeToken), args.Error(1)
 }
 
+// UpdateUsername(ctx context.Context, id string, username string) error
+func (m *MockAuthRepository) UpdateUsername(ctx context.Context, id string, username string) error {
+	args := m.Called(ctx, id, username)
+	return args.Error(0)
+}
+
+// UpdateUserEmail(ctx context.Context, id string, oldEmail string, newEmail string) (bool, error)
+func (m *MockAuthRepository) UpdateUserEmail(ctx context.Context, id string, oldEmail string, newEmail string) (bool, error) {
+	args := m.Called(ctx, id, oldEmail, newEmail)
+	return args.Bool(0), args.Error(1)
+}
+
+// MarkEmailVerified(ctx context.Context, id string, email string) (bool, error)
+func (m *MockAuthRepository) MarkEmailVerified(ctx context.Context, id string, email string) (bool, error) {
+	args := m.Called(ctx, id, email)
+	return args.Bool(0), args.Error(1)
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"github.com/palSagnik/uriel/internal/oidc"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDCLoginService starts a single sign-on and returns the url of the identity provider
+func (s *Service) OIDCLoginService() (string, error) {
+	if s.oidcProvider == nil {
+		return "", ErrOIDCNotConfigured
+	}
+
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		return "", err
+	}
+	if err := s.oidcFlows.Save(flow); err != nil {
+		return "", fmt.Errorf("service: error saving login state %v", err)
+	}
+
+	return s.oidcProvider.AuthCodeURL(flow.State, flow.Nonce, flow.CodeChallenge()), nil
+}
+
+// OIDCCallbackService finishes a single sign-on. The identity is linked to
+// the user that has it already, else to the user with the same verified email,
+// else a new user without a password is created for it.
+func (s *Service) OIDCCallbackService(ctx context.Context, state string, code string) (*models.LoginResult, error) {
+	if s.oidcProvider == nil {
+		return nil, ErrOIDCNotConfigured
+	}
+
+	flow, err := s.oidcFlows.Consume(state)
+	if err != nil {
+		return nil, fmt.Errorf("service: error reading login state %v", err)
+	}
+	if flow == nil {
+		return nil, oidc.ErrInvalidState
+	}
+
+	claims, err := s.oidcProvider.Exchange(ctx, code, flow.CodeVerifier, flow.Nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidIDToken) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("service: error exchanging code %v", err)
+	}
+
+	user, err := s.federatedUser(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.completeLogin(ctx, user)
+}
+
+func (s *Service) federatedUser(ctx context.Context, claims *oidc.IDTokenClaims) (*models.User, error) {
+	issuer := s.oidcProvider.Issuer()
+
+	user, err := s.repo.GetUserByIdentity(ctx, issuer, claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	if claims.Email == "" {
+		return nil, ErrOIDCEmailRequired
+	}
+
+	identity := models.Identity{
+		Issuer:   issuer,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		LinkedAt: time.Now().UTC(),
+	}
+
+	existing, err := s.repo.GetUserByEmail(ctx, claims.Email)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if existing != nil {
+		// only an address the provider has verified proves that the account is theirs
+		if !claims.EmailVerified {
+			return nil, ErrOIDCAccountConflict
+		}
+		if err := s.linkIdentity(ctx, existing, identity); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+
+	username, err := s.availableUsername(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	newUser := models.User{
+		ID:         primitive.NewObjectID(),
+		Email:      claims.Email,
+		Username:   username,
+		Role:       config.USER,
+		Verified:   claims.EmailVerified,
+		Identities: []models.Identity{identity},
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if claims.EmailVerified {
+		newUser.VerifiedAt = &now
+	}
+
+	if err := s.repo.CreateUser(ctx, newUser); err != nil {
+		return nil, fmt.Errorf("service: error creating user %v", err)
+	}
+
+	return &newUser, nil
+}
+
+// linkIdentity adds identity to an existing user with the same, provider verified, email
+func (s *Service) linkIdentity(ctx context.Context, user *models.User, identity models.Identity) error {
+	userId := user.ID.Hex()
+
+	// someone who registered with an address they do not own must not keep access
+	// once its owner signs in, so an unverified account loses its password and sessions
+	if !user.Verified {
+		if err := s.repo.UpdateUserPassword(ctx, userId, ""); err != nil {
+			return fmt.Errorf("service: error clearing password %v", err)
+		}
+		if err := s.revokeAllSessions(ctx, userId); err != nil {
+			return err
+		}
+		if _, err := s.repo.MarkEmailVerified(ctx, userId, user.Email); err != nil {
+			return fmt.Errorf("service: error verifying email %v", err)
+		}
+
+		now := time.Now().UTC()
+		user.Password = ""
+		user.Verified = true
+		user.VerifiedAt = &now
+	}
+
+	if err := s.repo.AddUserIdentity(ctx, userId, identity); err != nil {
+		return fmt.Errorf("service: error linking identity %v", err)
+	}
+	user.Identities = append(user.Identities, identity)
+
+	return nil
+}
+
+// availableUsername derives a username from the claims, adding a number if it is taken
+func (s *Service) availableUsername(ctx context.Context, claims *oidc.IDTokenClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = sanitizeUsername(base)
+
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		if !ValidUsername(candidate) {
+			return "", fmt.Errorf("service: derived username %q is not valid", candidate)
+		}
+		taken, err := s.usernameTaken(ctx, candidate, "")
+		if err != nil {
+			return "", fmt.Errorf("service: error checking existing username %v", err)
+		}
+		if !taken {
+			return candidate, nil
+		}
+
+		suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s%04d", base, suffix.Int64())
+	}
+
+	return "", errors.New("service: could not find a free username")
+}
+
+// sanitizeUsername turns a name from the provider into one usernamePattern accepts,
+// short enough to take the four digits added when it is taken
+func sanitizeUsername(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || r == '.' {
+			b.WriteRune(r)
+		}
+	}
+
+	username := strings.TrimLeft(b.String(), "._-")
+	if len(username) > 28 {
+		username = username[:28]
+	}
+	if len(username) < 3 {
+		username = "user" + username
+	}
+	return username
+}
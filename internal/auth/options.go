@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/palSagnik/uriel/internal/audit"
+	"github.com/palSagnik/uriel/internal/mail"
+	"github.com/palSagnik/uriel/internal/oidc"
+	"github.com/palSagnik/uriel/internal/password"
+	"github.com/palSagnik/uriel/internal/presence"
+	"github.com/palSagnik/uriel/internal/ratelimit"
+)
+
+// Option configures the optional collaborators of the auth Service
+type Option func(*Service)
+
+// WithRefreshTokenRepository sets the store used for refresh tokens.
+// Defaults to an in-memory store.
+func WithRefreshTokenRepository(repo RefreshTokenRepository) Option {
+	return func(s *Service) {
+		s.refreshRepo = repo
+	}
+}
+
+// WithRevocationRepository sets the store used for revoked access tokens.
+// Defaults to an in-memory store.
+func WithRevocationRepository(repo RevocationRepository) Option {
+	return func(s *Service) {
+		s.revocationRepo = repo
+	}
+}
+
+// WithPersonalAccessTokenRepository sets the store used for personal access tokens.
+// Defaults to an in-memory store.
+func WithPersonalAccessTokenRepository(repo PersonalAccessTokenRepository) Option {
+	return func(s *Service) {
+		s.patRepo = repo
+	}
+}
+
+// WithSessionRepository sets the store used for the sessions users are signed in with.
+// Defaults to an in-memory store.
+func WithSessionRepository(repo SessionRepository) Option {
+	return func(s *Service) {
+		s.sessionRepo = repo
+	}
+}
+
+// WithGuestRepository sets the store used for guest invites and guests.
+// Defaults to an in-memory store.
+func WithGuestRepository(repo GuestRepository) Option {
+	return func(s *Service) {
+		s.guestRepo = repo
+	}
+}
+
+// WithUsernameAliasRepository sets the store used for previous usernames.
+// Defaults to an in-memory store.
+func WithUsernameAliasRepository(repo UsernameAliasRepository) Option {
+	return func(s *Service) {
+		s.aliasRepo = repo
+	}
+}
+
+// WithAuditLog sets where impersonation is recorded.
+// Defaults to an in-memory log.
+func WithAuditLog(auditLog audit.Log) Option {
+	return func(s *Service) {
+		s.auditLog = auditLog
+	}
+}
+
+// WithPasswordPolicy sets the rules for new passwords.
+// Defaults to password.DefaultPolicy.
+func WithPasswordPolicy(policy password.Policy) Option {
+	return func(s *Service) {
+		s.passwordPolicy = policy
+	}
+}
+
+// WithPasswordHasher sets how passwords are hashed.
+// Defaults to password.DefaultHasher.
+func WithPasswordHasher(hasher password.Hasher) Option {
+	return func(s *Service) {
+		s.hasher = hasher
+	}
+}
+
+// WithMailer sets how mails (password reset links etc.) are delivered.
+// Defaults to writing them to the standard logger.
+func WithMailer(mailer mail.Mailer) Option {
+	return func(s *Service) {
+		s.mailer = mailer
+	}
+}
+
+// WithPublicURL sets the base url used in links that are mailed to users
+func WithPublicURL(publicURL string) Option {
+	return func(s *Service) {
+		s.publicURL = strings.TrimSuffix(publicURL, "/")
+	}
+}
+
+// WithUnverifiedLoginPolicy sets what happens when an unverified account logs in,
+// config.UNVERIFIED_LOGIN_LIMITED (the default) or config.UNVERIFIED_LOGIN_DENY
+func WithUnverifiedLoginPolicy(policy string) Option {
+	return func(s *Service) {
+		s.unverifiedLogin = policy
+	}
+}
+
+// WithRateLimitStore sets the store used for per email limits on mails.
+// Defaults to an in-memory store.
+func WithRateLimitStore(store ratelimit.Store) Option {
+	return func(s *Service) {
+		s.limiter = store
+	}
+}
+
+// WithAdminMFARequired sets whether admins and owners need a second factor to get their role.
+// Without one they are issued member claims. Defaults to true.
+func WithAdminMFARequired(required bool) Option {
+	return func(s *Service) {
+		s.requireAdminMFA = required
+	}
+}
+
+// WithLockout sets the lockout used against password guessing
+func WithLockout(lockout *ratelimit.Lockout) Option {
+	return func(s *Service) {
+		s.lockout = lockout
+	}
+}
+
+// WithOIDCProvider enables single sign-on with an OpenID Connect provider
+func WithOIDCProvider(provider *oidc.Provider) Option {
+	return func(s *Service) {
+		s.oidcProvider = provider
+	}
+}
+
+// WithOIDCFlowStore sets where logins in progress at the provider are kept.
+// Defaults to an in-memory store.
+func WithOIDCFlowStore(store oidc.FlowStore) Option {
+	return func(s *Service) {
+		s.oidcFlows = store
+	}
+}
+
+// WithKeyring signs tokens with the asymmetric keys of keyring instead of the shared secret
+func WithKeyring(keyring *Keyring) Option {
+	return func(s *Service) {
+		s.keyring = keyring
+	}
+}
+
+// WithPresenceBroker sets where signing in and out is published.
+// Defaults to a broker nobody listens to.
+func WithPresenceBroker(broker *presence.Broker) Option {
+	return func(s *Service) {
+		s.presenceBroker = broker
+	}
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/config"
+)
+
+// PERMISSIONS
+const (
+	PermJoinRooms         = "join_rooms"
+	PermCreateRooms       = "create_personal_rooms"
+	PermJoinMeetings      = "join_meetings"
+	PermUseIntegrations   = "use_integrations"
+	PermReadWorkspace     = "read_workspace"
+	PermUpdateProfile     = "update_profile"
+	PermManageWorkspace   = "manage_workspace"
+	PermManageRooms       = "manage_rooms"
+	PermManageUsers       = "manage_users"
+	PermViewAnalytics     = "view_analytics"
+	PermManageAvatars     = "manage_avatars"
+	PermImpersonateUsers  = "impersonate_users"
+	PermDeleteWorkspace   = "delete_workspace"
+	PermManageBilling     = "manage_billing"
+	PermTransferOwnership = "transfer_ownership"
+)
+
+var guestPermissions = []string{
+	PermJoinRooms,
+}
+
+var memberPermissions = append(slices.Clone(guestPermissions),
+	PermCreateRooms,
+	PermJoinMeetings,
+	PermUseIntegrations,
+	PermReadWorkspace,
+	PermUpdateProfile,
+)
+
+var adminPermissions = append(slices.Clone(memberPermissions),
+	PermManageWorkspace,
+	PermManageRooms,
+	PermManageUsers,
+	PermViewAnalytics,
+	PermManageAvatars,
+	PermImpersonateUsers,
+)
+
+var ownerPermissions = append(slices.Clone(adminPermissions),
+	PermDeleteWorkspace,
+	PermManageBilling,
+	PermTransferOwnership,
+)
+
+// rolePermissions is the permission matrix from docs/Discussion.md.
+// Every role has all the permissions of the role below it.
+var rolePermissions = map[string][]string{
+	config.GUEST: guestPermissions,
+	config.USER:  memberPermissions,
+	config.ADMIN: adminPermissions,
+	config.OWNER: ownerPermissions,
+}
+
+// HasPermission reports whether the role grants the permission, unknown roles have none
+func HasPermission(role, permission string) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+// RequireRole allows the request through if the authenticated user has one of the roles.
+// It has to run after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(roles, c.GetString("role")) {
+			abortForbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission allows the request through if the role of the authenticated user
+// grants every one of the permissions. Requests made with a personal access token
+// also need the permissions among the token scopes. It has to run after AuthMiddleware.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Granted(c, permissions...) {
+			abortForbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Granted reports whether the authenticated request has every one of the permissions,
+// for handlers that answer differently depending on them. Like RequirePermission it
+// checks the role and the scopes of a personal access token.
+func Granted(c *gin.Context, permissions ...string) bool {
+	role := c.GetString("role")
+	scopes, scoped := c.Get("scopes")
+	for _, permission := range permissions {
+		if !HasPermission(role, permission) {
+			return false
+		}
+		if scoped && !slices.Contains(scopes.([]string), permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// RequireSession refuses personal access tokens, guests and impersonation, for actions that need the user
+// to have logged in such as managing credentials. It has to run after AuthMiddleware.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("authMethod") != authMethodSession {
+			abortForbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRoomAccess keeps guests to the rooms of their invite, the room is the route parameter param.
+// Everyone else is let through. It has to run after AuthMiddleware.
+func RequireRoomAccess(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rooms, guest := c.Get("rooms")
+		if guest && !slices.Contains(rooms.([]string), c.Param(param)) {
+			abortForbidden(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+func abortForbidden(c *gin.Context) {
+	c.Error(apperr.Forbidden("You do not have permission to perform this action"))
+	c.Abort()
+}
@@ -2,14 +2,101 @@ package auth
 
 import (
 	"context"
+	"time"
 
 	"github.com/palSagnik/uriel/internal/models"
 )
 
 type AuthRepository interface {
+	// CreateUser returns ErrUsernameTaken or ErrEmailTaken when another user has the username or email
 	CreateUser(ctx context.Context, user models.User) error
 	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
 	GetUserById(ctx context.Context, id string) (*models.User, error)
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
-	UpdateUserStatus(ctx context.Context, id string) error
+	GetUserByIdentity(ctx context.Context, issuer string, subject string) (*models.User, error)
+	AddUserIdentity(ctx context.Context, id string, identity models.Identity) error
+	UpdateUserStatus(ctx context.Context, id string, isOnline bool) error
+	UpdateUserPassword(ctx context.Context, id string, passwordHash string) error
+	// UpgradeUserPassword replaces the hash only while it is still oldHash, so a password changed in the meantime is kept
+	UpgradeUserPassword(ctx context.Context, id string, oldHash string, newHash string) error
+	UpdateUserMFA(ctx context.Context, id string, mfa models.MFASettings) error
+	// UseTOTPStep records step as the last TOTP step used while the one recorded is earlier,
+	// it returns false when this step or a later one was used already
+	UseTOTPStep(ctx context.Context, id string, step int64) (bool, error)
+	// UseRecoveryCode removes the hash of a recovery code, it returns false when the code was used already
+	UseRecoveryCode(ctx context.Context, id string, codeHash string) (bool, error)
+	UpdateUsername(ctx context.Context, id string, username string) error
+	// UpdateUserEmail replaces the email only while it is still oldEmail, the new one counts as verified
+	UpdateUserEmail(ctx context.Context, id string, oldEmail string, newEmail string) (bool, error)
+	// MarkEmailVerified only succeeds while the user still has the given email
+	MarkEmailVerified(ctx context.Context, id string, email string) (bool, error)
+	CreateOneTimeToken(ctx context.Context, token models.OneTimeToken) error
+	// ConsumeOneTimeToken marks an unused, unexpired token as used and returns it.
+	// It returns nil if there is no such token.
+	ConsumeOneTimeToken(ctx context.Context, purpose string, tokenHash string) (*models.OneTimeToken, error)
+}
+
+type RefreshTokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	// MarkRefreshTokenUsed returns false if the token was already used or revoked
+	MarkRefreshTokenUsed(ctx context.Context, tokenHash string, usedAt time.Time) (bool, error)
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserTokens(ctx context.Context, userID string) error
+}
+
+// RevocationRepository keeps the ids (jti) of access tokens that were revoked before they expired,
+// the sessions whose access tokens were all revoked, and per user cut off times before which
+// every access token of that user is revoked.
+// Entries only need to live until the tokens they cover would have expired.
+type RevocationRepository interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, sessionID string, expiresAt time.Time) error
+	// RevokeUserTokens revokes the tokens issued before issuedBefore, a whole millisecond like their iat
+	RevokeUserTokens(ctx context.Context, userID string, issuedBefore time.Time) error
+	// ConsumeToken records a single use token (jti) as used, it returns false when it was used already
+	ConsumeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+	// IsTokenRevoked checks all three, sessionID is empty for tokens outside a session
+	IsTokenRevoked(ctx context.Context, jti string, sessionID string, userID string, issuedAt time.Time) (bool, error)
+}
+
+type PersonalAccessTokenRepository interface {
+	CreatePersonalAccessToken(ctx context.Context, token models.PersonalAccessToken) error
+	GetPersonalAccessToken(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error)
+	ListPersonalAccessTokens(ctx context.Context, userID string) ([]models.PersonalAccessToken, error)
+	// DeletePersonalAccessToken returns false if the user has no token with this id
+	DeletePersonalAccessToken(ctx context.Context, userID string, id string) (bool, error)
+	TouchPersonalAccessToken(ctx context.Context, id string, usedAt time.Time) error
+}
+
+type SessionRepository interface {
+	// SaveSession creates the session or, if it exists, updates when and from where it was last seen
+	SaveSession(ctx context.Context, session models.Session) error
+	GetSession(ctx context.Context, id string) (*models.Session, error)
+	// ListSessions returns the sessions of a user that are neither revoked nor expired
+	ListSessions(ctx context.Context, userID string) ([]models.Session, error)
+	RevokeSession(ctx context.Context, id string) error
+	RevokeUserSessions(ctx context.Context, userID string) error
+}
+
+// GuestRepository keeps guest invites and the guests that joined through them.
+// Both are removed once they expire.
+type GuestRepository interface {
+	CreateGuestInvite(ctx context.Context, invite models.GuestInvite) error
+	// UseGuestInvite counts a join against the invite, it returns nil when the invite is unknown, expired or used up
+	UseGuestInvite(ctx context.Context, tokenHash string, now time.Time) (*models.GuestInvite, error)
+	// DeleteGuestInvite removes the invite together with the guests that joined through it
+	DeleteGuestInvite(ctx context.Context, id string) (bool, error)
+	CreateGuest(ctx context.Context, guest models.Guest) error
+	// GetGuest returns nil once the guest has expired or its invite was deleted
+	GetGuest(ctx context.Context, id string) (*models.Guest, error)
+}
+
+// UsernameAliasRepository keeps the previous usernames of users until they expire.
+// Usernames are compared regardless of case.
+type UsernameAliasRepository interface {
+	SaveUsernameAlias(ctx context.Context, alias models.UsernameAlias) error
+	// GetUsernameAlias returns nil when nobody used the username or the alias expired
+	GetUsernameAlias(ctx context.Context, username string) (*models.UsernameAlias, error)
+	DeleteUsernameAlias(ctx context.Context, username string) error
 }
@@ -2,12 +2,64 @@ package auth
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/ratelimit"
 )
 
-func RegisterRoutes(router *gin.RouterGroup, handler *Handler) {
+func RegisterRoutes(router *gin.RouterGroup, handler *Handler, middleware gin.HandlerFunc, limiter ratelimit.Store) {
+	loginLimit := ratelimit.Middleware(limiter, ratelimit.PerMinute(config.LOGIN_RATE_LIMIT), ratelimit.KeyByIP("login"))
+	registerLimit := ratelimit.Middleware(limiter, ratelimit.PerMinute(config.REGISTER_RATE_LIMIT), ratelimit.KeyByIP("register"))
+	mailLimit := ratelimit.Middleware(limiter, ratelimit.PerMinute(config.MAIL_RATE_LIMIT), ratelimit.KeyByIP("mail"))
+	refreshLimit := ratelimit.Middleware(limiter, ratelimit.PerMinute(config.REFRESH_RATE_LIMIT), ratelimit.KeyByIP("refresh"))
+	// credentials can only be managed by someone who logged in, never with a personal access token
+	session := RequireSession()
+	// inviting people is an admin permission in docs/Discussion.md, guests are no different
+	inviteGuests := RequirePermission(PermManageUsers)
+	// impersonation tokens fail the session check, so they cannot start another impersonation
+	impersonate := RequirePermission(PermImpersonateUsers)
+
 	auth := router.Group("/auth")
 	{
-		auth.POST("/register", handler.RegisterUser)
-		auth.POST("/login", handler.LoginUser)
+		auth.POST("/register", registerLimit, handler.RegisterUser)
+		auth.POST("/login", loginLimit, handler.LoginUser)
+		auth.POST("/refresh", refreshLimit, handler.RefreshToken)
+		auth.POST("/logout", middleware, session, handler.LogoutUser)
+		auth.POST("/forgot-password", mailLimit, handler.ForgotPassword)
+		auth.POST("/reset-password", loginLimit, handler.ResetPassword)
+		auth.POST("/magic-link", mailLimit, handler.MagicLink)
+		auth.POST("/magic-link/verify", loginLimit, handler.MagicLinkLogin)
+		auth.POST("/change-password", middleware, session, handler.ChangePassword)
+		auth.POST("/change-email", middleware, session, mailLimit, handler.ChangeEmail)
+		auth.POST("/change-email/confirm", handler.ConfirmEmailChange)
+		auth.POST("/change-username", middleware, session, handler.ChangeUsername)
+		auth.GET("/verify-email", handler.VerifyEmail)
+		auth.POST("/verify-email/resend", mailLimit, handler.ResendVerification)
+		auth.POST("/mfa/enroll", middleware, session, handler.EnrollMFA)
+		auth.POST("/mfa/confirm", middleware, session, handler.ConfirmMFA)
+		auth.POST("/mfa/recovery-codes", middleware, session, handler.RegenerateRecoveryCodes)
+		auth.POST("/mfa/verify", loginLimit, handler.VerifyMFA)
+		auth.GET("/oidc/login", loginLimit, handler.OIDCLogin)
+		auth.GET("/oidc/callback", handler.OIDCCallback)
+		auth.POST("/tokens", middleware, session, handler.CreatePersonalAccessToken)
+		auth.GET("/tokens", middleware, session, handler.ListPersonalAccessTokens)
+		auth.DELETE("/tokens/:id", middleware, session, handler.RevokePersonalAccessToken)
+		auth.POST("/guest-invites", middleware, session, inviteGuests, handler.CreateGuestInvite)
+		auth.DELETE("/guest-invites/:id", middleware, session, inviteGuests, handler.DeleteGuestInvite)
+		auth.POST("/guest/join", loginLimit, handler.JoinAsGuest)
+		auth.POST("/impersonate", middleware, session, impersonate, handler.Impersonate)
+	}
+
+	// sessions belong to the user group of the api but are kept next to the tokens they track
+	users := router.Group("/users")
+	{
+		users.GET("/sessions", middleware, session, handler.ListSessions)
+		users.DELETE("/sessions", middleware, session, handler.SignOutEverywhere)
+		users.DELETE("/sessions/:id", middleware, session, handler.RevokeSession)
 	}
 }
+
+// RegisterWellKnownRoutes registers the routes other services discover uriel with,
+// they live at the root and not under the api version
+func RegisterWellKnownRoutes(router gin.IRouter, handler *Handler) {
+	router.GET("/.well-known/jwks.json", handler.JWKS)
+}
@@ -4,40 +4,91 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/http"
+	"log"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/audit"
 	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/mail"
 	"github.com/palSagnik/uriel/internal/models"
+	"github.com/palSagnik/uriel/internal/oidc"
+	"github.com/palSagnik/uriel/internal/password"
+	"github.com/palSagnik/uriel/internal/presence"
+	"github.com/palSagnik/uriel/internal/ratelimit"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Service struct {
-	repo         AuthRepository
-	jwtSecretKey []byte
+	repo            AuthRepository
+	refreshRepo     RefreshTokenRepository
+	revocationRepo  RevocationRepository
+	patRepo         PersonalAccessTokenRepository
+	sessionRepo     SessionRepository
+	guestRepo       GuestRepository
+	aliasRepo       UsernameAliasRepository
+	auditLog        audit.Log
+	mailer          mail.Mailer
+	publicURL       string
+	unverifiedLogin string
+	requireAdminMFA bool
+	limiter         ratelimit.Store
+	lockout         *ratelimit.Lockout
+	oidcProvider    *oidc.Provider
+	oidcFlows       oidc.FlowStore
+	keyring         *Keyring
+	passwordPolicy  password.Policy
+	hasher          password.Hasher
+	presenceBroker  *presence.Broker
+	jwtSecretKey    []byte
 }
 
-func NewService(repo AuthRepository, jwtSecretKey []byte) *Service {
-	return &Service{
-		repo:         repo,
-		jwtSecretKey: jwtSecretKey,
+func NewService(repo AuthRepository, jwtSecretKey []byte, opts ...Option) *Service {
+	s := &Service{
+		repo:            repo,
+		refreshRepo:     NewMemoryRefreshTokenRepository(),
+		revocationRepo:  NewMemoryRevocationRepository(),
+		patRepo:         NewMemoryPersonalAccessTokenRepository(),
+		sessionRepo:     NewMemorySessionRepository(),
+		guestRepo:       NewMemoryGuestRepository(),
+		aliasRepo:       NewMemoryUsernameAliasRepository(),
+		auditLog:        audit.NewMemoryLog(),
+		mailer:          mail.NewLogMailer(log.Writer()),
+		publicURL:       "http://localhost:8080",
+		unverifiedLogin: config.UNVERIFIED_LOGIN_LIMITED,
+		requireAdminMFA: true,
+		limiter:         ratelimit.NewMemoryStore(),
+		lockout:         ratelimit.NewLockout(ratelimit.NewMemoryLockoutStore(), config.LOCKOUT_THRESHOLD, config.LOCKOUT_DURATION, config.MAX_LOCKOUT_DURATION),
+		oidcFlows:       oidc.NewMemoryFlowStore(),
+		passwordPolicy:  password.DefaultPolicy(),
+		hasher:          password.DefaultHasher(),
+		presenceBroker:  presence.NewBroker(),
+		jwtSecretKey:    jwtSecretKey,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *Service) RegisterUserService(ctx context.Context, req *models.RegisterRequest) (*models.User, error) {
 
-	// check if this username already exists
-	existingUserByUsername, err := s.repo.GetUserByUsername(ctx, req.Username)
-	if err != nil && err != mongo.ErrNoDocuments {
+	if err := s.validateRegistration(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// check if this username already exists, or was recently given up by someone
+	usernameTaken, err := s.usernameTaken(ctx, req.Username, "")
+	if err != nil {
 		return nil, fmt.Errorf("service: error checking existing username %v", err)
 	}
-	if existingUserByUsername != nil {
-		return nil, errors.New("username already exists")
+	if usernameTaken {
+		return nil, ErrUsernameTaken
 	}
 
 	// check if this email already exists
@@ -46,23 +97,21 @@ func (s *Service) RegisterUserService(ctx context.Context, req *models.RegisterR
 		return nil, fmt.Errorf("service: error checking existing email %v", err)
 	}
 	if existingUserByEmail != nil {
-		return nil, errors.New("email already exists")
+		return nil, ErrEmailTaken
 	}
 
 	// hash password
-	// does not accept more than 72 bytes
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hashPassword(req.Password)
 	if err != nil {
 		return nil, fmt.Errorf("service: error hashing password %v", err)
 	}
 
 	// create user
-	// TODO: Errors should be ENUMS
 	newUser := models.User{
 		ID:        primitive.NewObjectID(),
 		Username:  req.Username,
 		Email:     req.Email,
-		Password:  string(hashedPassword),
+		Password:  hashedPassword,
 		Role:      config.USER,
 		IsOnline:  false,
 		AvatarUrl: "",
@@ -71,86 +120,554 @@ func (s *Service) RegisterUserService(ctx context.Context, req *models.RegisterR
 	}
 
 	if err := s.repo.CreateUser(ctx, newUser); err != nil {
+		// another registration got in since the checks above
+		if errors.Is(err, ErrUsernameTaken) || errors.Is(err, ErrEmailTaken) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("service: error in creating new user %v", err)
 	}
+
+	// the account exists at this point, if the mail fails the user can ask for it again
+	if err := s.sendVerificationMail(ctx, &newUser); err != nil {
+		log.Printf("Warning: could not send verification mail to user %s: %v", newUser.ID.Hex(), err)
+	}
+
 	return &newUser, nil
 }
 
-func (s *Service) LoginUserService(ctx context.Context, username string, password string) (string, string, error) {
+// VerifyEmailService marks the email in a verification link as verified.
+// A link stops working once the user has changed their email.
+func (s *Service) VerifyEmailService(ctx context.Context, token string) error {
+	claims := &models.EmailVerificationClaims{}
+	if err := s.parseToken(token, claims, audienceEmailVerification); err != nil {
+		return ErrInvalidVerification
+	}
 
-	// retrieve user
-	user, err := s.repo.GetUserByUsername(ctx, username)
+	verified, err := s.repo.MarkEmailVerified(ctx, claims.UserID, claims.Email)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return "", "", errors.New("invalid username or password")
-		}
-		return "", "", fmt.Errorf("service: error retrieving user %v", err)
+		return fmt.Errorf("service: error verifying email %v", err)
 	}
-	if user == nil {
-		return "", "", errors.New("invalid username or password")
+	if !verified {
+		return ErrInvalidVerification
 	}
 
-	// compare password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", "", errors.New("invalid username or password")
+	return nil
+}
+
+// ResendVerificationService mails a new verification link.
+// Like ForgotPasswordService it does nothing for unknown or already verified emails.
+func (s *Service) ResendVerificationService(ctx context.Context, email string) error {
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil || user.Verified {
+		return nil
 	}
 
-	// update user online status
-	if err := s.repo.UpdateUserStatus(ctx, user.ID.Hex()); err != nil {
-		return "", "", fmt.Errorf("service: error in updating user status %v", err)
+	if !s.allowMail(ctx, "verify-email", user.Email) {
+		return nil
 	}
 
-	// generate Token
-	token, err := s.GenerateToken(user.ID.Hex(), user.Username, user.Role)
+	return s.sendVerificationMail(ctx, user)
+}
+
+// allowMail limits how often a kind of mail can be sent to one address.
+// A failing limiter does not block the mail.
+func (s *Service) allowMail(ctx context.Context, kind string, email string) bool {
+	result, err := s.limiter.Allow(ctx, kind+":email:"+strings.ToLower(email), ratelimit.PerMinute(config.PASSWORD_RESET_RATE_LIMIT))
 	if err != nil {
-		return "", "", fmt.Errorf("service: error in generating token %v", err)
+		log.Printf("Warning: rate limiter failed, allowing mail: %v", err)
+		return true
 	}
-
-	return token, user.ID.Hex(), nil
+	return result.Allowed
 }
 
-func (s *Service) GenerateToken(userId, username, role string) (string, error) {
-	claims := models.Claims{
-		UserID:   userId,
-		Username: username,
-		Role:     role,
+func (s *Service) sendVerificationMail(ctx context.Context, user *models.User) error {
+	claims := models.EmailVerificationClaims{
+		UserID: user.ID.Hex(),
+		Email:  user.Email,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * config.TOKEN_DURATION)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.EMAIL_VERIFICATION_DURATION)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    "uriel",
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audienceEmailVerification},
 		},
 	}
+	token, err := s.signToken(claims)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/v1/auth/verify-email?token=%s", s.publicURL, url.QueryEscape(token))
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Uriel email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm this is your email address by opening the link below "+
+			"within the next 24 hours:\n\n%s\n", user.Username, link),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("service: error sending verification mail %v", err)
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(s.jwtSecretKey)
+	return nil
+}
+
+// checkLockout refuses a key that is locked after too many failures
+func (s *Service) checkLockout(ctx context.Context, key string) error {
+	lockedFor, err := s.lockout.LockedFor(ctx, key)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token %v", err)
+		return fmt.Errorf("service: error checking lockout %v", err)
 	}
+	if lockedFor > 0 {
+		return accountLocked(lockedFor)
+	}
+	return nil
+}
 
-	return tokenString, nil
+// failLockout counts a failure towards the lockout of key.
+// The attempt is refused either way, so an error is only logged.
+func (s *Service) failLockout(ctx context.Context, key string) {
+	if _, err := s.lockout.Fail(ctx, key); err != nil {
+		log.Printf("Warning: could not record a failure for %s: %v", key, err)
+	}
 }
 
-func (s *Service) ValidateToken(tokenString string) (*models.Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// resetLockout forgets the failures of key after a success
+func (s *Service) resetLockout(ctx context.Context, key string) {
+	if err := s.lockout.Reset(ctx, key); err != nil {
+		log.Printf("Warning: could not reset the failures for %s: %v", key, err)
+	}
+}
+
+// LoginUserService checks the credentials of a user, who can be identified by username or email.
+// Accounts with two factor authentication only get an MFA token here,
+// VerifyMFAService exchanges it together with a code for the real tokens.
+func (s *Service) LoginUserService(ctx context.Context, identifier string, password string) (*models.LoginResult, error) {
+	identifier = strings.TrimSpace(identifier)
+
+	// the lockout is keyed on what was typed rather than the user id,
+	// so unknown usernames get locked the same way and do not stand out
+	lockoutKey := "login:" + strings.ToLower(identifier)
+	if err := s.checkLockout(ctx, lockoutKey); err != nil {
+		return nil, err
+	}
+
+	// retrieve user, an identifier with an @ is tried as an email first.
+	// Usernames taken before they were validated may still contain one.
+	var user *models.User
+	var err error
+	if strings.Contains(identifier, "@") {
+		user, err = s.repo.GetUserByEmail(ctx, identifier)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("service: error retrieving user %v", err)
+		}
+	}
+	if user == nil {
+		user, err = s.repo.GetUserByUsername(ctx, identifier)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("service: error retrieving user %v", err)
+		}
+	}
+	// a username given up within the grace period still logs in
+	if user == nil {
+		user, err = s.userByAlias(ctx, identifier)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if user == nil {
+		s.failLockout(ctx, lockoutKey)
+		return nil, ErrInvalidCredentials
+	}
+
+	// compare password, accounts created by single sign-on have none
+	if user.Password == "" {
+		s.failLockout(ctx, lockoutKey)
+		return nil, ErrInvalidCredentials
+	}
+	match, err := s.hasher.Verify(password, user.Password)
+	if err != nil {
+		return nil, fmt.Errorf("service: error verifying password %v", err)
+	}
+	if !match {
+		s.failLockout(ctx, lockoutKey)
+		return nil, ErrInvalidCredentials
+	}
+	s.resetLockout(ctx, lockoutKey)
+
+	// the password is only known right now, so this is when old hashes get replaced
+	if s.hasher.NeedsRehash(user.Password) {
+		s.rehashPassword(ctx, user, password)
+	}
+
+	return s.completeLogin(ctx, user)
+}
+
+// completeLogin runs once the user has proven who they are,
+// through a password or an identity provider
+func (s *Service) completeLogin(ctx context.Context, user *models.User) (*models.LoginResult, error) {
+	// unverified accounts are either refused or get guest claims in IssueTokens
+	if !user.Verified && s.unverifiedLogin == config.UNVERIFIED_LOGIN_DENY {
+		return nil, ErrEmailNotVerified
+	}
+
+	if user.MFA.Enabled {
+		mfaToken, err := s.generateMFAToken(user.ID.Hex())
+		if err != nil {
+			return nil, fmt.Errorf("service: error in generating mfa token %v", err)
+		}
+		return &models.LoginResult{UserID: user.ID.Hex(), MFAToken: mfaToken}, nil
+	}
+
+	if err := s.markOnline(ctx, user); err != nil {
+		return nil, err
+	}
+
+	// generate tokens, a login always starts a new refresh token family
+	tokens, err := s.IssueTokens(ctx, user, primitive.NewObjectID().Hex(), false)
+	if err != nil {
+		return nil, fmt.Errorf("service: error in generating token %v", err)
+	}
+
+	return &models.LoginResult{UserID: user.ID.Hex(), Tokens: tokens}, nil
+}
+
+// ForgotPasswordService mails a password reset link to the owner of email.
+// Nothing happens for an unknown email, callers must not tell the two cases apart.
+func (s *Service) ForgotPasswordService(ctx context.Context, email string) error {
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil {
+		return nil
+	}
+
+	// over the limit is treated like an unknown email
+	if !s.allowMail(ctx, "forgot-password", user.Email) {
+		return nil
+	}
+
+	token, err := s.createOneTimeToken(ctx, user.ID.Hex(), models.TokenPurposePasswordReset, config.PASSWORD_RESET_DURATION)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", s.publicURL, url.QueryEscape(token))
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Uriel password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your Uriel account. "+
+			"If it was you, open the link below within the next hour:\n\n%s\n\n"+
+			"If it was not you, you can ignore this mail.", user.Username, link),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("service: error sending reset mail %v", err)
+	}
+
+	return nil
+}
+
+// ResetPasswordService sets a new password using a reset token.
+// Every existing session of the user is revoked afterwards.
+func (s *Service) ResetPasswordService(ctx context.Context, token string, password string) error {
+	// checked before the token is consumed so the link can be used again with a better password
+	invalid := &apperr.ValidationError{}
+	if err := s.checkPassword(ctx, invalid, password); err != nil {
+		return err
+	}
+	if err := invalid.ErrOrNil(); err != nil {
+		return err
+	}
+
+	resetToken, err := s.repo.ConsumeOneTimeToken(ctx, models.TokenPurposePasswordReset, hashToken(token))
+	if err != nil {
+		return fmt.Errorf("service: error retrieving reset token %v", err)
+	}
+	if resetToken == nil {
+		return ErrInvalidResetToken
+	}
+
+	hashedPassword, err := s.hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("service: error hashing password %v", err)
+	}
+
+	if err := s.repo.UpdateUserPassword(ctx, resetToken.UserID, hashedPassword); err != nil {
+		return fmt.Errorf("service: error updating password %v", err)
+	}
+
+	return s.revokeAllSessions(ctx, resetToken.UserID)
+}
+
+// revokeAllSessions revokes every refresh token and every access token issued so far for a user
+func (s *Service) revokeAllSessions(ctx context.Context, userId string) error {
+	// the sessions are revoked by id as well
+	if err := s.revokeOtherSessions(ctx, userId, ""); err != nil {
+		return err
+	}
+	if err := s.refreshRepo.RevokeUserTokens(ctx, userId); err != nil {
+		return fmt.Errorf("service: error revoking refresh tokens %v", err)
+	}
+	// the iat of a token is in milliseconds, the cut off covers the current one
+	// so a token issued just before is revoked too
+	cutoff := time.Now().UTC().Truncate(time.Millisecond).Add(time.Millisecond)
+	if err := s.revocationRepo.RevokeUserTokens(ctx, userId, cutoff); err != nil {
+		return fmt.Errorf("service: error revoking access tokens %v", err)
+	}
+	if err := s.sessionRepo.RevokeUserSessions(ctx, userId); err != nil {
+		return fmt.Errorf("service: error revoking sessions %v", err)
+	}
+	return nil
+}
+
+func (s *Service) createOneTimeToken(ctx context.Context, userId string, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	record := models.OneTimeToken{
+		ID:        primitive.NewObjectID(),
+		TokenHash: hashToken(token),
+		UserID:    userId,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := s.repo.CreateOneTimeToken(ctx, record); err != nil {
+		return "", fmt.Errorf("service: error storing %s token %v", purpose, err)
+	}
+
+	return token, nil
+}
+
+// hashPassword is the single place passwords get hashed
+func (s *Service) hashPassword(password string) (string, error) {
+	return s.hasher.Hash(password)
+}
+
+// rehashPassword moves the user to the current hashing parameters.
+// A failure is only logged, the login goes ahead and the next one tries again.
+func (s *Service) rehashPassword(ctx context.Context, user *models.User, password string) {
+	hashedPassword, err := s.hashPassword(password)
+	if err != nil {
+		log.Printf("Warning: could not rehash password of user %s: %v", user.ID.Hex(), err)
+		return
+	}
+
+	if err := s.repo.UpgradeUserPassword(ctx, user.ID.Hex(), user.Password, hashedPassword); err != nil {
+		log.Printf("Warning: could not store rehashed password of user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	user.Password = hashedPassword
+}
+
+// LogoutService revokes the access token identified by jti and ends its session,
+// along with the refresh token family of refreshToken if one is given. The user is marked as offline.
+func (s *Service) LogoutService(ctx context.Context, userId string, jti string, sessionID string, expiresAt time.Time, refreshToken string) error {
+	if err := s.revocationRepo.RevokeToken(ctx, jti, expiresAt); err != nil {
+		return fmt.Errorf("service: error revoking token %v", err)
+	}
+
+	// other access tokens of the session, such as one from before the last refresh, end with it
+	if sessionID != "" {
+		if err := s.revokeSession(ctx, sessionID); err != nil {
+			return err
+		}
+	}
+
+	if refreshToken != "" {
+		stored, err := s.refreshRepo.GetRefreshToken(ctx, hashToken(refreshToken))
+		if err != nil {
+			return fmt.Errorf("service: error retrieving refresh token %v", err)
+		}
+		// a refresh token of some other user is ignored rather than revoked
+		if stored != nil && stored.UserID == userId {
+			if err := s.refreshRepo.RevokeTokenFamily(ctx, stored.FamilyID); err != nil {
+				return fmt.Errorf("service: error revoking token family %v", err)
+			}
 		}
-		return s.jwtSecretKey, nil
+	}
+
+	return s.markOffline(ctx, userId)
+}
+
+// markOnline flags the user as signed in and tells the others
+func (s *Service) markOnline(ctx context.Context, user *models.User) error {
+	if err := s.repo.UpdateUserStatus(ctx, user.ID.Hex(), true); err != nil {
+		return fmt.Errorf("service: error in updating user status %v", err)
+	}
+	user.IsOnline = true
+	if user.Presence != nil {
+		now := time.Now().UTC()
+		user.Presence.AutoStatus, user.Presence.AutoMessage, user.Presence.LastActiveAt = "", "", &now
+	}
+	s.presenceBroker.Publish(presence.StatusChanged(user))
+	return nil
+}
+
+// markOffline flags the user as signed out and tells the others
+func (s *Service) markOffline(ctx context.Context, userId string) error {
+	if err := s.repo.UpdateUserStatus(ctx, userId, false); err != nil {
+		return fmt.Errorf("service: error in updating user status %v", err)
+	}
+	s.presenceBroker.Publish(presence.Event{
+		Type:     presence.EventStatusChanged,
+		UserID:   userId,
+		Presence: models.PresenceStatus{Status: models.StatusOffline},
+		At:       time.Now().UTC(),
 	})
+	return nil
+}
+
+// RefreshTokens exchanges a refresh token for a new access and refresh token pair.
+// Refresh tokens are single use. Presenting one that was already rotated means it
+// has leaked, so the whole family is revoked and the user has to login again.
+func (s *Service) RefreshTokens(ctx context.Context, refreshToken string) (*models.TokenPair, error) {
+	tokenHash := hashToken(refreshToken)
+
+	stored, err := s.refreshRepo.GetRefreshToken(ctx, tokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving refresh token %v", err)
+	}
+	if stored == nil || stored.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if stored.UsedAt != nil {
+		if err := s.refreshRepo.RevokeTokenFamily(ctx, stored.FamilyID); err != nil {
+			return nil, fmt.Errorf("service: error revoking token family %v", err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
 
+	// another request may have rotated this token between the read and now
+	marked, err := s.refreshRepo.MarkRefreshTokenUsed(ctx, tokenHash, time.Now().UTC())
 	if err != nil {
-		if errors.Is(err, jwt.ErrTokenMalformed) {
-			return nil, errors.New("token is malformed")
-		} else if errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet) {
-			return nil, errors.New("token has expired or is not yet valid")
+		return nil, fmt.Errorf("service: error updating refresh token %v", err)
+	}
+	if !marked {
+		if err := s.refreshRepo.RevokeTokenFamily(ctx, stored.FamilyID); err != nil {
+			return nil, fmt.Errorf("service: error revoking token family %v", err)
 		}
-		return nil, fmt.Errorf("token parsing failed: %w", err)
+		return nil, ErrRefreshTokenReused
+	}
+
+	// re-read the user so that role changes are picked up on refresh
+	user, err := s.repo.GetUserById(ctx, stored.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	tokens, err := s.IssueTokens(ctx, user, stored.FamilyID, stored.MFA)
+	if err != nil {
+		return nil, fmt.Errorf("service: error in generating token %v", err)
+	}
+
+	return tokens, nil
+}
+
+// IssueTokens creates an access token and a refresh token belonging to familyID.
+// Until the email is verified the access token only carries the guest role,
+// the full role is picked up by the first refresh after verification.
+// mfa tells whether the login passed a second factor, admins and owners
+// only get their role with one when requireAdminMFA is set.
+func (s *Service) IssueTokens(ctx context.Context, user *models.User, familyID string, mfa bool) (*models.TokenPair, error) {
+	role, enrollmentRequired := s.effectiveRole(user, mfa)
+
+	now := time.Now().UTC()
+	if err := s.recordSession(ctx, user.ID.Hex(), familyID, now); err != nil {
+		return nil, err
 	}
 
-	claims, ok := token.Claims.(*models.Claims)
-	if !ok || !token.Valid {
-		return nil, errors.New("invalid token claims or token is not valid")
+	accessToken, err := s.generateToken(user.ID.Hex(), user.Username, role, mfa, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.RefreshToken{
+		ID:        primitive.NewObjectID(),
+		TokenHash: hashToken(refreshToken),
+		FamilyID:  familyID,
+		UserID:    user.ID.Hex(),
+		MFA:       mfa,
+		ExpiresAt: now.Add(config.REFRESH_TOKEN_DURATION),
+		CreatedAt: now,
+	}
+	if err := s.refreshRepo.CreateRefreshToken(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token %v", err)
+	}
+
+	return &models.TokenPair{
+		UserID:                user.ID.Hex(),
+		Role:                  role,
+		AccessToken:           accessToken,
+		RefreshToken:          refreshToken,
+		ExpiresAt:             now.Add(config.ACCESS_TOKEN_DURATION),
+		MFAEnrollmentRequired: enrollmentRequired,
+	}, nil
+}
+
+// effectiveRole is the role a login gets, which can be less than the role of the user.
+// enrollmentRequired is set when the admin role was withheld for lack of a second factor.
+func (s *Service) effectiveRole(user *models.User, mfa bool) (role string, enrollmentRequired bool) {
+	if !user.Verified {
+		return config.GUEST, false
+	}
+	if s.requireAdminMFA && !mfa && (user.Role == config.ADMIN || user.Role == config.OWNER) {
+		return config.USER, true
+	}
+	return user.Role, false
+}
+
+func (s *Service) GenerateToken(userId, username, role string) (string, error) {
+	return s.generateToken(userId, username, role, false, "")
+}
+
+func (s *Service) generateToken(userId, username, role string, mfa bool, sessionID string) (string, error) {
+	jti, err := generateOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := models.Claims{
+		UserID:    userId,
+		Username:  username,
+		Role:      role,
+		MFA:       mfa,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(config.ACCESS_TOKEN_DURATION)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audienceAccess},
+			ID:        jti,
+		},
+	}
+
+	return s.signToken(claims)
+}
+
+func (s *Service) ValidateToken(tokenString string) (*models.Claims, error) {
+	claims := &models.Claims{}
+	if err := s.parseToken(tokenString, claims, audienceAccess); err != nil {
+		return nil, err
 	}
 
 	return claims, nil
@@ -160,37 +677,82 @@ func (s *Service) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// get the token
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorisation header",
-			})
-			c.Abort()
-			return
-		}
-
 		if !strings.HasPrefix(authHeader, "Bearer ") {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorisation header",
-			})
+			c.Error(apperr.Unauthorized("Invalid authorisation header"))
 			c.Abort()
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
+		if strings.HasPrefix(tokenString, patPrefix) {
+			s.authenticateWithPersonalAccessToken(c, tokenString)
+			return
+		}
+
 		// validate token
 		claims, err := s.ValidateToken(tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": err.Error(),
-			})
+			c.Error(apperr.Unauthorized(err.Error()))
+			c.Abort()
+			return
+		}
+
+		// check if the token was revoked by a logout
+		// tokens without an id cannot be revoked so they are not accepted either
+		if claims.ID == "" {
+			c.Error(ErrTokenRevoked)
+			c.Abort()
+			return
+		}
+
+		revoked, err := s.revocationRepo.IsTokenRevoked(c, claims.ID, claims.SessionID, claims.UserID, claims.IssuedAt.Time)
+		if err != nil {
+			c.Error(apperr.Wrap(err, "failed to validate token"))
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.Error(ErrTokenRevoked)
 			c.Abort()
 			return
 		}
 
+		// guests are removed when their invite is deleted, their tokens go with them
+		authMethod := authMethodSession
+		if len(claims.Rooms) > 0 {
+			guest, err := s.guestRepo.GetGuest(c, claims.UserID)
+			if err != nil {
+				c.Error(apperr.Wrap(err, "failed to validate token"))
+				c.Abort()
+				return
+			}
+			if guest == nil {
+				c.Error(ErrTokenRevoked)
+				c.Abort()
+				return
+			}
+			authMethod = authMethodGuest
+			c.Set("rooms", claims.Rooms)
+		}
+		if claims.Act != nil {
+			authMethod = authMethodImpersonation
+			c.Set("actorID", claims.Act.Subject)
+		}
+
 		c.Set("userID", claims.UserID)
 		c.Set("username", claims.Username)
+		c.Set("role", claims.Role)
+		c.Set("mfa", claims.MFA)
+		c.Set("jti", claims.ID)
+		c.Set("sessionID", claims.SessionID)
+		c.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		c.Set("authMethod", authMethod)
 
+		if claims.Act != nil {
+			s.serveImpersonated(c, claims)
+			return
+		}
 		c.Next()
 	}
 }
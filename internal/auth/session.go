@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+)
+
+// recordSession creates or refreshes the session with the id familyID,
+// the client is taken from ctx
+func (s *Service) recordSession(ctx context.Context, userId string, familyID string, now time.Time) error {
+	client := clientFrom(ctx)
+
+	session := models.Session{
+		ID:         familyID,
+		UserID:     userId,
+		DeviceInfo: parseUserAgent(client.UserAgent),
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(config.REFRESH_TOKEN_DURATION),
+	}
+	if err := s.sessionRepo.SaveSession(ctx, session); err != nil {
+		return fmt.Errorf("failed to store session %v", err)
+	}
+	return nil
+}
+
+// ListSessionsService returns where the user is signed in, currentSessionID is marked as current
+func (s *Service) ListSessionsService(ctx context.Context, userId string, currentSessionID string) ([]models.SessionResponse, error) {
+	sessions, err := s.sessionRepo.ListSessions(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("service: error listing sessions %v", err)
+	}
+
+	response := []models.SessionResponse{}
+	for _, session := range sessions {
+		response = append(response, models.SessionResponse{
+			ID:         session.ID,
+			DeviceInfo: session.DeviceInfo,
+			IP:         session.IP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == currentSessionID,
+		})
+	}
+	return response, nil
+}
+
+// RevokeSessionService signs the user out of one session
+func (s *Service) RevokeSessionService(ctx context.Context, userId string, sessionID string) error {
+	session, err := s.sessionRepo.GetSession(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("service: error retrieving session %v", err)
+	}
+	// sessions of other users look the same as missing ones
+	if session == nil || session.UserID != userId || session.RevokedAt != nil {
+		return ErrSessionNotFound
+	}
+
+	return s.revokeSession(ctx, sessionID)
+}
+
+// SignOutEverywhereService ends every session of the user, including the current one
+func (s *Service) SignOutEverywhereService(ctx context.Context, userId string) error {
+	if err := s.revokeAllSessions(ctx, userId); err != nil {
+		return err
+	}
+
+	return s.markOffline(ctx, userId)
+}
+
+// revokeSession stops the refresh tokens of a session and rejects its access tokens
+func (s *Service) revokeSession(ctx context.Context, sessionID string) error {
+	if err := s.refreshRepo.RevokeTokenFamily(ctx, sessionID); err != nil {
+		return fmt.Errorf("service: error revoking token family %v", err)
+	}
+	if err := s.revocationRepo.RevokeSession(ctx, sessionID, time.Now().Add(config.ACCESS_TOKEN_DURATION)); err != nil {
+		return fmt.Errorf("service: error revoking session tokens %v", err)
+	}
+	if err := s.sessionRepo.RevokeSession(ctx, sessionID); err != nil {
+		return fmt.Errorf("service: error revoking session %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	netmail "net/mail"
+	"regexp"
+
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/models"
+)
+
+// usernamePattern allows 3 to 32 letters, digits, dots, dashes and underscores, starting with a letter or digit
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{2,31}$`)
+
+// the longest address that fits in the path of an smtp message (RFC 5321)
+const maxEmailLength = 254
+
+// validateRegistration checks every field of a registration so all problems are reported at once
+func (s *Service) validateRegistration(ctx context.Context, req *models.RegisterRequest) error {
+	invalid := &apperr.ValidationError{}
+
+	validateUsername(invalid, req.Username)
+	validateEmail(invalid, req.Email)
+
+	if err := s.checkPassword(ctx, invalid, req.Password); err != nil {
+		return err
+	}
+
+	return invalid.ErrOrNil()
+}
+
+// ValidUsername tells whether a username would be accepted today, accounts from before may not be
+func ValidUsername(username string) bool {
+	return usernamePattern.MatchString(username)
+}
+
+func validateUsername(invalid *apperr.ValidationError, username string) {
+	if !usernamePattern.MatchString(username) {
+		invalid.Add("username", "must be 3 to 32 letters, digits, dots, dashes or underscores and start with a letter or digit")
+	}
+}
+
+// validateEmail only accepts a bare address, it has to be deliverable since it gets a confirmation link
+func validateEmail(invalid *apperr.ValidationError, email string) {
+	if address, err := netmail.ParseAddress(email); err != nil || address.Address != email || len(email) > maxEmailLength {
+		invalid.Add("email", "must be a valid email address")
+	}
+}
+
+// checkPassword adds the ways password breaks the password policy to invalid
+func (s *Service) checkPassword(ctx context.Context, invalid *apperr.ValidationError, password string) error {
+	problems, err := s.passwordPolicy.Check(ctx, password)
+	if err != nil {
+		return fmt.Errorf("service: error checking password %v", err)
+	}
+	if len(problems) > 0 {
+		invalid.Add("password", problems...)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package avatar
+
+import (
+	"fmt"
+
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/config"
+)
+
+var ErrAvatarNotFound = apperr.NotFound("avatar not found")
+
+var ErrImageTooLarge = apperr.TooLarge(fmt.Sprintf("avatar must be at most %d MB", config.MAX_AVATAR_UPLOAD_BYTES>>20))
+var ErrTooManyPixels = apperr.TooLarge(fmt.Sprintf("avatar must be at most %d megapixels", config.MAX_AVATAR_PIXELS/1_000_000))
+var ErrNotAnImage = apperr.UnsupportedType("avatar must be a PNG, JPEG or GIF image")
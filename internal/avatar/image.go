@@ -0,0 +1,258 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/palSagnik/uriel/internal/config"
+)
+
+// ThumbnailSizes are the sides of the square images made of every upload, largest first.
+// The largest one becomes the avatar_url of the user.
+var ThumbnailSizes = []int{256, 128, 64}
+
+// Thumbnail is a square PNG Size pixels wide
+type Thumbnail struct {
+	Size int
+	Data []byte
+}
+
+// formats are the sniffed content types accepted, with the name image.Decode gives them
+var formats = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpeg",
+	"image/gif":  "gif",
+}
+
+// Thumbnails checks that r is an image and makes a thumbnail of it for each of the ThumbnailSizes.
+// The type is sniffed from the content, whatever the client called the file. The thumbnails are
+// encoded anew from the pixels alone so EXIF and other metadata never leave the server,
+// the orientation EXIF asks for is applied first. Animated GIFs keep their first frame.
+func Thumbnails(r io.Reader) ([]Thumbnail, error) {
+	data, err := io.ReadAll(io.LimitReader(r, config.MAX_AVATAR_UPLOAD_BYTES+1))
+	if err != nil {
+		return nil, fmt.Errorf("avatar: read upload: %w", err)
+	}
+	if len(data) > config.MAX_AVATAR_UPLOAD_BYTES {
+		return nil, ErrImageTooLarge
+	}
+
+	format, ok := formats[http.DetectContentType(data)]
+	if !ok {
+		return nil, ErrNotAnImage
+	}
+
+	// the header is enough to refuse images that would take too much memory to decode
+	header, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decoded != format || header.Width <= 0 || header.Height <= 0 {
+		return nil, ErrNotAnImage
+	}
+	if int64(header.Width)*int64(header.Height) > config.MAX_AVATAR_PIXELS {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotAnImage
+	}
+
+	// cropping the middle first is the same as cropping it after turning the image
+	square := squareCrop(img)
+	if format == "jpeg" {
+		square = orient(square, exifOrientation(data))
+	}
+
+	thumbnails := make([]Thumbnail, 0, len(ThumbnailSizes))
+	for _, size := range ThumbnailSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, resize(square, size)); err != nil {
+			return nil, fmt.Errorf("avatar: encode thumbnail: %w", err)
+		}
+		thumbnails = append(thumbnails, Thumbnail{Size: size, Data: buf.Bytes()})
+	}
+	return thumbnails, nil
+}
+
+// squareCrop is the largest square in the middle of img
+func squareCrop(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	from := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, from, draw.Src)
+	return square
+}
+
+// orient turns and mirrors the square src the way the EXIF orientation asks for,
+// 1 is upright already
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	last := src.Bounds().Dx() - 1
+	dst := image.NewRGBA(src.Bounds())
+	for y := 0; y <= last; y++ {
+		for x := 0; x <= last; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = last-x, y
+			case 3: // upside down
+				sx, sy = last-x, last-y
+			case 4: // upside down and mirrored
+				sx, sy = x, last-y
+			case 5: // on its side and mirrored
+				sx, sy = y, x
+			case 6: // turned anticlockwise, needs a quarter turn clockwise
+				sx, sy = y, last-x
+			case 7: // on the other side and mirrored
+				sx, sy = last-y, last-x
+			case 8: // turned clockwise, needs a quarter turn anticlockwise
+				sx, sy = last-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+// contribution is how much of a source pixel goes into a resized one
+type contribution struct {
+	index  int
+	weight float64
+}
+
+// boxWeights spreads from pixels over to pixels, every resized pixel is the average
+// of the source pixels it covers, weighted by how much of them it covers
+func boxWeights(from int, to int) [][]contribution {
+	scale := float64(from) / float64(to)
+	weights := make([][]contribution, to)
+	for i := range weights {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		for j := int(start); j < from && float64(j) < end; j++ {
+			if covered := min(end, float64(j+1)) - max(start, float64(j)); covered > 0 {
+				weights[i] = append(weights[i], contribution{index: j, weight: covered / scale})
+			}
+		}
+	}
+	return weights
+}
+
+// resize scales the square src to size pixels a side, rows first and then columns.
+// The pixels are premultiplied so transparent ones do not darken their neighbours.
+func resize(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	weights := boxWeights(side, size)
+
+	rows := make([]float64, side*size*4)
+	for y := 0; y < side; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, contributions := range weights {
+			out := rows[(y*size+x)*4:]
+			for _, c := range contributions {
+				for channel := 0; channel < 4; channel++ {
+					out[channel] += c.weight * float64(row[c.index*4+channel])
+				}
+			}
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y, contributions := range weights {
+		for x := 0; x < size; x++ {
+			var sum [4]float64
+			for _, c := range contributions {
+				for channel := 0; channel < 4; channel++ {
+					sum[channel] += c.weight * rows[(c.index*size+x)*4+channel]
+				}
+			}
+			out := dst.Pix[y*dst.Stride+x*4:]
+			for channel := 0; channel < 4; channel++ {
+				out[channel] = uint8(min(255, math.Round(sum[channel])))
+			}
+		}
+	}
+	return dst
+}
+
+// exifOrientation reads the orientation from the EXIF of a JPEG, 1 when it has none.
+// EXIF sits in an APP1 segment ahead of the image data.
+func exifOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// padding ahead of a marker
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation looks for the orientation tag in the first directory of the TIFF structure EXIF uses
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	directory := int(order.Uint32(tiff[4:]))
+	if directory < 8 || directory+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[directory:]))
+	for i := 0; i < entries; i++ {
+		entry := directory + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) != 0x0112 {
+			continue
+		}
+		// a single SHORT, kept in the first two bytes of the value
+		if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+			return orientation
+		}
+		return 1
+	}
+	return 1
+}
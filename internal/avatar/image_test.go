@@ -0,0 +1,147 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// halves is a w×h image, red on the left and blue on the right
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.SetRGBA(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.SetRGBA(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// withOrientation puts an EXIF segment with the orientation right after the start of the JPEG
+func withOrientation(jpg []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+func TestThumbnails_SquareSizes(t *testing.T) {
+	thumbnails, err := Thumbnails(bytes.NewReader(encodePNG(t, halves(400, 200))))
+	require.NoError(t, err)
+	require.Len(t, thumbnails, len(ThumbnailSizes))
+
+	for i, thumbnail := range thumbnails {
+		assert.Equal(t, ThumbnailSizes[i], thumbnail.Size)
+
+		img, err := png.Decode(bytes.NewReader(thumbnail.Data))
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, thumbnail.Size, thumbnail.Size), img.Bounds())
+
+		// the middle of the image is kept, half of it red and half blue
+		r, _, b, _ := img.At(thumbnail.Size/4, thumbnail.Size/2).RGBA()
+		assert.Greater(t, r, b)
+		r, _, b, _ = img.At(thumbnail.Size*3/4, thumbnail.Size/2).RGBA()
+		assert.Greater(t, b, r)
+	}
+}
+
+func TestThumbnails_UpscalesSmallImages(t *testing.T) {
+	thumbnails, err := Thumbnails(bytes.NewReader(encodePNG(t, halves(10, 10))))
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(thumbnails[0].Data))
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, img.At(0, 0))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, img.At(255, 255))
+}
+
+func TestThumbnails_AppliesAndStripsExif(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, halves(64, 32), &jpeg.Options{Quality: 95}))
+	upload := withOrientation(buf.Bytes(), 6)
+	require.Equal(t, 6, exifOrientation(upload))
+
+	thumbnails, err := Thumbnails(bytes.NewReader(upload))
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(thumbnails[0].Data))
+	require.NoError(t, err)
+
+	// a quarter turn clockwise brings the red left half to the top
+	r, _, b, _ := img.At(224, 32).RGBA()
+	assert.Greater(t, r, b)
+	r, _, b, _ = img.At(32, 224).RGBA()
+	assert.Greater(t, b, r)
+
+	for _, thumbnail := range thumbnails {
+		assert.False(t, bytes.Contains(thumbnail.Data, []byte("Exif")))
+	}
+}
+
+func TestExifOrientation_WithoutExif(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, halves(8, 8), nil))
+	assert.Equal(t, 1, exifOrientation(buf.Bytes()))
+	assert.Equal(t, 1, exifOrientation(withOrientation(buf.Bytes(), 42)))
+	assert.Equal(t, 1, exifOrientation([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF}))
+}
+
+func TestThumbnails_RefusesWhatIsNotAnImage(t *testing.T) {
+	tests := map[string][]byte{
+		"text":      []byte("definitely not a picture"),
+		"html":      []byte("<html><body><img src=x onerror=alert(1)></body></html>"),
+		"truncated": encodePNG(t, halves(40, 40))[:60],
+		"empty":     {},
+	}
+	for name, upload := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Thumbnails(bytes.NewReader(upload))
+			assert.ErrorIs(t, err, ErrNotAnImage)
+		})
+	}
+}
+
+func TestThumbnails_RefusesLargeUploads(t *testing.T) {
+	upload := append(encodePNG(t, halves(8, 8)), bytes.Repeat([]byte{0}, config.MAX_AVATAR_UPLOAD_BYTES)...)
+	_, err := Thumbnails(bytes.NewReader(upload))
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+
+	// a few bytes claiming a huge image are refused before it is decoded,
+	// a 20 megapixel photo as well as a decompression bomb
+	for _, size := range [][2]uint32{{5_000, 4_000}, {100_000, 100_000}} {
+		huge := encodePNG(t, halves(8, 8))
+		binary.BigEndian.PutUint32(huge[16:], size[0])
+		binary.BigEndian.PutUint32(huge[20:], size[1])
+		binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+		_, err = Thumbnails(bytes.NewReader(huge))
+		assert.ErrorIs(t, err, ErrTooManyPixels)
+	}
+}
@@ -9,4 +9,6 @@ import (
 type AvatarRepository interface {
 	GetAvatarUrlById(ctx context.Context, id string) (string, error)
 	GetAvatars(ctx context.Context) ([]models.Avatar, error)
+	CreateAvatar(ctx context.Context, avatar models.Avatar) error
+	DeleteAvatar(ctx context.Context, id string) error
 }
\ No newline at end of file
@@ -0,0 +1,22 @@
+// Package blob stores uploaded files and hands out the URLs they are served from.
+//
+// LocalStore keeps them on disk for a single instance, deployments with several
+// instances implement Store on top of shared object storage instead.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrInvalidKey = errors.New("blob: invalid key")
+
+// Store keeps files under keys such as "avatars/<user id>/<upload id>/256.png".
+// Keys are slash separated and relative, they never contain "." or ".." elements.
+type Store interface {
+	// Put stores body under key, replacing what was there, and returns its public URL
+	Put(ctx context.Context, key string, contentType string, body io.Reader) (string, error)
+	// Delete removes key, keys that do not exist are not an error
+	Delete(ctx context.Context, key string) error
+}
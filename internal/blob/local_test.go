@@ -0,0 +1,50 @@
+package blob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStore_PutAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalStore(dir, "https://uriel.example/media/")
+	ctx := context.Background()
+
+	url, err := store.Put(ctx, "avatars/u1/a/256.png", "image/png", strings.NewReader("first"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://uriel.example/media/avatars/u1/a/256.png", url)
+
+	_, err = store.Put(ctx, "avatars/u1/a/256.png", "image/png", strings.NewReader("second"))
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "avatars", "u1", "a", "256.png"))
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	// nothing is left behind but the file itself
+	entries, err := os.ReadDir(filepath.Join(dir, "avatars", "u1", "a"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, store.Delete(ctx, "avatars/u1/a/256.png"))
+	_, err = os.Stat(filepath.Join(dir, "avatars", "u1", "a", "256.png"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, store.Delete(ctx, "avatars/u1/a/256.png"), "deleting twice is fine")
+}
+
+func TestLocalStore_RefusesKeysOutsideTheDirectory(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "/media")
+	ctx := context.Background()
+
+	for _, key := range []string{"", ".", "../escape.png", "avatars/../../escape.png", "/etc/passwd", "avatars//a.png", `avatars\..\a.png`} {
+		_, err := store.Put(ctx, key, "image/png", strings.NewReader("x"))
+		assert.ErrorIs(t, err, ErrInvalidKey, key)
+		assert.ErrorIs(t, store.Delete(ctx, key), ErrInvalidKey, key)
+	}
+}
@@ -1,17 +1,76 @@
 package config
 
 import (
+	"errors"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	ServerPort string
-	MongoDBURI string
-	JWTSecret string
+	// Environment is ENV_DEVELOPMENT or ENV_PRODUCTION
+	Environment string
+	ServerPort  string
+	MongoDBURI  string
+	JWTSecret   string
+	PublicURL   string
+
+	// SigningKeysDir holds the PEM private keys tokens are signed with,
+	// see auth.LoadSigningKeys. JWTSecret is used when it is empty.
+	SigningKeysDir string
+
+	// UnverifiedLogin decides what happens when an account that has not verified
+	// its email logs in, either UNVERIFIED_LOGIN_LIMITED or UNVERIFIED_LOGIN_DENY.
+	// There is no workspace model yet so this is deployment wide for now.
+	UnverifiedLogin string
+
+	// TrustedProxies are the proxies allowed to set X-Forwarded-For.
+	// Per ip rate limits can be bypassed with a spoofed header if this is too wide.
+	TrustedProxies []string
+
+	// OIDC
+	// single sign-on is enabled when OIDCIssuer is set
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+
+	// PASSWORDS
+	// BreachedPasswordsFile is a list of SHA-1 hashes new passwords are checked against, see password.LoadBreachedFile
+	PasswordMinLength     int
+	PasswordMinClasses    int
+	BreachedPasswordsFile string
+
+	// RequireAdminMFA withholds the admin and owner roles from logins without a second factor
+	RequireAdminMFA bool
+
+	// PRESENCE
+	// AwayAfterMinutes of inactivity make users away, 0 turns it off.
+	// Users show off hours outside of the working hours when WorkingHoursStart and End are set,
+	// like UnverifiedLogin they are deployment wide until there is a workspace model.
+	AwayAfterMinutes     int
+	WorkingHoursTimezone string
+	WorkingHoursStart    string
+	WorkingHoursEnd      string
+	WorkingHoursDays     []string
+
+	// MEDIA
+	// uploads such as avatars are kept in MediaDir and served under /media,
+	// MediaURL is where clients find them, PublicURL/media unless a CDN sits in front
+	MediaDir string
+	MediaURL string
+
+	// MAIL
+	// when SMTPHost is empty mail is written to MailLogFile, or stdout if that is empty too
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+	MailLogFile  string
 }
 
 func LoadConfig() *Config {
@@ -20,25 +79,114 @@ func LoadConfig() *Config {
 	}
 
 	cfg := &Config{
-		ServerPort: getEnv("SERVER_PORT", ":8080"),
-		MongoDBURI: getEnv("MONGO_URI", "mongodb://localhost:27017/uriel?authSource=admin"),
-		JWTSecret: getEnv("JWT_SECRET", "super_secret_jwt_key"),
+		Environment: getEnv("APP_ENV", ENV_DEVELOPMENT),
+		ServerPort:  getEnv("SERVER_PORT", ":8080"),
+		MongoDBURI:  getEnv("MONGO_URI", "mongodb://localhost:27017/uriel?authSource=admin"),
+		JWTSecret:   getEnv("JWT_SECRET", DEFAULT_JWT_SECRET),
+		PublicURL:   getEnv("PUBLIC_URL", "http://localhost:8080"),
+
+		SigningKeysDir: getEnv("JWT_SIGNING_KEYS_DIR", ""),
+
+		UnverifiedLogin: getEnv("UNVERIFIED_LOGIN", UNVERIFIED_LOGIN_LIMITED),
+		TrustedProxies:  splitList(getEnv("TRUSTED_PROXIES", "")),
+		RequireAdminMFA: getEnv("REQUIRE_ADMIN_MFA", "true") != "false",
+
+		PasswordMinLength:     getEnvInt("PASSWORD_MIN_LENGTH", DEFAULT_PASSWORD_MIN_LENGTH),
+		PasswordMinClasses:    getEnvInt("PASSWORD_MIN_CLASSES", 0),
+		BreachedPasswordsFile: getEnv("BREACHED_PASSWORDS_FILE", ""),
+
+		OIDCIssuer:       getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:  getEnv("OIDC_REDIRECT_URL", ""),
+
+		AwayAfterMinutes:     getEnvInt("AWAY_AFTER_MINUTES", DEFAULT_AWAY_AFTER_MINUTES),
+		WorkingHoursTimezone: getEnv("WORKING_HOURS_TIMEZONE", "UTC"),
+		WorkingHoursStart:    getEnv("WORKING_HOURS_START", ""),
+		WorkingHoursEnd:      getEnv("WORKING_HOURS_END", ""),
+		WorkingHoursDays:     splitList(getEnv("WORKING_HOURS_DAYS", "monday,tuesday,wednesday,thursday,friday")),
+
+		MediaDir: getEnv("MEDIA_DIR", "media"),
+		MediaURL: getEnv("MEDIA_URL", ""),
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		MailFrom:     getEnv("MAIL_FROM", "Uriel <no-reply@uriel.local>"),
+		MailLogFile:  getEnv("MAIL_LOG_FILE", ""),
 	}
 
-	if cfg.JWTSecret == "super_secret_default_key" {
+	if cfg.JWTSecret == DEFAULT_JWT_SECRET {
 		log.Println("WARNING: JWT_SECRET is using a default, insecure value. Please set it in environment variables.")
 	}
+	if cfg.SigningKeysDir == "" {
+		log.Println("INFO: JWT_SIGNING_KEYS_DIR is not set, tokens are signed with JWT_SECRET and cannot be verified by other services.")
+	}
 	if strings.Contains(cfg.MongoDBURI, "localhost") && getEnv("MONGO_URI", "") == "" {
 		log.Println("INFO: MONGO_URI is using a default 'localhost' value. Ensure MongoDB is running locally or via Docker Compose.")
 	}
+	if cfg.UnverifiedLogin != UNVERIFIED_LOGIN_LIMITED && cfg.UnverifiedLogin != UNVERIFIED_LOGIN_DENY {
+		log.Printf("WARNING: UNVERIFIED_LOGIN %q is not known, using %q.", cfg.UnverifiedLogin, UNVERIFIED_LOGIN_LIMITED)
+		cfg.UnverifiedLogin = UNVERIFIED_LOGIN_LIMITED
+	}
+	if cfg.OIDCIssuer != "" && cfg.OIDCRedirectURL == "" {
+		cfg.OIDCRedirectURL = strings.TrimSuffix(cfg.PublicURL, "/") + "/api/v1/auth/oidc/callback"
+	}
+	if cfg.MediaURL == "" {
+		cfg.MediaURL = strings.TrimSuffix(cfg.PublicURL, "/") + "/media"
+	}
+	if cfg.BreachedPasswordsFile == "" {
+		log.Println("INFO: BREACHED_PASSWORDS_FILE is not set, new passwords are not checked against breached passwords.")
+	}
+	if cfg.SMTPHost == "" {
+		log.Println("INFO: SMTP_HOST is not set, outgoing mail will be logged instead of sent.")
+	}
 
 	return cfg
 }
 
+// Validate reports settings that are not safe to run in production with
+func (cfg *Config) Validate() error {
+	if cfg.Environment != ENV_PRODUCTION {
+		return nil
+	}
+	if cfg.JWTSecret == "" || cfg.JWTSecret == DEFAULT_JWT_SECRET {
+		return errors.New("JWT_SECRET must be set to a non-default value in production")
+	}
+	return nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
 	}
 
 	return defaultValue
-}
\ No newline at end of file
+}
+
+// getEnvInt is getEnv for numbers, a value that is not a number is reported and the default used
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("WARNING: %s %q is not a number, using %d.", key, value, defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+// splitList splits a comma separated value, ignoring empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
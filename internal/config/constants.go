@@ -1,14 +1,115 @@
 package config
 
+import "time"
+
 // JWT
-const TOKEN_DURATION = 36
+const ACCESS_TOKEN_DURATION = 15 * time.Minute
+const REFRESH_TOKEN_DURATION = 30 * 24 * time.Hour
+
+// time between the password check and the second factor
+const MFA_TOKEN_DURATION = 5 * time.Minute
+
+// a signing key stays valid this long after the next key took over,
+// the longest lived JWT is the email verification link
+const SIGNING_KEY_OVERLAP = EMAIL_VERIFICATION_DURATION
+
+const DEFAULT_JWT_SECRET = "super_secret_jwt_key"
+
+// PERSONAL ACCESS TOKENS
+const DEFAULT_PAT_DURATION = 30 * 24 * time.Hour
+const MAX_PAT_DURATION = 365 * 24 * time.Hour
+
+// last use is written at most this often per token
+const PAT_LAST_USED_INTERVAL = time.Minute
+
+// GUESTS
+// guests get a single access token and no refresh token
+const GUEST_TOKEN_DURATION = 8 * time.Hour
+const DEFAULT_GUEST_INVITE_DURATION = 7 * 24 * time.Hour
+const MAX_GUEST_INVITE_DURATION = 30 * 24 * time.Hour
+
+// IMPERSONATION
+// an admin acting as a user gets a single access token and no refresh token
+const IMPERSONATION_TOKEN_DURATION = 15 * time.Minute
+
+// PASSWORDS
+const DEFAULT_PASSWORD_MIN_LENGTH = 8
+
+// USERNAMES
+// a previous username still logs in and cannot be taken by anybody else for this long
+const USERNAME_ALIAS_DURATION = 30 * 24 * time.Hour
+
+// PRESENCE
+// a status can be set to expire at most this far ahead
+const MAX_STATUS_DURATION = 30 * 24 * time.Hour
+
+// how often expired statuses are reverted and the presence rules run for everybody signed in
+const PRESENCE_TICK_INTERVAL = 30 * time.Second
+
+// heartbeats write the last activity at most this often per user,
+// unless the presence rules change the status
+const LAST_ACTIVE_WRITE_INTERVAL = time.Minute
+
+// users without activity become away after this many minutes unless AWAY_AFTER_MINUTES says otherwise
+const DEFAULT_AWAY_AFTER_MINUTES = 10
+
+// idle presence streams get a comment this often so proxies keep them open
+const PRESENCE_KEEPALIVE_INTERVAL = 30 * time.Second
+
+// AVATARS
+// uploads are refused above this size before they are decoded
+const MAX_AVATAR_UPLOAD_BYTES = 5 << 20
+
+// and above this many pixels, a small file can still decode to a huge image.
+// 16 megapixels decode to 64 MB of RGBA, which bounds the memory of concurrent uploads.
+const MAX_AVATAR_PIXELS = 16_000_000
+
+// ENVIRONMENTS
+const ENV_DEVELOPMENT = "development"
+const ENV_PRODUCTION = "production"
+
+// ONE TIME TOKENS
+const PASSWORD_RESET_DURATION = time.Hour
+const EMAIL_VERIFICATION_DURATION = 24 * time.Hour
+const MAGIC_LINK_DURATION = 15 * time.Minute
+const EMAIL_CHANGE_DURATION = 24 * time.Hour
+
+// RATE LIMITS
+// requests per minute per ip, except PASSWORD_RESET_RATE_LIMIT which is per email
+const LOGIN_RATE_LIMIT = 5
+const REGISTER_RATE_LIMIT = 3
+const MAIL_RATE_LIMIT = 5
+const REFRESH_RATE_LIMIT = 30
+const PASSWORD_RESET_RATE_LIMIT = 1
+
+// ACCOUNT LOCKOUT
+// after LOCKOUT_THRESHOLD failed logins the account is locked for LOCKOUT_DURATION,
+// doubling with every further failure up to MAX_LOCKOUT_DURATION
+const LOCKOUT_THRESHOLD = 5
+const LOCKOUT_DURATION = time.Minute
+const MAX_LOCKOUT_DURATION = time.Hour
+
+// UNVERIFIED LOGIN POLICIES
+// limited lets unverified accounts in with guest claims, deny refuses the login
+const UNVERIFIED_LOGIN_LIMITED = "limited"
+const UNVERIFIED_LOGIN_DENY = "deny"
 
 // ROLES
 const USER = "user"
 const ADMIN = "admin"
 const GUEST = "guest"
+const OWNER = "owner"
 
 // DATABASE
 const DATABASE_NAME = "urieldb"
 const USER_COLLECTION = "user"
 const AVATAR_COLLECTION = "avatar"
+const REFRESH_TOKEN_COLLECTION = "refresh_token"
+const REVOKED_TOKEN_COLLECTION = "revoked_token"
+const ONE_TIME_TOKEN_COLLECTION = "one_time_token"
+const PERSONAL_ACCESS_TOKEN_COLLECTION = "personal_access_token"
+const SESSION_COLLECTION = "session"
+const GUEST_INVITE_COLLECTION = "guest_invite"
+const GUEST_COLLECTION = "guest"
+const USERNAME_ALIAS_COLLECTION = "username_alias"
+const AUDIT_LOG_COLLECTION = "audit_log"
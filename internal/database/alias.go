@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/auth"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoUsernameAliasRepository struct {
+	collection *mongo.Collection
+}
+
+func NewUsernameAliasRepository(mongodb *MongoDB) auth.UsernameAliasRepository {
+	collection := mongodb.GetCollection(config.USERNAME_ALIAS_COLLECTION)
+
+	indexes := []mongo.IndexModel{
+		// USERNAME (UNIQUE INDEX) with the same collation as the usernames of users
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetUnique(true).SetCollation(caseInsensitive),
+		},
+		// EXPIRES AT (TTL INDEX) the username is free again once the alias is removed
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("Warning: The username alias indexes could not be created: %v", err)
+	}
+
+	return &mongoUsernameAliasRepository{collection: collection}
+}
+
+func (repo *mongoUsernameAliasRepository) SaveUsernameAlias(ctx context.Context, alias models.UsernameAlias) error {
+	// a user that had the name before hands it on, an alias that expired but was not removed yet is replaced
+	filter := bson.M{"username": alias.Username}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "username", Value: alias.Username},
+			{Key: "user_id", Value: alias.UserID},
+			{Key: "created_at", Value: alias.CreatedAt},
+			{Key: "expires_at", Value: alias.ExpiresAt},
+		}},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "_id", Value: alias.ID}}},
+	}
+
+	_, err := repo.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true).SetCollation(caseInsensitive))
+	return err
+}
+
+func (repo *mongoUsernameAliasRepository) GetUsernameAlias(ctx context.Context, username string) (*models.UsernameAlias, error) {
+	var alias models.UsernameAlias
+
+	// the TTL monitor only runs every minute
+	filter := bson.M{"username": username, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+	err := repo.collection.FindOne(ctx, filter, options.FindOne().SetCollation(caseInsensitive)).Decode(&alias)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &alias, nil
+}
+
+func (repo *mongoUsernameAliasRepository) DeleteUsernameAlias(ctx context.Context, username string) error {
+	filter := bson.M{"username": username}
+	_, err := repo.collection.DeleteOne(ctx, filter, options.Delete().SetCollation(caseInsensitive))
+	return err
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/audit"
+	"github.com/palSagnik/uriel/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoAuditLog struct {
+	collection *mongo.Collection
+}
+
+func NewAuditLog(mongodb *MongoDB) audit.Log {
+	collection := mongodb.GetCollection(config.AUDIT_LOG_COLLECTION)
+
+	// entries are never removed, so there is no TTL index
+	indexes := []mongo.IndexModel{
+		// ACTOR (INDEX) what did an admin do
+		{
+			Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		// SUBJECT (INDEX) what was done as or to a user
+		{
+			Keys: bson.D{{Key: "subject_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("Warning: The audit log indexes could not be created: %v", err)
+	}
+
+	return &mongoAuditLog{collection: collection}
+}
+
+func (l *mongoAuditLog) Record(ctx context.Context, entry audit.Entry) error {
+	_, err := l.collection.InsertOne(ctx, entry)
+	return err
+}
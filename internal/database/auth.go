@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -15,8 +16,18 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// caseInsensitive compares strings the way the unique indexes on username and email do,
+// strength 2 ignores case but not accents. Queries only use an index with the same collation.
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+const (
+	usernameIndexName = "username_ci"
+	emailIndexName    = "email_ci"
+)
+
 type mongoAuthRepository struct {
-	collection *mongo.Collection
+	collection      *mongo.Collection
+	tokenCollection *mongo.Collection
 }
 
 func NewAuthRepository(mongodb *MongoDB) auth.AuthRepository {
@@ -25,38 +36,133 @@ func NewAuthRepository(mongodb *MongoDB) auth.AuthRepository {
 	userCollection := mongodb.GetCollection(config.USER_COLLECTION)
 
 	// ensuring proper indexes efficient login and preventing duplicates
-	// this helps in data integrity
+	// this helps in data integrity, usernames and emails are unique regardless of case
+	// so Alice and alice cannot both register
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
 	// USERNAME (INDEX)
 	usernameIndexModel := mongo.IndexModel{
 		Keys:    bson.D{{Key: "username", Value: 1}},
-		Options: options.Index().SetUnique(true),
+		Options: options.Index().SetName(usernameIndexName).SetUnique(true).SetCollation(caseInsensitive),
 	}
+	ensureCaseInsensitiveIndex(ctx, userCollection, usernameIndexModel, "username_1")
 
 	// EMAIL (INDEX)
 	emailIndexModel := mongo.IndexModel{
 		Keys:    bson.D{{Key: "email", Value: 1}},
-		Options: options.Index().SetUnique(true),
+		Options: options.Index().SetName(emailIndexName).SetUnique(true).SetCollation(caseInsensitive),
+	}
+	ensureCaseInsensitiveIndex(ctx, userCollection, emailIndexModel, "email_1")
+
+	// IDENTITY (INDEX)
+	// an account at an identity provider belongs to one user at most,
+	// users without identities are left out of the index
+	identityIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "identities.issuer", Value: 1}, {Key: "identities.subject", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"identities.subject": bson.M{"$exists": true},
+		}),
+	}
+	_, err = userCollection.Indexes().CreateOne(ctx, identityIndexModel)
+	if err != nil {
+		log.Printf("Warning: The unique index on identities could not be created: %v", err)
+	}
+
+	// CREATED AT (INDEX)
+	// the user directory pages through users by creation date, the collation is the one of its queries
+	createdAtIndexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}},
+		Options: options.Index().SetCollation(caseInsensitive),
+	}
+	_, err = userCollection.Indexes().CreateOne(ctx, createdAtIndexModel)
+	if err != nil {
+		log.Printf("Warning: The index on created_at could not be created: %v", err)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 
-	// creating index on username
-	_, err = userCollection.Indexes().CreateOne(ctx, usernameIndexModel)
+	// AUTO EXPIRE (INDEX)
+	// the status expirer looks for statuses past their auto_expire_at
+	autoExpireIndexModel := mongo.IndexModel{
+		Keys: bson.D{{Key: "presence.auto_expire_at", Value: 1}},
+	}
+	_, err = userCollection.Indexes().CreateOne(ctx, autoExpireIndexModel)
 	if err != nil {
-		log.Printf("Warning: The unique index on username could not be created: %v", err)
+		log.Printf("Warning: The index on presence.auto_expire_at could not be created: %v", err)
 	}
 
-	// creating index on email
-	_, err = userCollection.Indexes().CreateOne(ctx, emailIndexModel)
+	// one time tokens (password reset etc.) are looked up by hash and removed once expired
+	tokenCollection := mongodb.GetCollection(config.ONE_TIME_TOKEN_COLLECTION)
+	tokenIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err = tokenCollection.Indexes().CreateMany(ctx, tokenIndexes)
 	if err != nil {
-		log.Printf("Warning: The unique index on email could not be created: %v", err)
+		log.Printf("Warning: The one time token indexes could not be created: %v", err)
 	}
-	return &mongoAuthRepository{collection: userCollection}
+
+	return &mongoAuthRepository{collection: userCollection, tokenCollection: tokenCollection}
+}
+
+// ensureCaseInsensitiveIndex creates a unique index with the case insensitive collation
+// and drops the case sensitive one it replaces. Creating it fails while users still collide,
+// the old index is then kept until cmd/uriel-migrate resolved them.
+func ensureCaseInsensitiveIndex(ctx context.Context, collection *mongo.Collection, model mongo.IndexModel, replaces string) {
+	_, err := collection.Indexes().CreateOne(ctx, model)
+	if err != nil {
+		log.Printf("Warning: The unique index %s could not be created, run uriel-migrate to find users that differ only in case: %v", *model.Options.Name, err)
+		return
+	}
+
+	// the old index is gone on every start after the first
+	_, err = collection.Indexes().DropOne(ctx, replaces)
+	if err != nil && !isIndexNotFound(err) {
+		log.Printf("Warning: The index %s could not be dropped: %v", replaces, err)
+	}
+}
+
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 27
+}
+
+// duplicateKey is the field of the unique index that refused a write, empty for other errors.
+// The server names the fields of the index in the keyPattern of the write error.
+func duplicateKey(err error) string {
+	var writeErr mongo.WriteException
+	if !errors.As(err, &writeErr) {
+		return ""
+	}
+	for _, we := range writeErr.WriteErrors {
+		if we.Code != 11000 {
+			continue
+		}
+		keyPattern, ok := we.Raw.Lookup("keyPattern").DocumentOK()
+		if !ok {
+			continue
+		}
+		if elements, err := keyPattern.Elements(); err == nil && len(elements) > 0 {
+			return elements[0].Key()
+		}
+	}
+	return ""
 }
 
 // MongoAuthRepository implementing AuthRepository interface
 func (repo *mongoAuthRepository) CreateUser(ctx context.Context, user models.User) error {
 	_, err := repo.collection.InsertOne(ctx, user)
+	switch duplicateKey(err) {
+	case "username":
+		return auth.ErrUsernameTaken
+	case "email":
+		return auth.ErrEmailTaken
+	}
 	return err
 }
 
@@ -64,7 +170,7 @@ func (repo *mongoAuthRepository) GetUserByUsername(ctx context.Context, username
 	var user models.User
 
 	filter := bson.M{"username": username}
-	err := repo.collection.FindOne(ctx, filter).Decode(&user)
+	err := repo.collection.FindOne(ctx, filter, options.FindOne().SetCollation(caseInsensitive)).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
@@ -97,6 +203,20 @@ func (repo *mongoAuthRepository) GetUserByEmail(ctx context.Context, email strin
 	var user models.User
 
 	filter := bson.M{"email": email}
+	err := repo.collection.FindOne(ctx, filter, options.FindOne().SetCollation(caseInsensitive)).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (repo *mongoAuthRepository) GetUserByIdentity(ctx context.Context, issuer string, subject string) (*models.User, error) {
+	var user models.User
+
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"issuer": issuer, "subject": subject}}}
 	err := repo.collection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -107,15 +227,214 @@ func (repo *mongoAuthRepository) GetUserByEmail(ctx context.Context, email strin
 	return &user, nil
 }
 
-func (repo *mongoAuthRepository) UpdateUserStatus(ctx context.Context, id string) error {
+func (repo *mongoAuthRepository) AddUserIdentity(ctx context.Context, id string, identity models.Identity) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objectId}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{{Key: "identities", Value: identity}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}},
+	}
+
+	_, err = repo.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (repo *mongoAuthRepository) UpdateUserStatus(ctx context.Context, id string, isOnline bool) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	// what the presence rules decided before is stale either way,
+	// signing in counts as activity
+	set := bson.D{
+		{Key: "is_online", Value: isOnline},
+		{Key: "presence.auto_status", Value: ""},
+		{Key: "presence.auto_message", Value: ""},
+	}
+	if isOnline {
+		set = append(set, bson.E{Key: "presence.last_active_at", Value: time.Now().UTC()})
+	}
+
+	filter := bson.M{"_id": objectId}
+	update := bson.D{{Key: "$set", Value: set}}
+
+	_, err = repo.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (repo *mongoAuthRepository) UpdateUserPassword(ctx context.Context, id string, passwordHash string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objectId}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "password", Value: passwordHash},
+		{Key: "updated_at", Value: time.Now().UTC()},
+	}}}
+
+	_, err = repo.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (repo *mongoAuthRepository) UpgradeUserPassword(ctx context.Context, id string, oldHash string, newHash string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	// matching on the old hash keeps a password that was changed since it was read,
+	// updated_at is left alone since the user did not change anything
+	filter := bson.M{"_id": objectId, "password": oldHash}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: newHash}}}}
+
+	_, err = repo.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (repo *mongoAuthRepository) UpdateUserMFA(ctx context.Context, id string, mfa models.MFASettings) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objectId}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "mfa", Value: mfa},
+		{Key: "updated_at", Value: time.Now().UTC()},
+	}}}
+
+	_, err = repo.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (repo *mongoAuthRepository) UseTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	// $not also matches users without a last step, it is left out while it is 0
+	filter := bson.M{
+		"_id":                objectId,
+		"mfa.enabled":        true,
+		"mfa.last_used_step": bson.M{"$not": bson.M{"$gte": step}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "mfa.last_used_step", Value: step}}}}
+
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (repo *mongoAuthRepository) UseRecoveryCode(ctx context.Context, id string, codeHash string) (bool, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.M{"_id": objectId, "mfa.enabled": true, "mfa.recovery_codes": codeHash}
+	update := bson.D{{Key: "$pull", Value: bson.D{{Key: "mfa.recovery_codes", Value: codeHash}}}}
+
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (repo *mongoAuthRepository) UpdateUsername(ctx context.Context, id string, username string) error {
 	objectId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
 	}
 
 	filter := bson.M{"_id": objectId}
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "is_online", Value: true}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "username", Value: username},
+		{Key: "updated_at", Value: time.Now().UTC()},
+	}}}
 
 	_, err = repo.collection.UpdateOne(ctx, filter, update)
 	return err
 }
+
+func (repo *mongoAuthRepository) UpdateUserEmail(ctx context.Context, id string, oldEmail string, newEmail string) (bool, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	// the new address was confirmed through the link mailed to it
+	now := time.Now().UTC()
+	filter := bson.M{"_id": objectId, "email": oldEmail}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "email", Value: newEmail},
+		{Key: "verified", Value: true},
+		{Key: "verified_at", Value: now},
+		{Key: "updated_at", Value: now},
+	}}}
+
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (repo *mongoAuthRepository) MarkEmailVerified(ctx context.Context, id string, email string) (bool, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	filter := bson.M{"_id": objectId, "email": email}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "verified", Value: true},
+		{Key: "verified_at", Value: now},
+		{Key: "updated_at", Value: now},
+	}}}
+
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (repo *mongoAuthRepository) CreateOneTimeToken(ctx context.Context, token models.OneTimeToken) error {
+	_, err := repo.tokenCollection.InsertOne(ctx, token)
+	return err
+}
+
+func (repo *mongoAuthRepository) ConsumeOneTimeToken(ctx context.Context, purpose string, tokenHash string) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+
+	now := time.Now().UTC()
+	filter := bson.M{
+		"token_hash": tokenHash,
+		"purpose":    purpose,
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "used_at", Value: now}}}}
+
+	// find and update in one step so a token can never be used twice
+	err := repo.tokenCollection.FindOneAndUpdate(ctx, filter, update).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
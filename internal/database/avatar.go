@@ -25,19 +25,19 @@ func NewAvatarRepository(mongo *MongoDB) avatar.AvatarRepository {
 func (repo *mongoAvatarRepository) GetAvatarUrlById(ctx context.Context, id string) (string, error) {
 	avatarId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return "", err
+		return "", avatar.ErrAvatarNotFound
 	}
 
-	var avatar models.Avatar
+	var stored models.Avatar
 	filter := bson.M{"_id": avatarId}
-	if err := repo.collection.FindOne(ctx, filter).Decode(&avatar); err != nil {
+	if err := repo.collection.FindOne(ctx, filter).Decode(&stored); err != nil {
 		if err == mongo.ErrNoDocuments {
-			return "", errors.New("avatar not found")
+			return "", avatar.ErrAvatarNotFound
 		}
 		return "", fmt.Errorf("failed to find avatar: %v", err)
 	}
 
-	return avatar.AvatarUrl, nil
+	return stored.AvatarUrl, nil
 }
 
 func (repo *mongoAvatarRepository) GetAvatars(ctx context.Context) ([]models.Avatar, error) {
@@ -54,4 +54,25 @@ func (repo *mongoAvatarRepository) GetAvatars(ctx context.Context) ([]models.Ava
 	}
 
 	return avatars, nil
-}
\ No newline at end of file
+}
+
+func (repo *mongoAvatarRepository) CreateAvatar(ctx context.Context, avatar models.Avatar) error {
+	_, err := repo.collection.InsertOne(ctx, avatar)
+	return err
+}
+
+func (repo *mongoAvatarRepository) DeleteAvatar(ctx context.Context, id string) error {
+	avatarId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return avatar.ErrAvatarNotFound
+	}
+
+	result, err := repo.collection.DeleteOne(ctx, bson.M{"_id": avatarId})
+	if err != nil {
+		return fmt.Errorf("failed to delete avatar: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return avatar.ErrAvatarNotFound
+	}
+	return nil
+}
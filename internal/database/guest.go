@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/auth"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoGuestRepository struct {
+	invites *mongo.Collection
+	guests  *mongo.Collection
+}
+
+func NewGuestRepository(mongodb *MongoDB) auth.GuestRepository {
+	inviteCollection := mongodb.GetCollection(config.GUEST_INVITE_COLLECTION)
+	guestCollection := mongodb.GetCollection(config.GUEST_COLLECTION)
+
+	inviteIndexes := []mongo.IndexModel{
+		// TOKEN HASH (UNIQUE INDEX)
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		// EXPIRES AT (TTL INDEX) mongo removes expired invites on its own
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	guestIndexes := []mongo.IndexModel{
+		// INVITE (INDEX) for removing the guests of an invite
+		{
+			Keys: bson.D{{Key: "invite_id", Value: 1}},
+		},
+		// EXPIRES AT (TTL INDEX) guests are removed when their token expires
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := inviteCollection.Indexes().CreateMany(ctx, inviteIndexes); err != nil {
+		log.Printf("Warning: The guest invite indexes could not be created: %v", err)
+	}
+	if _, err := guestCollection.Indexes().CreateMany(ctx, guestIndexes); err != nil {
+		log.Printf("Warning: The guest indexes could not be created: %v", err)
+	}
+
+	return &mongoGuestRepository{invites: inviteCollection, guests: guestCollection}
+}
+
+func (repo *mongoGuestRepository) CreateGuestInvite(ctx context.Context, invite models.GuestInvite) error {
+	_, err := repo.invites.InsertOne(ctx, invite)
+	return err
+}
+
+func (repo *mongoGuestRepository) UseGuestInvite(ctx context.Context, tokenHash string, now time.Time) (*models.GuestInvite, error) {
+	var invite models.GuestInvite
+
+	// the ttl index only runs once a minute, so expiry is checked here as well
+	filter := bson.M{
+		"token_hash": tokenHash,
+		"expires_at": bson.M{"$gt": now},
+		"$or": bson.A{
+			bson.M{"max_uses": 0},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}}},
+		},
+	}
+	update := bson.M{"$inc": bson.M{"uses": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := repo.invites.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invite)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func (repo *mongoGuestRepository) DeleteGuestInvite(ctx context.Context, id string) (bool, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+
+	result, err := repo.invites.DeleteOne(ctx, bson.M{"_id": objectId})
+	if err != nil {
+		return false, err
+	}
+	if result.DeletedCount == 0 {
+		return false, nil
+	}
+
+	if _, err := repo.guests.DeleteMany(ctx, bson.M{"invite_id": id}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (repo *mongoGuestRepository) CreateGuest(ctx context.Context, guest models.Guest) error {
+	_, err := repo.guests.InsertOne(ctx, guest)
+	return err
+}
+
+func (repo *mongoGuestRepository) GetGuest(ctx context.Context, id string) (*models.Guest, error) {
+	var guest models.Guest
+
+	filter := bson.M{"_id": id, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+	err := repo.guests.FindOne(ctx, filter).Decode(&guest)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &guest, nil
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CaseCollision is a group of users whose username or email only differ in case
+type CaseCollision struct {
+	Field string
+	// Key is the value all users share once lower cased
+	Key   string
+	Users []models.User
+}
+
+// MigrationRepository has the queries of cmd/uriel-migrate, the server never uses it
+type MigrationRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMigrationRepository(mongodb *MongoDB) *MigrationRepository {
+	return &MigrationRepository{collection: mongodb.GetCollection(config.USER_COLLECTION)}
+}
+
+// FindCaseCollisions groups the users on the lower cased field ("username" or "email").
+// $toLower only folds ASCII, which covers usernames and all but exotic emails.
+func (repo *MigrationRepository) FindCaseCollisions(ctx context.Context, field string) ([]CaseCollision, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "$toLower", Value: "$" + field}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "users", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := repo.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Key   string        `bson:"_id"`
+		Users []models.User `bson:"users"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("failed to decode cursor: %w", err)
+	}
+
+	collisions := make([]CaseCollision, 0, len(groups))
+	for _, group := range groups {
+		collisions = append(collisions, CaseCollision{Field: field, Key: group.Key, Users: group.Users})
+	}
+	return collisions, nil
+}
+
+// ListUsernames returns the id and username of every user
+func (repo *MigrationRepository) ListUsernames(ctx context.Context) ([]models.User, error) {
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "_id", Value: 1}, {Key: "username", Value: 1}}).
+		SetSort(bson.D{{Key: "username", Value: 1}})
+
+	cursor, err := repo.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode cursor: %w", err)
+	}
+	return users, nil
+}
+
+// UsernameTaken compares like the unique index on username does
+func (repo *MigrationRepository) UsernameTaken(ctx context.Context, username string) (bool, error) {
+	count, err := repo.collection.CountDocuments(ctx, bson.M{"username": username}, options.Count().SetCollation(caseInsensitive))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (repo *MigrationRepository) RenameUser(ctx context.Context, user models.User, username string) error {
+	filter := bson.M{"_id": user.ID}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "username", Value: username},
+		{Key: "updated_at", Value: time.Now().UTC()},
+	}}}
+
+	_, err := repo.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// ReplaceUserEmail sets a new email, which has not been verified
+func (repo *MigrationRepository) ReplaceUserEmail(ctx context.Context, user models.User, email string) error {
+	filter := bson.M{"_id": user.ID}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "email", Value: email},
+			{Key: "verified", Value: false},
+			{Key: "updated_at", Value: time.Now().UTC()},
+		}},
+		{Key: "$unset", Value: bson.D{{Key: "verified_at", Value: ""}}},
+	}
+
+	_, err := repo.collection.UpdateOne(ctx, filter, update)
+	return err
+}
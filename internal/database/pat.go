@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/auth"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoPersonalAccessTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewPersonalAccessTokenRepository(mongodb *MongoDB) auth.PersonalAccessTokenRepository {
+	tokenCollection := mongodb.GetCollection(config.PERSONAL_ACCESS_TOKEN_COLLECTION)
+
+	indexes := []mongo.IndexModel{
+		// TOKEN HASH (UNIQUE INDEX)
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		// USER (INDEX) for listing the tokens of a user
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		// EXPIRES AT (TTL INDEX) mongo removes expired tokens on its own
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := tokenCollection.Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("Warning: The personal access token indexes could not be created: %v", err)
+	}
+
+	return &mongoPersonalAccessTokenRepository{collection: tokenCollection}
+}
+
+func (repo *mongoPersonalAccessTokenRepository) CreatePersonalAccessToken(ctx context.Context, token models.PersonalAccessToken) error {
+	_, err := repo.collection.InsertOne(ctx, token)
+	return err
+}
+
+func (repo *mongoPersonalAccessTokenRepository) GetPersonalAccessToken(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+
+	filter := bson.M{"token_hash": tokenHash}
+	err := repo.collection.FindOne(ctx, filter).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (repo *mongoPersonalAccessTokenRepository) ListPersonalAccessTokens(ctx context.Context, userID string) ([]models.PersonalAccessToken, error) {
+	filter := bson.M{"user_id": userID}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := repo.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []models.PersonalAccessToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (repo *mongoPersonalAccessTokenRepository) DeletePersonalAccessToken(ctx context.Context, userID string, id string) (bool, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, nil
+	}
+
+	// the user id is part of the filter so nobody can revoke the tokens of someone else
+	filter := bson.M{"_id": objectId, "user_id": userID}
+	result, err := repo.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount == 1, nil
+}
+
+func (repo *mongoPersonalAccessTokenRepository) TouchPersonalAccessToken(ctx context.Context, id string, usedAt time.Time) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objectId}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "last_used_at", Value: usedAt}}}}
+
+	_, err = repo.collection.UpdateOne(ctx, filter, update)
+	return err
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/auth"
+	"github.com/palSagnik/uriel/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoRevocationRepository struct {
+	collection *mongo.Collection
+}
+
+// revokedToken is either a single revoked jti, a revoked session stored under
+// "session:<id>", or a per user cut off stored under "user:<id>" that revokes
+// every token of the user issued before it
+type revokedToken struct {
+	ID           string     `bson:"_id"`
+	IssuedBefore *time.Time `bson:"issued_before,omitempty"`
+	ExpiresAt    time.Time  `bson:"expires_at"`
+}
+
+func userCutoffID(userID string) string {
+	return "user:" + userID
+}
+
+func sessionID(id string) string {
+	return "session:" + id
+}
+
+func NewRevocationRepository(mongodb *MongoDB) auth.RevocationRepository {
+	revokedCollection := mongodb.GetCollection(config.REVOKED_TOKEN_COLLECTION)
+
+	// EXPIRES AT (TTL INDEX)
+	// a revoked token only has to be remembered until it would have expired
+	expiresIndexModel := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := revokedCollection.Indexes().CreateOne(ctx, expiresIndexModel); err != nil {
+		log.Printf("Warning: The TTL index on revoked tokens could not be created: %v", err)
+	}
+
+	return &mongoRevocationRepository{collection: revokedCollection}
+}
+
+func (repo *mongoRevocationRepository) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	// upsert so that logging out twice with the same token is not an error
+	filter := bson.M{"_id": jti}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "expires_at", Value: expiresAt}}}}
+
+	_, err := repo.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (repo *mongoRevocationRepository) RevokeSession(ctx context.Context, id string, expiresAt time.Time) error {
+	filter := bson.M{"_id": sessionID(id)}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "expires_at", Value: expiresAt}}}}
+
+	_, err := repo.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (repo *mongoRevocationRepository) RevokeUserTokens(ctx context.Context, userID string, issuedBefore time.Time) error {
+	// once the newest token covered by the cut off has expired the entry is useless
+	filter := bson.M{"_id": userCutoffID(userID)}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "issued_before", Value: issuedBefore},
+		{Key: "expires_at", Value: issuedBefore.Add(config.ACCESS_TOKEN_DURATION)},
+	}}}
+
+	_, err := repo.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (repo *mongoRevocationRepository) ConsumeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	// an insert and not an upsert, of two requests with the same token only one gets through
+	_, err := repo.collection.InsertOne(ctx, revokedToken{ID: jti, ExpiresAt: expiresAt})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (repo *mongoRevocationRepository) IsTokenRevoked(ctx context.Context, jti string, session string, userID string, issuedAt time.Time) (bool, error) {
+	var entries []revokedToken
+
+	ids := bson.A{jti, userCutoffID(userID)}
+	if session != "" {
+		ids = append(ids, sessionID(session))
+	}
+	filter := bson.M{"_id": bson.M{"$in": ids}}
+	cursor, err := repo.collection.Find(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &entries); err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		if entry.IssuedBefore == nil || issuedAt.Before(*entry.IssuedBefore) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/auth"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoSessionRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSessionRepository(mongodb *MongoDB) auth.SessionRepository {
+	sessionCollection := mongodb.GetCollection(config.SESSION_COLLECTION)
+
+	indexes := []mongo.IndexModel{
+		// USER (INDEX) for listing the sessions of a user
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}},
+		},
+		// EXPIRES AT (TTL INDEX) mongo removes ended sessions on its own
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := sessionCollection.Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("Warning: The session indexes could not be created: %v", err)
+	}
+
+	return &mongoSessionRepository{collection: sessionCollection}
+}
+
+func (repo *mongoSessionRepository) SaveSession(ctx context.Context, session models.Session) error {
+	filter := bson.M{"_id": session.ID}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "device_info", Value: session.DeviceInfo},
+			{Key: "ip", Value: session.IP},
+			{Key: "user_agent", Value: session.UserAgent},
+			{Key: "last_seen_at", Value: session.LastSeenAt},
+			{Key: "expires_at", Value: session.ExpiresAt},
+		}},
+		{Key: "$setOnInsert", Value: bson.D{
+			{Key: "user_id", Value: session.UserID},
+			{Key: "created_at", Value: session.CreatedAt},
+		}},
+	}
+
+	_, err := repo.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (repo *mongoSessionRepository) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	var session models.Session
+
+	err := repo.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (repo *mongoSessionRepository) ListSessions(ctx context.Context, userID string) ([]models.Session, error) {
+	filter := bson.M{
+		"user_id":    userID,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": time.Now().UTC()},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}})
+
+	cursor, err := repo.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []models.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (repo *mongoSessionRepository) RevokeSession(ctx context.Context, id string) error {
+	return repo.revokeWhere(ctx, bson.M{"_id": id})
+}
+
+func (repo *mongoSessionRepository) RevokeUserSessions(ctx context.Context, userID string) error {
+	return repo.revokeWhere(ctx, bson.M{"user_id": userID})
+}
+
+func (repo *mongoSessionRepository) revokeWhere(ctx context.Context, filter bson.M) error {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: time.Now().UTC()}}}}
+
+	_, err := repo.collection.UpdateMany(ctx, filter, update)
+	return err
+}
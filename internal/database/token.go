@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/auth"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoRefreshTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRefreshTokenRepository(mongodb *MongoDB) auth.RefreshTokenRepository {
+	tokenCollection := mongodb.GetCollection(config.REFRESH_TOKEN_COLLECTION)
+
+	indexes := []mongo.IndexModel{
+		// TOKEN HASH (UNIQUE INDEX)
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		// FAMILY (INDEX) for revoking a whole family
+		{
+			Keys: bson.D{{Key: "family_id", Value: 1}},
+		},
+		// USER (INDEX) for revoking every token of a user
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+		// EXPIRES AT (TTL INDEX) mongo removes expired tokens on its own
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := tokenCollection.Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("Warning: The refresh token indexes could not be created: %v", err)
+	}
+
+	return &mongoRefreshTokenRepository{collection: tokenCollection}
+}
+
+func (repo *mongoRefreshTokenRepository) CreateRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	_, err := repo.collection.InsertOne(ctx, token)
+	return err
+}
+
+func (repo *mongoRefreshTokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+
+	filter := bson.M{"token_hash": tokenHash}
+	err := repo.collection.FindOne(ctx, filter).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (repo *mongoRefreshTokenRepository) MarkRefreshTokenUsed(ctx context.Context, tokenHash string, usedAt time.Time) (bool, error) {
+	// only matches a token that nobody has rotated yet, which makes the rotation atomic
+	filter := bson.M{"token_hash": tokenHash, "used_at": nil, "revoked_at": nil}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "used_at", Value: usedAt}}}}
+
+	result, err := repo.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (repo *mongoRefreshTokenRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	filter := bson.M{"family_id": familyID, "revoked_at": nil}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: time.Now().UTC()}}}}
+
+	_, err := repo.collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (repo *mongoRefreshTokenRepository) RevokeUserTokens(ctx context.Context, userID string) error {
+	filter := bson.M{"user_id": userID, "revoked_at": nil}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revoked_at", Value: time.Now().UTC()}}}}
+
+	_, err := repo.collection.UpdateMany(ctx, filter, update)
+	return err
+}
@@ -2,8 +2,9 @@ package database
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"regexp"
+	"time"
 
 	"github.com/palSagnik/uriel/internal/config"
 	"github.com/palSagnik/uriel/internal/models"
@@ -11,12 +12,32 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type mongoUserRepository struct {
 	collection *mongo.Collection
 }
 
+// userProjection loads what the user responses show and nothing else,
+// password hashes and two factor secrets never leave the database this way
+var userProjection = bson.D{
+	{Key: "username", Value: 1},
+	{Key: "full_name", Value: 1},
+	{Key: "email", Value: 1},
+	{Key: "role", Value: 1},
+	{Key: "avatar_url", Value: 1},
+	{Key: "is_online", Value: 1},
+	{Key: "verified", Value: 1},
+	{Key: "verified_at", Value: 1},
+	{Key: "mfa.enabled", Value: 1},
+	{Key: "identities.issuer", Value: 1},
+	{Key: "preferences", Value: 1},
+	{Key: "presence", Value: 1},
+	{Key: "created_at", Value: 1},
+	{Key: "updated_at", Value: 1},
+}
+
 func NewUserRepository(mongo *MongoDB) user.UserRepository {
 	userCollection := mongo.GetCollection(config.USER_COLLECTION)
 
@@ -30,23 +51,247 @@ func (repo *mongoUserRepository) UpdateUserAvatar(ctx context.Context, userId st
 	}
 
 	filterUser := bson.M{"_id": userObjectId}
-	updateUser := bson.D{{Key: "$set", Value: bson.D{{Key: "avatar_url", Value: avatarUrl}}}}
+	updateUser := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "avatar_url", Value: avatarUrl},
+		{Key: "updated_at", Value: time.Now().UTC()},
+	}}}
 
 	_, err = repo.collection.UpdateOne(ctx, filterUser, updateUser)
 	return err
 }
 
-func (repo *mongoUserRepository) GetUsers(ctx context.Context) ([]models.User, error) {
-	var users []models.User
+// ListUsers pages through the users by keyset, a page starts after the sort key and id of the
+// last user of the previous one so it does not shift when users are added in between.
+// Usernames are unique and compared like the username index does, they need no tie breaker.
+func (repo *mongoUserRepository) ListUsers(ctx context.Context, query user.UserQuery) ([]models.User, error) {
+	conditions := bson.A{}
+	if query.Search != "" {
+		// a substring match, the index is no help here
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query.Search), Options: "i"}
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"username": pattern},
+			bson.M{"full_name": pattern},
+		}})
+	}
+	// users that chose offline are not online to the others
+	if query.Online != nil && *query.Online {
+		conditions = append(conditions, bson.M{"is_online": true, "presence.status": bson.M{"$ne": models.StatusOffline}})
+	}
+	if query.Online != nil && !*query.Online {
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"is_online": false},
+			bson.M{"presence.status": models.StatusOffline},
+		}})
+	}
+	if query.Role != "" {
+		conditions = append(conditions, bson.M{"role": query.Role})
+	}
+	if query.WorkspaceID != "" {
+		conditions = append(conditions, bson.M{"workspace_id": query.WorkspaceID})
+	}
+	if query.RoomID != "" {
+		conditions = append(conditions, bson.M{"presence.current_room_id": query.RoomID})
+	}
+
+	order, after := 1, "$gt"
+	if query.Descending {
+		order, after = -1, "$lt"
+	}
+	sort := bson.D{{Key: query.Sort, Value: order}}
+	if query.Sort != user.SortUsername {
+		sort = append(sort, bson.E{Key: "_id", Value: order})
+	}
+
+	if query.AfterKey != nil {
+		if query.Sort == user.SortUsername {
+			conditions = append(conditions, bson.M{"username": bson.M{after: query.AfterKey}})
+		} else {
+			conditions = append(conditions, bson.M{"$or": bson.A{
+				bson.M{query.Sort: bson.M{after: query.AfterKey}},
+				bson.M{query.Sort: query.AfterKey, "_id": bson.M{after: query.AfterID}},
+			}})
+		}
+	}
+
+	filter := bson.M{}
+	if len(conditions) > 0 {
+		filter = bson.M{"$and": conditions}
+	}
+
+	projection := bson.D{{Key: query.Sort, Value: 1}}
+	for _, path := range query.Fields {
+		if path != query.Sort {
+			projection = append(projection, bson.E{Key: path, Value: 1})
+		}
+	}
+
+	opts := options.Find().
+		SetSort(sort).
+		SetLimit(int64(query.Limit)).
+		SetProjection(projection).
+		SetCollation(caseInsensitive)
 
-	cursor, err := repo.collection.Find(ctx, bson.M{})
+	cursor, err := repo.collection.Find(ctx, filter, opts)
 	if err != nil {
-		return nil, errors.New("user list not found")
+		return nil, fmt.Errorf("failed to list users: %w", err)
 	}
-	
+
+	users := []models.User{}
 	if err = cursor.All(ctx, &users); err != nil {
 		return nil, fmt.Errorf("failed to decode cursor: %w", err)
 	}
-	
 	return users, nil
 }
+
+func (repo *mongoUserRepository) GetUserById(ctx context.Context, id string) (*models.User, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id %v", err)
+	}
+
+	var found models.User
+	err = repo.collection.FindOne(ctx, bson.M{"_id": objectId}, options.FindOne().SetProjection(userProjection)).Decode(&found)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &found, nil
+}
+
+func (repo *mongoUserRepository) UpdateUserProfile(ctx context.Context, id string, update models.UpdateProfileRequest) (*models.User, error) {
+	set := bson.D{}
+	if update.FullName != nil {
+		set = append(set, bson.E{Key: "full_name", Value: *update.FullName})
+	}
+	if update.AvatarUrl != nil {
+		set = append(set, bson.E{Key: "avatar_url", Value: *update.AvatarUrl})
+	}
+	return repo.updateUser(ctx, id, set)
+}
+
+func (repo *mongoUserRepository) UpdateUserSettings(ctx context.Context, id string, update models.UpdateSettingsRequest) (*models.User, error) {
+	// every setting is its own path, so settings that are not sent are left alone
+	set := bson.D{}
+	if update.Timezone != nil {
+		set = append(set, bson.E{Key: "preferences.timezone", Value: *update.Timezone})
+	}
+	if notifications := update.Notifications; notifications != nil {
+		if notifications.ProximityChat != nil {
+			set = append(set, bson.E{Key: "preferences.notification_settings.proximity_chat", Value: *notifications.ProximityChat})
+		}
+		if notifications.Mentions != nil {
+			set = append(set, bson.E{Key: "preferences.notification_settings.mentions", Value: *notifications.Mentions})
+		}
+		if notifications.Meetings != nil {
+			set = append(set, bson.E{Key: "preferences.notification_settings.meetings", Value: *notifications.Meetings})
+		}
+	}
+	if avatar := update.Avatar; avatar != nil {
+		if avatar.VirtualBackground != nil {
+			set = append(set, bson.E{Key: "preferences.avatar_settings.virtual_background", Value: *avatar.VirtualBackground})
+		}
+		if avatar.ShowName != nil {
+			set = append(set, bson.E{Key: "preferences.avatar_settings.show_name", Value: *avatar.ShowName})
+		}
+	}
+	return repo.updateUser(ctx, id, set)
+}
+
+func (repo *mongoUserRepository) UpdateUserPresence(ctx context.Context, id string, presence models.Presence) (*models.User, error) {
+	// the status is replaced as a whole, the room the user is in stays
+	set := bson.D{
+		{Key: "presence.status", Value: presence.Status},
+		{Key: "presence.status_message", Value: presence.Message},
+		{Key: "presence.status_emoji", Value: presence.Emoji},
+		{Key: "presence.auto_expire_at", Value: presence.AutoExpireAt},
+		{Key: "presence.updated_at", Value: presence.UpdatedAt},
+	}
+	return repo.updateUser(ctx, id, set)
+}
+
+// ExpireStatuses reverts one user at a time, so a status set again in between is left alone
+// and several instances can run it at once without reverting a user twice
+func (repo *mongoUserRepository) ExpireStatuses(ctx context.Context, now time.Time) ([]models.User, error) {
+	filter := bson.M{"presence.auto_expire_at": bson.M{"$lte": now}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "presence.status", Value: models.StatusOnline},
+		{Key: "presence.status_message", Value: ""},
+		{Key: "presence.status_emoji", Value: ""},
+		{Key: "presence.auto_expire_at", Value: nil},
+		{Key: "presence.updated_at", Value: now},
+	}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(userProjection)
+
+	expired := []models.User{}
+	for {
+		var user models.User
+		err := repo.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+		if err == mongo.ErrNoDocuments {
+			return expired, nil
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired = append(expired, user)
+	}
+}
+
+func (repo *mongoUserRepository) UpdateAutoPresence(ctx context.Context, id string, status string, message string, lastActiveAt *time.Time) (*models.User, error) {
+	set := bson.D{
+		{Key: "presence.auto_status", Value: status},
+		{Key: "presence.auto_message", Value: message},
+	}
+	if lastActiveAt != nil {
+		set = append(set, bson.E{Key: "presence.last_active_at", Value: *lastActiveAt})
+	}
+
+	// the rules run on their own, they leave updated_at to the changes users make
+	return repo.setUser(ctx, id, set)
+}
+
+func (repo *mongoUserRepository) EachOnlineUser(ctx context.Context, fn func(user *models.User) error) error {
+	cursor, err := repo.collection.Find(ctx, bson.M{"is_online": true}, options.Find().SetProjection(userProjection))
+	if err != nil {
+		return fmt.Errorf("failed to list online users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return fmt.Errorf("failed to decode cursor: %w", err)
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// updateUser sets the fields and updated_at, and returns the user as it is afterwards
+func (repo *mongoUserRepository) updateUser(ctx context.Context, id string, set bson.D) (*models.User, error) {
+	set = append(set, bson.E{Key: "updated_at", Value: time.Now().UTC()})
+	return repo.setUser(ctx, id, set)
+}
+
+// setUser sets the fields and returns the user as it is afterwards, nil if there is no user with the id
+func (repo *mongoUserRepository) setUser(ctx context.Context, id string, set bson.D) (*models.User, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id %v", err)
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(userProjection)
+
+	var updated models.User
+	err = repo.collection.FindOneAndUpdate(ctx, bson.M{"_id": objectId}, bson.D{{Key: "$set", Value: set}}, opts).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &updated, nil
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -16,24 +18,183 @@ type RegisterResponse struct {
 	UserID  string `json:"user_id"`
 }
 
-type FailedResponse struct {
-	Error string `json:"message"`
-}
-
 type LoginRequest struct {
+	// Username takes a username or an email, Email is there for clients that log in with the email field
 	Username string `json:"username"`
+	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
 type LoginResponse struct {
-	Message string `json:"message"`
-	Token   string `json:"token"`
-	UserID  string `json:"user_id"`
+	Message      string `json:"message"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	UserID       string `json:"user_id"`
+	Role         string `json:"role,omitempty"`
+	// set instead of the tokens when the account has two factor authentication
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+	// set for admins that have to enroll a second factor before they get admin claims
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+type MagicLinkLoginRequest struct {
+	Token string `json:"token"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+	Confirm  string `json:"confirm"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// EmailVerificationClaims are carried by the signed link mailed after registration
+type EmailVerificationClaims struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+type MFAEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token"`
+	// either a TOTP code or one of the recovery codes
+	Code string `json:"code"`
+}
+
+// MFAClaims are carried by the short lived token handed out when a login still needs a second factor
+type MFAClaims struct {
+	UserID string `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+type CreatePersonalAccessTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// defaults to 30 days, at most 365
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+type PersonalAccessTokenResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreatePersonalAccessTokenResponse is the only time the token itself is shown
+type CreatePersonalAccessTokenResponse struct {
+	PersonalAccessTokenResponse
+	Token string `json:"token"`
+}
+
+// Reauthentication is part of every request that changes a credential,
+// one of the two proves the user is still the one at the keyboard
+type Reauthentication struct {
+	CurrentPassword string `json:"current_password"`
+	// a TOTP code or one of the recovery codes, for accounts with two factor authentication
+	Code string `json:"code"`
+}
+
+type ChangePasswordRequest struct {
+	Reauthentication
+	Password string `json:"password"`
+	Confirm  string `json:"confirm"`
+}
+
+type ChangeEmailRequest struct {
+	Reauthentication
+	Email string `json:"email"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+type ChangeUsernameRequest struct {
+	Reauthentication
+	Username string `json:"username"`
+}
+
+// EmailChangeClaims are carried by the link mailed to the new address of an email change.
+// The change only goes through while the user still has OldEmail, so the link works once.
+type EmailChangeClaims struct {
+	UserID   string `json:"user_id"`
+	OldEmail string `json:"old_email"`
+	Email    string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Claims of an access token.
+// RegisteredClaims.ID carries the jti which is used to revoke a single token.
 type Claims struct {
 	UserID   string
 	Username string
 	Role     string
+	// MFA is true when the login passed a second factor
+	MFA bool `json:"mfa,omitempty"`
+	// SessionID is the session the token was issued to
+	SessionID string `json:"sid,omitempty"`
+	// Rooms are the only rooms a guest may enter, only set for guests
+	Rooms []string `json:"rooms,omitempty"`
+	// Act names the admin acting as the user, only set when impersonating (RFC 8693)
+	Act *Actor `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
+
+// Actor is who really makes the requests of an impersonation token
+type Actor struct {
+	Subject  string `json:"sub"`
+	Username string `json:"username,omitempty"`
+}
+
+type ImpersonateRequest struct {
+	UserID string `json:"user_id"`
+	// kept in the audit log
+	Reason string `json:"reason"`
+}
+
+type ImpersonationResponse struct {
+	Message   string `json:"message"`
+	Token     string `json:"token"`
+	ExpiresIn int64  `json:"expires_in"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+}
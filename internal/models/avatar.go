@@ -12,4 +12,21 @@ type Avatar struct {
 
 type GetAvatarsResponse struct {
 	Avatars []Avatar `json:"avatars"`
-}
\ No newline at end of file
+}
+
+type CreateAvatarRequest struct {
+	Name      string `json:"name"`
+	AvatarUrl string `json:"avatar_url"`
+}
+
+// UploadAvatarResponse has the thumbnails made of an upload, largest first.
+// The largest is the avatar_url of the user from now on.
+type UploadAvatarResponse struct {
+	AvatarUrl  string            `json:"avatar_url"`
+	Thumbnails []AvatarThumbnail `json:"thumbnails"`
+}
+
+type AvatarThumbnail struct {
+	Size int    `json:"size"`
+	Url  string `json:"url"`
+}
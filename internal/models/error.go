@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ErrorResponse is the standard error body described in docs/Discussion.md
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+}
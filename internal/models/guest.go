@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GuestInvite is a link that lets visitors in without an account.
+// It is either a workspace invite or the shareable link of a room,
+// in both cases the guests can only enter Rooms. Only the hash of the token is stored.
+type GuestInvite struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	TokenHash string             `bson:"token_hash"`
+	CreatedBy string             `bson:"created_by"`
+	Rooms     []string           `bson:"rooms"`
+	// MaxUses of zero allows any number of guests
+	MaxUses   int       `bson:"max_uses"`
+	Uses      int       `bson:"uses"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// Guest is a visitor that joined through an invite, it goes away with its token
+type Guest struct {
+	ID          string    `bson:"_id"`
+	DisplayName string    `bson:"display_name"`
+	InviteID    string    `bson:"invite_id"`
+	Rooms       []string  `bson:"rooms"`
+	CreatedAt   time.Time `bson:"created_at"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+type CreateGuestInviteRequest struct {
+	Rooms []string `json:"rooms"`
+	// defaults to 7 days, at most 30
+	ExpiresInHours int `json:"expires_in_hours"`
+	MaxUses        int `json:"max_uses"`
+}
+
+type GuestInviteResponse struct {
+	ID        string    `json:"id"`
+	Rooms     []string  `json:"rooms"`
+	MaxUses   int       `json:"max_uses"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// the link to share, only returned when the invite is created
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+type GuestJoinRequest struct {
+	Invite      string `json:"invite"`
+	DisplayName string `json:"display_name"`
+}
+
+type GuestJoinResponse struct {
+	Message     string   `json:"message"`
+	GuestID     string   `json:"guest_id"`
+	DisplayName string   `json:"display_name"`
+	Rooms       []string `json:"rooms"`
+	Token       string   `json:"token"`
+	ExpiresIn   int64    `json:"expires_in"`
+}
@@ -0,0 +1,7 @@
+package models
+
+// Page is embedded in the responses of the list endpoints, NextCursor is nil
+// on the last page. See the pagination package for the cursors.
+type Page struct {
+	NextCursor *string `json:"next_cursor"`
+}
@@ -0,0 +1,77 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson"
+
+// Preferences are the settings of a user, see the users collection in docs/SCHEMA.md
+type Preferences struct {
+	Timezone      string               `bson:"timezone" json:"timezone"`
+	Notifications NotificationSettings `bson:"notification_settings" json:"notification_settings"`
+	Avatar        AvatarSettings       `bson:"avatar_settings" json:"avatar_settings"`
+}
+
+// NotificationSettings choose what the user is notified about
+type NotificationSettings struct {
+	ProximityChat bool `bson:"proximity_chat" json:"proximity_chat"`
+	Mentions      bool `bson:"mentions" json:"mentions"`
+	Meetings      bool `bson:"meetings" json:"meetings"`
+}
+
+// AvatarSettings change how the avatar of the user is drawn in rooms
+type AvatarSettings struct {
+	VirtualBackground string `bson:"virtual_background" json:"virtual_background"`
+	ShowName          bool   `bson:"show_name" json:"show_name"`
+}
+
+// DefaultPreferences are the settings of users that did not change them
+func DefaultPreferences() Preferences {
+	return Preferences{
+		Timezone: "UTC",
+		Notifications: NotificationSettings{
+			ProximityChat: true,
+			Mentions:      true,
+			Meetings:      true,
+		},
+		Avatar: AvatarSettings{
+			VirtualBackground: "none",
+			ShowName:          true,
+		},
+	}
+}
+
+// UnmarshalBSON starts from the defaults, settings added after a user saved theirs
+// and settings that were never saved keep their default
+func (p *Preferences) UnmarshalBSON(data []byte) error {
+	type stored Preferences
+	decoded := stored(DefaultPreferences())
+	if err := bson.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*p = Preferences(decoded)
+	return nil
+}
+
+// Settings returns the preferences of u, the defaults if they were never saved
+func (u *User) Settings() Preferences {
+	if u.Preferences == nil {
+		return DefaultPreferences()
+	}
+	return *u.Preferences
+}
+
+// UpdateSettingsRequest changes the settings that are set and leaves the others alone
+type UpdateSettingsRequest struct {
+	Timezone      *string                     `json:"timezone"`
+	Notifications *NotificationSettingsUpdate `json:"notification_settings"`
+	Avatar        *AvatarSettingsUpdate       `json:"avatar_settings"`
+}
+
+type NotificationSettingsUpdate struct {
+	ProximityChat *bool `json:"proximity_chat"`
+	Mentions      *bool `json:"mentions"`
+	Meetings      *bool `json:"meetings"`
+}
+
+type AvatarSettingsUpdate struct {
+	VirtualBackground *string `json:"virtual_background"`
+	ShowName          *bool   `json:"show_name"`
+}
@@ -0,0 +1,84 @@
+package models
+
+import "time"
+
+// the statuses a user can show, see docs/API.md
+const (
+	StatusOnline       = "online"
+	StatusAway         = "away"
+	StatusBusy         = "busy"
+	StatusDoNotDisturb = "do-not-disturb"
+	StatusOffline      = "offline"
+
+	// StatusOffHours is only set by the presence rules, users cannot choose it
+	StatusOffHours = "off-hours"
+)
+
+// Presence is the status a user chose along with the one the presence rules decided on.
+// It is nil until either was set for the first time. Choosing offline hides a user that is signed in.
+type Presence struct {
+	Status  string `bson:"status,omitempty"`
+	Message string `bson:"status_message,omitempty"`
+	Emoji   string `bson:"status_emoji,omitempty"`
+	// AutoExpireAt is when the status reverts to online, nil for a status that stays
+	AutoExpireAt *time.Time `bson:"auto_expire_at,omitempty"`
+	UpdatedAt    time.Time  `bson:"updated_at,omitempty"`
+
+	// AutoStatus is what the presence rules decided, empty for online
+	AutoStatus   string     `bson:"auto_status,omitempty"`
+	AutoMessage  string     `bson:"auto_message,omitempty"`
+	LastActiveAt *time.Time `bson:"last_active_at,omitempty"`
+}
+
+// PresenceStatus is the status of a user as the others see it
+type PresenceStatus struct {
+	Status       string     `json:"status"`
+	Message      string     `json:"message,omitempty"`
+	Emoji        string     `json:"emoji,omitempty"`
+	AutoExpireAt *time.Time `json:"auto_expire_at,omitempty"`
+	// Automatic is set when the presence rules decided the status rather than the user
+	Automatic bool `json:"automatic,omitempty"`
+}
+
+// Equal reports whether p and other show the same
+func (p PresenceStatus) Equal(other PresenceStatus) bool {
+	sameExpiry := p.AutoExpireAt == other.AutoExpireAt ||
+		(p.AutoExpireAt != nil && other.AutoExpireAt != nil && p.AutoExpireAt.Equal(*other.AutoExpireAt))
+	return p.Status == other.Status && p.Message == other.Message && p.Emoji == other.Emoji &&
+		p.Automatic == other.Automatic && sameExpiry
+}
+
+// CurrentStatus is the status u shows at now. A status the user chose wins over the presence
+// rules, except for online which the rules may turn into away or off hours. Users that are
+// not signed in are offline but keep their message, a status past its auto_expire_at is
+// back to online before the expirer gets to it.
+func (u *User) CurrentStatus(now time.Time) PresenceStatus {
+	p := u.Presence
+	if p == nil {
+		p = &Presence{}
+	}
+
+	current := PresenceStatus{Status: StatusOnline}
+	if p.Status != "" && (p.AutoExpireAt == nil || now.Before(*p.AutoExpireAt)) {
+		current = PresenceStatus{Status: p.Status, Message: p.Message, Emoji: p.Emoji, AutoExpireAt: p.AutoExpireAt}
+	}
+
+	switch {
+	case !u.IsOnline:
+		current.Status, current.AutoExpireAt = StatusOffline, nil
+	case p.AutoStatus != "" && current.Status == StatusOnline:
+		current.Status, current.Automatic = p.AutoStatus, true
+		if current.Message == "" && current.Emoji == "" {
+			current.Message = p.AutoMessage
+		}
+	}
+	return current
+}
+
+// UpdateStatusRequest replaces the status, message and emoji are cleared when they are not sent
+type UpdateStatusRequest struct {
+	Status       string     `json:"status"`
+	Message      string     `json:"message"`
+	Emoji        string     `json:"emoji"`
+	AutoExpireAt *time.Time `json:"auto_expire_at"`
+}
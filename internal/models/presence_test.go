@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUser_CurrentStatus(t *testing.T) {
+	now := time.Date(2025, 1, 16, 14, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Minute)
+	busy := &Presence{Status: StatusBusy, Message: "In a meeting", Emoji: "📅", AutoExpireAt: &later}
+
+	tests := []struct {
+		name string
+		user User
+		want PresenceStatus
+	}{
+		{"never set", User{IsOnline: true}, PresenceStatus{Status: StatusOnline}},
+		{"chosen", User{IsOnline: true, Presence: busy}, PresenceStatus{Status: StatusBusy, Message: "In a meeting", Emoji: "📅", AutoExpireAt: &later}},
+		{"signed out keeps the message", User{Presence: busy}, PresenceStatus{Status: StatusOffline, Message: "In a meeting", Emoji: "📅"}},
+		{"expired", User{IsOnline: true, Presence: &Presence{Status: StatusAway, Message: "Lunch", AutoExpireAt: &earlier}}, PresenceStatus{Status: StatusOnline}},
+		{"idle", User{IsOnline: true, Presence: &Presence{AutoStatus: StatusAway}}, PresenceStatus{Status: StatusAway, Automatic: true}},
+		// the rules only change online, the message stays the one the user chose
+		{"chosen online but idle", User{IsOnline: true, Presence: &Presence{Status: StatusOnline, Message: "Remote today", AutoStatus: StatusAway}}, PresenceStatus{Status: StatusAway, Message: "Remote today", Automatic: true}},
+		{"chosen busy but idle", User{IsOnline: true, Presence: &Presence{Status: StatusBusy, AutoStatus: StatusAway}}, PresenceStatus{Status: StatusBusy}},
+		{"meeting", User{IsOnline: true, Presence: &Presence{AutoStatus: StatusBusy, AutoMessage: "In a meeting"}}, PresenceStatus{Status: StatusBusy, Message: "In a meeting", Automatic: true}},
+		{"signed out after a meeting", User{Presence: &Presence{AutoStatus: StatusBusy, AutoMessage: "In a meeting"}}, PresenceStatus{Status: StatusOffline}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.user.CurrentStatus(now))
+		})
+	}
+}
+
+func TestUser_AppearingOffline(t *testing.T) {
+	user := User{IsOnline: true, Presence: &Presence{Status: StatusOffline}}
+	assert.False(t, user.Public().IsOnline)
+	assert.Equal(t, StatusOffline, user.Public().Presence.Status)
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is the server side record of an issued refresh token.
+// Only the SHA-256 hash of the token is stored. Every token issued from
+// the same login shares a FamilyID so that reuse of a rotated token can
+// revoke the whole chain.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	TokenHash string             `bson:"token_hash"`
+	FamilyID  string             `bson:"family_id"`
+	UserID    string             `bson:"user_id"`
+	MFA       bool               `bson:"mfa"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+	RevokedAt *time.Time         `bson:"revoked_at,omitempty"`
+}
+
+// TokenPair is what the auth service hands back after a successful login or refresh
+type TokenPair struct {
+	UserID       string
+	Role         string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+	// MFAEnrollmentRequired is set when an admin got member claims because they have no second factor yet
+	MFAEnrollmentRequired bool
+}
+
+// LoginResult is either a token pair, or an MFA token when a second factor is still needed
+type LoginResult struct {
+	UserID   string
+	Tokens   *TokenPair
+	MFAToken string
+}
+
+// Purposes of one time tokens
+const (
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeMagicLink     = "magic_link"
+)
+
+// OneTimeToken is a single use, expiring token that is mailed to a user.
+// Like refresh tokens only the hash is stored.
+type OneTimeToken struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	TokenHash string             `bson:"token_hash"`
+	UserID    string             `bson:"user_id"`
+	Purpose   string             `bson:"purpose"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+}
+
+// PersonalAccessToken lets scripts and bots act as a user without their password.
+// Only the SHA-256 hash is stored, Prefix is kept so the user can tell tokens apart.
+// MFA is whether the session that created the token had passed a second factor.
+type PersonalAccessToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     string             `bson:"user_id"`
+	Name       string             `bson:"name"`
+	Prefix     string             `bson:"prefix"`
+	TokenHash  string             `bson:"token_hash"`
+	Scopes     []string           `bson:"scopes"`
+	MFA        bool               `bson:"mfa"`
+	ExpiresAt  time.Time          `bson:"expires_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at"`
+}
+
+// Session is one login of a user on a device.
+// Its ID is the FamilyID of the refresh tokens issued to it.
+type Session struct {
+	ID         string     `bson:"_id"`
+	UserID     string     `bson:"user_id"`
+	DeviceInfo DeviceInfo `bson:"device_info"`
+	IP         string     `bson:"ip"`
+	UserAgent  string     `bson:"user_agent"`
+	CreatedAt  time.Time  `bson:"created_at"`
+	LastSeenAt time.Time  `bson:"last_seen_at"`
+	// moves with every refresh, the session ends with its last refresh token
+	ExpiresAt time.Time  `bson:"expires_at"`
+	RevokedAt *time.Time `bson:"revoked_at,omitempty"`
+}
+
+type DeviceInfo struct {
+	Platform string `bson:"platform" json:"platform"`
+	OS       string `bson:"os" json:"os"`
+	Browser  string `bson:"browser" json:"browser"`
+	Version  string `bson:"version" json:"version"`
+}
+
+type SessionResponse struct {
+	ID         string     `json:"id"`
+	DeviceInfo DeviceInfo `json:"device_info"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	Current    bool       `json:"current"`
+}
@@ -3,21 +3,170 @@ package models
 import (
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type User struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Email      string             `bson:"email"`
+	Username   string             `bson:"username"`
+	FullName   string             `bson:"full_name,omitempty"`
+	Password   string             `bson:"password" json:"-"`
+	Role       string             `bson:"role"`
+	AvatarUrl  string             `bson:"avatar_url"`
+	IsOnline   bool               `bson:"is_online"`
+	Verified   bool               `bson:"verified"`
+	VerifiedAt *time.Time         `bson:"verified_at,omitempty"`
+	MFA        MFASettings        `bson:"mfa" json:"-"`
+	Identities []Identity         `bson:"identities,omitempty"`
+	// Preferences is nil until the user saves their settings for the first time
+	Preferences *Preferences `bson:"preferences,omitempty"`
+	// IsOnline is whether the user is signed in, Presence the status they chose
+	Presence  *Presence `bson:"presence,omitempty"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at,omitempty"`
+}
+
+// UnmarshalBSON reads users stored before email verification existed as verified,
+// they have no verified field and were all allowed in as members at the time
+func (u *User) UnmarshalBSON(data []byte) error {
+	type stored User
+	user := stored{Verified: true}
+	if err := bson.Unmarshal(data, &user); err != nil {
+		return err
+	}
+	*u = User(user)
+	return nil
+}
+
+// PublicUser is what every member of the workspace can see of a user
+type PublicUser struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	FullName  string `json:"full_name"`
+	AvatarUrl string `json:"avatar_url"`
+	// IsOnline is false for users that appear offline
+	IsOnline bool           `json:"is_online"`
+	Presence PresenceStatus `json:"presence"`
+}
+
+// SelfUser is the profile users get of their own account
+type SelfUser struct {
+	PublicUser
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	Verified   bool      `json:"verified"`
+	MFAEnabled bool      `json:"mfa_enabled"`
+	Timezone   string    `json:"timezone"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// AdminUser is a user as seen by those allowed to manage users
+type AdminUser struct {
+	SelfUser
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	// LinkedProviders are the issuers of the single sign-on identities
+	LinkedProviders []string `json:"linked_providers,omitempty"`
+}
+
+// Public maps u to the response every member may see
+func (u *User) Public() PublicUser {
+	status := u.CurrentStatus(time.Now())
+	return PublicUser{
+		ID:        u.ID.Hex(),
+		Username:  u.Username,
+		FullName:  u.FullName,
+		AvatarUrl: u.AvatarUrl,
+		IsOnline:  status.Status != StatusOffline,
+		Presence:  status,
+	}
+}
+
+// Self maps u to the response for the user themselves
+func (u *User) Self() SelfUser {
+	return SelfUser{
+		PublicUser: u.Public(),
+		Email:      u.Email,
+		Role:       u.Role,
+		Verified:   u.Verified,
+		MFAEnabled: u.MFA.Enabled,
+		Timezone:   u.Settings().Timezone,
+		CreatedAt:  u.CreatedAt,
+		UpdatedAt:  u.UpdatedAt,
+	}
+}
+
+// Admin maps u to the response for admins
+func (u *User) Admin() AdminUser {
+	admin := AdminUser{
+		SelfUser:   u.Self(),
+		VerifiedAt: u.VerifiedAt,
+	}
+	for _, identity := range u.Identities {
+		admin.LinkedProviders = append(admin.LinkedProviders, identity.Issuer)
+	}
+	return admin
+}
+
+// MFASettings hold the TOTP state of a user.
+// The secret waits in PendingSecret until a first code confirms the enrollment.
+// Recovery codes are stored as SHA-256 hashes and removed once used.
+type MFASettings struct {
+	Enabled       bool       `bson:"enabled"`
+	Secret        string     `bson:"secret,omitempty" json:"-"`
+	PendingSecret string     `bson:"pending_secret,omitempty" json:"-"`
+	RecoveryCodes []string   `bson:"recovery_codes,omitempty" json:"-"`
+	LastUsedStep  int64      `bson:"last_used_step,omitempty"`
+	EnabledAt     *time.Time `bson:"enabled_at,omitempty"`
+}
+
+// Identity links a user to an account at an OpenID Connect provider.
+// The subject is only unique within its issuer.
+type Identity struct {
+	Issuer   string    `bson:"issuer"`
+	Subject  string    `bson:"subject"`
+	Email    string    `bson:"email,omitempty"`
+	LinkedAt time.Time `bson:"linked_at"`
+}
+
+// UsernameAlias is a previous username of a user.
+// Until it expires it still logs in and nobody else can register it.
+type UsernameAlias struct {
 	ID        primitive.ObjectID `bson:"_id"`
-	Email     string             `bson:"email"`
 	Username  string             `bson:"username"`
-	Password  string             `bson:"password"`
-	Role      string             `bson:"role"`
-	AvatarUrl string             `bson:"avatar_url"`
-	IsOnline  bool               `bson:"is_online"`
+	UserID    string             `bson:"user_id"`
 	CreatedAt time.Time          `bson:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at,omitempty"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// ListUsersRequest are the query parameters of the user directory
+type ListUsersRequest struct {
+	Cursor    string `form:"cursor"`
+	Limit     int    `form:"limit"`
+	Search    string `form:"q"`
+	Online    *bool  `form:"online"`
+	Role      string `form:"role"`
+	Workspace string `form:"workspace"`
+	Room      string `form:"room"`
+	Sort      string `form:"sort"`
+	Fields    string `form:"fields"`
+}
+
+type ListUsersResponse struct {
+	// Users are PublicUser or AdminUser, only with the requested fields when fields was set
+	Users []map[string]any `json:"users"`
+	Page
 }
 
 type UpdateUserAvatarRequest struct {
 	AvatarId string `json:"avatar_id"`
 }
+
+// UpdateProfileRequest changes the fields that are set and leaves the others alone,
+// an empty string clears a field
+type UpdateProfileRequest struct {
+	FullName  *string `json:"full_name"`
+	AvatarUrl *string `json:"avatar_url"`
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func secretUser() User {
+	now := time.Now()
+	return User{
+		ID:       primitive.NewObjectID(),
+		Email:    "test@test.com",
+		Username: "test",
+		Password: "$2a$10$secrethash",
+		Role:     "user",
+		Verified: true,
+		MFA: MFASettings{
+			Enabled:       true,
+			Secret:        "TOTPSECRET",
+			PendingSecret: "PENDINGSECRET",
+			RecoveryCodes: []string{"recoverycodehash"},
+			EnabledAt:     &now,
+		},
+		Identities: []Identity{{Issuer: "https://idp.test", Subject: "subject-1", LinkedAt: now}},
+		CreatedAt:  now,
+	}
+}
+
+func TestUser_SecretsAreNotSerialized(t *testing.T) {
+	user := secretUser()
+
+	// not even the model itself, in case it is handed to c.JSON by mistake
+	views := map[string]any{
+		"user":   user,
+		"public": user.Public(),
+		"self":   user.Self(),
+		"admin":  user.Admin(),
+	}
+	for name, view := range views {
+		body, err := json.Marshal(view)
+		assert.NoError(t, err)
+		for _, secret := range []string{"$2a$10$secrethash", "TOTPSECRET", "PENDINGSECRET", "recoverycodehash"} {
+			assert.NotContains(t, string(body), secret, name)
+		}
+	}
+}
+
+func TestUser_Views(t *testing.T) {
+	user := secretUser()
+
+	var public map[string]any
+	body, _ := json.Marshal(user.Public())
+	json.Unmarshal(body, &public)
+	assert.ElementsMatch(t, []string{"id", "username", "full_name", "avatar_url", "is_online", "presence"}, keys(public))
+
+	var self map[string]any
+	body, _ = json.Marshal(user.Self())
+	json.Unmarshal(body, &self)
+	assert.Equal(t, "test@test.com", self["email"])
+	assert.Equal(t, true, self["mfa_enabled"])
+	assert.NotContains(t, self, "linked_providers")
+
+	var admin map[string]any
+	body, _ = json.Marshal(user.Admin())
+	json.Unmarshal(body, &admin)
+	assert.Equal(t, user.ID.Hex(), admin["id"])
+	assert.Equal(t, []any{"https://idp.test"}, admin["linked_providers"])
+	assert.NotContains(t, string(body), "subject-1")
+}
+
+func keys(m map[string]any) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestPreferences_MissingSettingsKeepTheirDefault(t *testing.T) {
+	// written by a partial update before the user saved anything else
+	doc, _ := bson.Marshal(bson.M{
+		"_id":         primitive.NewObjectID(),
+		"username":    "test",
+		"preferences": bson.M{"notification_settings": bson.M{"mentions": false}},
+	})
+
+	var user User
+	assert.NoError(t, bson.Unmarshal(doc, &user))
+
+	want := DefaultPreferences()
+	want.Notifications.Mentions = false
+	assert.Equal(t, want, user.Settings())
+
+	// and users without any preferences get all the defaults
+	doc, _ = bson.Marshal(bson.M{"_id": primitive.NewObjectID(), "username": "test"})
+	user = User{}
+	assert.NoError(t, bson.Unmarshal(doc, &user))
+	assert.Nil(t, user.Preferences)
+	assert.Equal(t, DefaultPreferences(), user.Settings())
+}
+
+func TestUser_MissingVerifiedCountsAsVerified(t *testing.T) {
+	legacy, _ := bson.Marshal(bson.M{"_id": primitive.NewObjectID(), "username": "old-timer", "role": "admin"})
+	unverified, _ := bson.Marshal(bson.M{"_id": primitive.NewObjectID(), "username": "newcomer", "role": "user", "verified": false})
+
+	var user User
+	assert.NoError(t, bson.Unmarshal(legacy, &user))
+	assert.True(t, user.Verified)
+	assert.Equal(t, "admin", user.Role)
+
+	user = User{}
+	assert.NoError(t, bson.Unmarshal(unverified, &user))
+	assert.False(t, user.Verified)
+
+	// the same goes for users inside other documents, like the results of a cursor
+	nested, _ := bson.Marshal(bson.M{"users": bson.A{bson.Raw(legacy), bson.Raw(unverified)}})
+	var decoded struct {
+		Users []User `bson:"users"`
+	}
+	assert.NoError(t, bson.Unmarshal(nested, &decoded))
+	assert.True(t, decoded.Users[0].Verified)
+	assert.False(t, decoded.Users[1].Verified)
+
+	// and new users keep their field, verified or not
+	stored, _ := bson.Marshal(User{ID: primitive.NewObjectID(), Username: "fresh"})
+	assert.NoError(t, bson.Unmarshal(stored, &user))
+	assert.False(t, user.Verified)
+}
@@ -0,0 +1,114 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// how long a user has to finish logging in at the provider
+const flowTTL = 10 * time.Minute
+
+// Flow is what has to be remembered between sending the user to the provider and the callback
+type Flow struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// NewFlow creates fresh state, nonce and PKCE verifier for one login
+func NewFlow() (*Flow, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	// RFC 7636 wants 43 to 128 characters, 32 bytes encode to 43
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Flow{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(flowTTL),
+	}, nil
+}
+
+// CodeChallenge is the S256 PKCE challenge of the verifier
+func (f *Flow) CodeChallenge() string {
+	return CodeChallengeS256(f.CodeVerifier)
+}
+
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random value %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// FlowStore keeps flows until their callback arrives
+type FlowStore interface {
+	Save(flow *Flow) error
+	// Consume returns and removes the flow for state, or nil if there is none.
+	// A state can only be used once.
+	Consume(state string) (*Flow, error)
+}
+
+type memoryFlowStore struct {
+	mu    sync.Mutex
+	flows map[string]*Flow
+}
+
+// NewMemoryFlowStore keeps flows in memory.
+// With several instances the callback has to reach the instance that started the login.
+func NewMemoryFlowStore() FlowStore {
+	return &memoryFlowStore{flows: map[string]*Flow{}}
+}
+
+func (s *memoryFlowStore) Save(flow *Flow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// drop abandoned logins so the map cannot grow without bound
+	now := time.Now()
+	for state, f := range s.flows {
+		if now.After(f.ExpiresAt) {
+			delete(s.flows, state)
+		}
+	}
+
+	s.flows[flow.State] = flow
+	return nil
+}
+
+func (s *memoryFlowStore) Consume(state string) (*Flow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	flow, ok := s.flows[state]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.flows, state)
+
+	if time.Now().After(flow.ExpiresAt) {
+		return nil, nil
+	}
+	return flow, nil
+}
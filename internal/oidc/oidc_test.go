@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/palSagnik/uriel/internal/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://uriel.test/api/v1/auth/oidc/callback"
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	idp := oidctest.NewServer("uriel", "secret")
+	t.Cleanup(idp.Close)
+
+	provider, err := NewProvider(context.Background(), Config{
+		Issuer:       idp.URL,
+		ClientID:     "uriel",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+	}, idp.Client())
+	require.NoError(t, err)
+
+	return provider, idp
+}
+
+// authorize follows the provider redirect and returns the code and state of the callback
+func authorize(t *testing.T, idp *oidctest.Server, authURL string) (string, string) {
+	client := idp.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestExchange(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	idp.SetUser(oidctest.User{Subject: "42", Email: "jane@company.com", EmailVerified: true})
+
+	flow, err := NewFlow()
+	require.NoError(t, err)
+
+	code, state := authorize(t, idp, provider.AuthCodeURL(flow.State, flow.Nonce, flow.CodeChallenge()))
+	assert.Equal(t, flow.State, state)
+
+	claims, err := provider.Exchange(context.Background(), code, flow.CodeVerifier, flow.Nonce)
+	require.NoError(t, err)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, "jane@company.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+
+	// codes are single use
+	_, err = provider.Exchange(context.Background(), code, flow.CodeVerifier, flow.Nonce)
+	assert.Error(t, err)
+}
+
+func TestExchange_WrongVerifier(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	idp.SetUser(oidctest.User{Subject: "42"})
+
+	flow, _ := NewFlow()
+	other, _ := NewFlow()
+
+	code, _ := authorize(t, idp, provider.AuthCodeURL(flow.State, flow.Nonce, flow.CodeChallenge()))
+
+	_, err := provider.Exchange(context.Background(), code, other.CodeVerifier, flow.Nonce)
+	assert.Error(t, err)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	provider, idp := newTestProvider(t)
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   idp.URL,
+			"sub":   "42",
+			"aud":   "uriel",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": "n",
+		}
+	}
+
+	_, err := provider.VerifyIDToken(context.Background(), idp.SignIDToken(valid()), "n")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		change func(jwt.MapClaims)
+	}{
+		{"wrong nonce", func(c jwt.MapClaims) { c["nonce"] = "other" }},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.test" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+		{"other authorized party", func(c jwt.MapClaims) {
+			c["aud"] = []string{"uriel", "someone-else"}
+			c["azp"] = "someone-else"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.change(claims)
+
+			_, err := provider.VerifyIDToken(context.Background(), idp.SignIDToken(claims), "n")
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+
+	t.Run("not signed by the provider", func(t *testing.T) {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid()).SignedString([]byte("secret"))
+
+		_, err := provider.VerifyIDToken(context.Background(), token, "n")
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+}
+
+func TestMemoryFlowStore(t *testing.T) {
+	store := NewMemoryFlowStore()
+
+	flow, _ := NewFlow()
+	require.NoError(t, store.Save(flow))
+
+	got, err := store.Consume(flow.State)
+	assert.NoError(t, err)
+	assert.Equal(t, flow, got)
+
+	// a state is only good once
+	got, err = store.Consume(flow.State)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	expired, _ := NewFlow()
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	store.Save(expired)
+
+	got, _ = store.Consume(expired.State)
+	assert.Nil(t, got)
+}
@@ -0,0 +1,72 @@
+// Package pagination is the cursor contract of the list endpoints.
+//
+// A list answers with a page of items and next_cursor, which is null on the last page.
+// The client passes next_cursor back as the cursor query parameter, with the same sort
+// and filters, to get the page after it. Cursors are opaque to clients, they name the
+// sort key and the id of the last item so pages stay stable while items are added.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var ErrInvalidCursor = apperr.Invalid("invalid cursor")
+
+// Cursor is the position after the last item of a page, Value is the sort key of that
+// item and ID breaks ties between items with the same key
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Decode reads a cursor handed out for sort, cursors of another sort are invalid
+func Decode(s string, sort string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort != sort || !primitive.IsValidObjectID(cursor.ID) {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// Limit returns the page size for the requested one, DefaultLimit when none was requested
+func Limit(requested int) (int, error) {
+	if requested == 0 {
+		return DefaultLimit, nil
+	}
+	if requested < 1 || requested > MaxLimit {
+		return 0, fmt.Errorf("must be between 1 and %d", MaxLimit)
+	}
+	return requested, nil
+}
+
+// Next returns the page info of a page with more items after last.
+// Repositories load one item more than the limit to find out if there are.
+func Next(last Cursor) models.Page {
+	next := last.Encode()
+	return models.Page{NextCursor: &next}
+}
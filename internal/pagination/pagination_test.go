@@ -0,0 +1,50 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursor_RoundTrip(t *testing.T) {
+	cursor := Cursor{Sort: "-created_at", Value: "2025-01-15T10:30:00.123Z", ID: primitive.NewObjectID().Hex()}
+
+	page := Next(cursor)
+	decoded, err := Decode(*page.NextCursor, "-created_at")
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, *decoded)
+
+	// a cursor does not carry over to another order
+	_, err = Decode(*page.NextCursor, "created_at")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestDecode_RejectsTamperedCursors(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("not json")),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"s":"username","v":"alice","id":{"$gt":""}}`)),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"s":"username","v":"alice","id":"nope"}`)),
+	} {
+		_, err := Decode(raw, "username")
+		assert.ErrorIs(t, err, ErrInvalidCursor, raw)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	limit, err := Limit(0)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultLimit, limit)
+
+	limit, err = Limit(MaxLimit)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxLimit, limit)
+
+	for _, requested := range []int{-1, MaxLimit + 1} {
+		_, err := Limit(requested)
+		assert.Error(t, err)
+	}
+}
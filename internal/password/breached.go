@@ -0,0 +1,88 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prefixLength is how much of a hash is used to look up a range, as in the Have I Been Pwned range api
+const prefixLength = 5
+
+type BreachedChecker interface {
+	IsBreached(ctx context.Context, password string) (bool, error)
+}
+
+// RangeSource answers the k-anonymity way: given the first five hex characters of
+// the SHA-1 of a password it returns the rest of every breached hash with that prefix,
+// so the source never learns which password is being checked.
+type RangeSource interface {
+	Range(ctx context.Context, prefix string) ([]string, error)
+}
+
+// rangeChecker checks passwords against a RangeSource
+type rangeChecker struct {
+	source RangeSource
+}
+
+func NewRangeChecker(source RangeSource) BreachedChecker {
+	return &rangeChecker{source: source}
+}
+
+func (c *rangeChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := c.source.Range(ctx, hash[:prefixLength])
+	if err != nil {
+		return false, fmt.Errorf("password: error looking up breached range %v", err)
+	}
+	for _, suffix := range suffixes {
+		if suffix == hash[prefixLength:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FileRanges is a RangeSource kept in memory, grouped by prefix
+type FileRanges map[string][]string
+
+func (r FileRanges) Range(ctx context.Context, prefix string) ([]string, error) {
+	return r[prefix], nil
+}
+
+// LoadBreachedFile reads a list of SHA-1 hashes, one per line, in the format of the
+// Have I Been Pwned download ("HASH:count", the count is optional and ignored).
+// The whole list is held in memory so it is meant for a cut down list such as the most common passwords.
+func LoadBreachedFile(path string) (BreachedChecker, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ranges := FileRanges{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" || strings.HasPrefix(hash, "#") {
+			continue
+		}
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("password: %s line %d is not a SHA-1 hash", path, line)
+		}
+
+		hash = strings.ToUpper(hash)
+		ranges[hash[:prefixLength]] = append(ranges[hash[:prefixLength]], hash[prefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewRangeChecker(ranges), nil
+}
@@ -0,0 +1,195 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownHash = errors.New("password: hash was not made by a known algorithm")
+
+// Hasher turns passwords into hashes that carry the algorithm and its parameters,
+// so a hash can be checked after the parameters in use have changed
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded. It is an error when the hasher does not own encoded.
+	Verify(password string, encoded string) (bool, error)
+	// Owns reports whether encoded was made by this algorithm
+	Owns(encoded string) bool
+	// NeedsRehash reports whether encoded was made with weaker parameters than Hash uses now
+	NeedsRehash(encoded string) bool
+}
+
+// DefaultHasher hashes with argon2id and still accepts the bcrypt hashes of older accounts
+func DefaultHasher() Hasher {
+	return NewUpgradingHasher(DefaultArgon2id(), Bcrypt{Cost: 12})
+}
+
+// Argon2id hashes into the PHC string format, $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+type Argon2id struct {
+	// Memory is in KiB
+	Memory     uint32
+	Time       uint32
+	Threads    uint8
+	SaltLength int
+	KeyLength  uint32
+}
+
+// DefaultArgon2id uses the parameters recommended by OWASP, 19 MiB of memory and two passes
+func DefaultArgon2id() Argon2id {
+	return Argon2id{
+		Memory:     19 * 1024,
+		Time:       2,
+		Threads:    1,
+		SaltLength: 16,
+		KeyLength:  32,
+	}
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(password string, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	// the parameters of the hash are used, not the current ones
+	derived := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(derived, key) == 1, nil
+}
+
+func (a Argon2id) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory < a.Memory || params.Time < a.Time || params.Threads < a.Threads ||
+		len(salt) < a.SaltLength || uint32(len(key)) < a.KeyLength
+}
+
+func decodeArgon2id(encoded string) (params Argon2id, salt []byte, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("password: unsupported argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("password: invalid argon2id parameters %q", parts[3])
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, fmt.Errorf("password: invalid argon2id salt %v", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, fmt.Errorf("password: invalid argon2id key %v", err)
+	}
+	return params, salt, key, nil
+}
+
+// Bcrypt is the algorithm the first accounts were created with.
+// It ignores everything after the first 72 bytes of a password.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (b Bcrypt) Verify(password string, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b Bcrypt) Owns(encoded string) bool {
+	// $2a$, $2b$ and $2y$ are all bcrypt
+	return len(encoded) > 4 && strings.HasPrefix(encoded, "$2") && encoded[3] == '$'
+}
+
+func (b Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < b.Cost
+}
+
+// upgradingHasher hashes with current and verifies with whichever hasher made the hash.
+// Hashes of any other hasher need a rehash, which moves accounts over as they log in.
+type upgradingHasher struct {
+	current Hasher
+	legacy  []Hasher
+}
+
+func NewUpgradingHasher(current Hasher, legacy ...Hasher) Hasher {
+	return &upgradingHasher{current: current, legacy: legacy}
+}
+
+func (u *upgradingHasher) Hash(password string) (string, error) {
+	return u.current.Hash(password)
+}
+
+func (u *upgradingHasher) Verify(password string, encoded string) (bool, error) {
+	owner := u.owner(encoded)
+	if owner == nil {
+		return false, ErrUnknownHash
+	}
+	return owner.Verify(password, encoded)
+}
+
+func (u *upgradingHasher) Owns(encoded string) bool {
+	return u.owner(encoded) != nil
+}
+
+func (u *upgradingHasher) NeedsRehash(encoded string) bool {
+	if !u.current.Owns(encoded) {
+		return true
+	}
+	return u.current.NeedsRehash(encoded)
+}
+
+func (u *upgradingHasher) owner(encoded string) Hasher {
+	if u.current.Owns(encoded) {
+		return u.current
+	}
+	for _, hasher := range u.legacy {
+		if hasher.Owns(encoded) {
+			return hasher
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package password
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeBreachedFile writes the hashes of passwords in the Have I Been Pwned download format
+func writeBreachedFile(t *testing.T, passwords ...string) string {
+	var lines []string
+	for i, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":"+strings.Repeat("9", i+1))
+	}
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# top passwords\n"+strings.Join(lines, "\n")+"\n"), 0o600))
+	return path
+}
+
+func TestPolicy_Check(t *testing.T) {
+	breached, err := LoadBreachedFile(writeBreachedFile(t, "password", "correct horse battery staple"))
+	require.NoError(t, err)
+
+	policy := DefaultPolicy()
+	policy.Breached = breached
+
+	strict := policy
+	strict.MinClasses = 3
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		problems []string
+	}{
+		{"long enough", policy, "hunter2hunter2", nil},
+		{"empty", policy, "", []string{"must be at least 8 characters"}},
+		{"characters not bytes", policy, "ééééééé", []string{"must be at least 8 characters"}},
+		{"truncated by bcrypt", policy, strings.Repeat("a", 73), []string{"must be at most 72 bytes"}},
+		{"breached", policy, "correct horse battery staple", []string{"has appeared in a data breach, please choose another"}},
+		{"too few classes", strict, "hunter2hunter2", []string{"must mix at least 3 of lower case letters, upper case letters, digits and symbols"}},
+		{"enough classes", strict, "Hunter2hunter2", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := tt.policy.Check(context.Background(), tt.password)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.problems, problems)
+		})
+	}
+}
+
+func TestLoadBreachedFile_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("password\n"), 0o600))
+
+	_, err := LoadBreachedFile(path)
+	assert.ErrorContains(t, err, "line 1 is not a SHA-1 hash")
+
+	_, err = LoadBreachedFile(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+// rangeRecorder remembers what was asked for
+type rangeRecorder struct {
+	prefixes []string
+}
+
+func (r *rangeRecorder) Range(ctx context.Context, prefix string) ([]string, error) {
+	r.prefixes = append(r.prefixes, prefix)
+	return nil, nil
+}
+
+func TestRangeChecker_OnlySharesPrefix(t *testing.T) {
+	source := &rangeRecorder{}
+
+	breached, err := NewRangeChecker(source).IsBreached(context.Background(), "password")
+	assert.NoError(t, err)
+	assert.False(t, breached)
+
+	// the sha1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	assert.Equal(t, []string{"5BAA6"}, source.prefixes)
+}
+
+func TestArgon2id_EncodesParameters(t *testing.T) {
+	hasher := DefaultArgon2id()
+
+	encoded, err := hasher.Hash("correct horse")
+	require.NoError(t, err)
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=19456,t=2,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`, encoded)
+
+	match, err := hasher.Verify("correct horse", encoded)
+	assert.NoError(t, err)
+	assert.True(t, match)
+
+	match, err = hasher.Verify("wrong horse", encoded)
+	assert.NoError(t, err)
+	assert.False(t, match)
+
+	// the salt is random
+	again, _ := hasher.Hash("correct horse")
+	assert.NotEqual(t, encoded, again)
+
+	assert.False(t, hasher.NeedsRehash(encoded))
+
+	// hashes keep verifying after the parameters were raised, but get replaced
+	stronger := hasher
+	stronger.Time = 3
+	match, err = stronger.Verify("correct horse", encoded)
+	assert.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, stronger.NeedsRehash(encoded))
+}
+
+func TestDefaultHasher_UpgradesBcrypt(t *testing.T) {
+	hasher := DefaultHasher()
+
+	legacy, err := Bcrypt{Cost: 4}.Hash("correct horse")
+	require.NoError(t, err)
+
+	match, err := hasher.Verify("correct horse", legacy)
+	assert.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, hasher.NeedsRehash(legacy))
+
+	match, err = hasher.Verify("wrong horse", legacy)
+	assert.NoError(t, err)
+	assert.False(t, match)
+
+	current, err := hasher.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(current, "$argon2id$"))
+	assert.False(t, hasher.NeedsRehash(current))
+
+	_, err = hasher.Verify("correct horse", "plaintext")
+	assert.ErrorIs(t, err, ErrUnknownHash)
+}
@@ -0,0 +1,84 @@
+package password
+
+import (
+	"context"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BcryptMaxBytes is the longest password bcrypt looks at, anything after it is ignored
+const BcryptMaxBytes = 72
+
+// Policy decides which passwords users may choose
+type Policy struct {
+	// MinLength is counted in characters, not bytes
+	MinLength int
+	// MaxBytes rejects passwords the hash would truncate, zero allows any length
+	MaxBytes int
+	// MinClasses is how many of lower case, upper case, digits and symbols have to appear
+	MinClasses int
+	// Breached rejects passwords known from breaches, nil skips the check
+	Breached BreachedChecker
+}
+
+// DefaultPolicy follows NIST SP 800-63B: a minimum length and a breach check rather than composition rules
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength: 8,
+		MaxBytes:  BcryptMaxBytes,
+	}
+}
+
+// Check returns every rule password breaks, none means it is allowed.
+// The error is only set when the breach check could not be done.
+func (p Policy) Check(ctx context.Context, password string) ([]string, error) {
+	var problems []string
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes", p.MaxBytes))
+	}
+	if classes := countClasses(password); classes < p.MinClasses {
+		problems = append(problems, fmt.Sprintf("must mix at least %d of lower case letters, upper case letters, digits and symbols", p.MinClasses))
+	}
+
+	// a password that already breaks a rule is not worth looking up
+	if len(problems) == 0 && p.Breached != nil {
+		breached, err := p.Breached.IsBreached(ctx, password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			problems = append(problems, "has appeared in a data breach, please choose another")
+		}
+	}
+
+	return problems, nil
+}
+
+func countClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,75 @@
+// Package presence fans out the presence changes of users to the clients listening for them.
+package presence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/models"
+)
+
+// EventStatusChanged is published whenever the status a user shows changes,
+// through PUT /users/status, signing in or out, or the status expiring
+const EventStatusChanged = "user_status_changed"
+
+// subscriberBuffer is how far a subscriber may fall behind before it is dropped
+const subscriberBuffer = 64
+
+type Event struct {
+	Type     string                `json:"type"`
+	UserID   string                `json:"user_id"`
+	Presence models.PresenceStatus `json:"presence"`
+	At       time.Time             `json:"at"`
+}
+
+// StatusChanged is the event for the status user shows now
+func StatusChanged(user *models.User) Event {
+	now := time.Now().UTC()
+	return Event{Type: EventStatusChanged, UserID: user.ID.Hex(), Presence: user.CurrentStatus(now), At: now}
+}
+
+// Broker hands every published event to every subscriber. It only knows the subscribers
+// of this instance, running several needs a shared bus in front of it.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns the events published from now on and a function that ends the subscription.
+// A subscriber that falls too far behind has its channel closed rather than holding up
+// the others, it has to subscribe again and reload what it missed.
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	events := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	b.subscribers[events] = struct{}{}
+	b.mu.Unlock()
+
+	return events, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[events]; ok {
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// Publish never blocks
+func (b *Broker) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
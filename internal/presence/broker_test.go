@@ -0,0 +1,44 @@
+package presence
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroker_PublishesToEverySubscriber(t *testing.T) {
+	broker := NewBroker()
+	first, unsubscribeFirst := broker.Subscribe()
+	second, unsubscribeSecond := broker.Subscribe()
+	defer unsubscribeSecond()
+
+	event := Event{Type: EventStatusChanged, UserID: "user-1"}
+	broker.Publish(event)
+	assert.Equal(t, event, <-first)
+	assert.Equal(t, event, <-second)
+
+	// nothing reaches a subscription that ended, ending it twice is fine
+	unsubscribeFirst()
+	unsubscribeFirst()
+	broker.Publish(event)
+	_, open := <-first
+	assert.False(t, open)
+	assert.Equal(t, event, <-second)
+}
+
+func TestBroker_DropsSlowSubscribers(t *testing.T) {
+	broker := NewBroker()
+	slow, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		broker.Publish(Event{Type: EventStatusChanged})
+	}
+
+	// the buffered events are still delivered, then the channel is closed
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+}
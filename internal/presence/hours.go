@@ -0,0 +1,81 @@
+package presence
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// WorkingHours are the working_hours of a workspace, see docs/SCHEMA.md.
+// Hours that end before they start run past midnight, the day is the one they start on.
+type WorkingHours struct {
+	location *time.Location
+	// start and end are minutes after midnight
+	start int
+	end   int
+	days  map[time.Weekday]bool
+}
+
+// ParseWorkingHours reads working hours such as "America/New_York", "09:00", "17:00", "monday"
+func ParseWorkingHours(timezone, start, end string, days []string) (*WorkingHours, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" || timezone == "Local" {
+		return nil, fmt.Errorf("working hours: %q is not an IANA time zone", timezone)
+	}
+
+	hours := &WorkingHours{location: location, days: make(map[time.Weekday]bool)}
+	if hours.start, err = parseClock(start); err != nil {
+		return nil, err
+	}
+	if hours.end, err = parseClock(end); err != nil {
+		return nil, err
+	}
+	if hours.start == hours.end {
+		return nil, fmt.Errorf("working hours: start and end are both %s", start)
+	}
+
+	for _, day := range days {
+		weekday, ok := weekdays[strings.ToLower(strings.TrimSpace(day))]
+		if !ok {
+			return nil, fmt.Errorf("working hours: %q is not a day of the week", day)
+		}
+		hours.days[weekday] = true
+	}
+	if len(hours.days) == 0 {
+		return nil, fmt.Errorf("working hours: no working days")
+	}
+	return hours, nil
+}
+
+// Contains reports whether t is within the working hours
+func (h *WorkingHours) Contains(t time.Time) bool {
+	local := t.In(h.location)
+	minute := local.Hour()*60 + local.Minute()
+
+	if h.start < h.end {
+		return h.days[local.Weekday()] && minute >= h.start && minute < h.end
+	}
+	// past midnight the hours belong to the day before
+	if minute >= h.start {
+		return h.days[local.Weekday()]
+	}
+	return minute < h.end && h.days[local.AddDate(0, 0, -1).Weekday()]
+}
+
+func parseClock(clock string) (int, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("working hours: %q is not a time such as 09:00", clock)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
@@ -0,0 +1,61 @@
+package presence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkingHours_Contains(t *testing.T) {
+	weekdays := []string{"monday", "tuesday", "wednesday", "thursday", "friday"}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	office, err := ParseWorkingHours("Europe/Berlin", "09:00", "17:30", weekdays)
+	assert.NoError(t, err)
+	// 22:00 to 06:00, the night from Friday to Saturday is the last one of the week
+	nights, err := ParseWorkingHours("Europe/Berlin", "22:00", "06:00", weekdays)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		hours *WorkingHours
+		at    time.Time
+		want  bool
+	}{
+		{"start", office, time.Date(2025, 1, 16, 9, 0, 0, 0, berlin), true},
+		{"before start", office, time.Date(2025, 1, 16, 8, 59, 0, 0, berlin), false},
+		{"end", office, time.Date(2025, 1, 16, 17, 30, 0, 0, berlin), false},
+		{"other time zone", office, time.Date(2025, 1, 16, 16, 0, 0, 0, time.UTC), true},
+		{"weekend", office, time.Date(2025, 1, 18, 12, 0, 0, 0, berlin), false},
+		{"night", nights, time.Date(2025, 1, 16, 23, 0, 0, 0, berlin), true},
+		{"early morning", nights, time.Date(2025, 1, 17, 5, 0, 0, 0, berlin), true},
+		{"day", nights, time.Date(2025, 1, 17, 12, 0, 0, 0, berlin), false},
+		{"saturday morning", nights, time.Date(2025, 1, 18, 5, 0, 0, 0, berlin), true},
+		{"sunday night", nights, time.Date(2025, 1, 19, 23, 0, 0, 0, berlin), false},
+		{"monday morning", nights, time.Date(2025, 1, 20, 5, 0, 0, 0, berlin), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.hours.Contains(tt.at))
+		})
+	}
+}
+
+func TestParseWorkingHours_Invalid(t *testing.T) {
+	weekdays := []string{"monday"}
+	for name, parse := range map[string]func() (*WorkingHours, error){
+		"time zone": func() (*WorkingHours, error) {
+			return ParseWorkingHours("Mars/Olympus_Mons", "09:00", "17:00", weekdays)
+		},
+		"local":   func() (*WorkingHours, error) { return ParseWorkingHours("Local", "09:00", "17:00", weekdays) },
+		"start":   func() (*WorkingHours, error) { return ParseWorkingHours("UTC", "9am", "17:00", weekdays) },
+		"end":     func() (*WorkingHours, error) { return ParseWorkingHours("UTC", "09:00", "25:00", weekdays) },
+		"empty":   func() (*WorkingHours, error) { return ParseWorkingHours("UTC", "09:00", "09:00", weekdays) },
+		"day":     func() (*WorkingHours, error) { return ParseWorkingHours("UTC", "09:00", "17:00", []string{"someday"}) },
+		"no days": func() (*WorkingHours, error) { return ParseWorkingHours("UTC", "09:00", "17:00", nil) },
+	} {
+		_, err := parse()
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,96 @@
+package presence
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/models"
+)
+
+// Facts are what the rules decide on
+type Facts struct {
+	UserID string
+	// LastActiveAt is the last heartbeat or sign in, zero if there was none
+	LastActiveAt time.Time
+	Now          time.Time
+}
+
+// Outcome is the status a rule decided on, the zero Outcome is online
+type Outcome struct {
+	Status  string
+	Message string
+}
+
+// Rule decides the status of a user from the facts, ok is false when it does not apply
+type Rule interface {
+	Evaluate(ctx context.Context, facts Facts) (outcome Outcome, ok bool, err error)
+}
+
+type RuleFunc func(ctx context.Context, facts Facts) (Outcome, bool, error)
+
+func (f RuleFunc) Evaluate(ctx context.Context, facts Facts) (Outcome, bool, error) {
+	return f(ctx, facts)
+}
+
+// Engine evaluates the rules in order, the first one that applies decides
+type Engine struct {
+	rules []Rule
+}
+
+// NewEngine returns an engine for the rules, without any every user is online
+func NewEngine(rules ...Rule) *Engine {
+	return &Engine{rules: rules}
+}
+
+// Evaluate returns the outcome of the first rule that applies. A rule that fails is
+// skipped, a calendar that is down should not take the idle detection with it.
+func (e *Engine) Evaluate(ctx context.Context, facts Facts) Outcome {
+	for _, rule := range e.rules {
+		outcome, ok, err := rule.Evaluate(ctx, facts)
+		if err != nil {
+			log.Printf("Error: presence rule failed for user %s: %v", facts.UserID, err)
+			continue
+		}
+		if ok {
+			return outcome
+		}
+	}
+	return Outcome{}
+}
+
+// Idle makes users away once they were inactive for after
+func Idle(after time.Duration) Rule {
+	return RuleFunc(func(ctx context.Context, facts Facts) (Outcome, bool, error) {
+		if facts.Now.Sub(facts.LastActiveAt) < after {
+			return Outcome{}, false, nil
+		}
+		return Outcome{Status: models.StatusAway}, true, nil
+	})
+}
+
+// OffHours shows users as off hours outside of the working hours
+func OffHours(hours *WorkingHours) Rule {
+	return RuleFunc(func(ctx context.Context, facts Facts) (Outcome, bool, error) {
+		if hours.Contains(facts.Now) {
+			return Outcome{}, false, nil
+		}
+		return Outcome{Status: models.StatusOffHours}, true, nil
+	})
+}
+
+// CalendarSource tells whether a user is in a meeting, calendar integrations provide it
+type CalendarSource interface {
+	InMeeting(ctx context.Context, userID string, at time.Time) (bool, error)
+}
+
+// Busy shows users as busy while their calendar has a meeting
+func Busy(calendar CalendarSource) Rule {
+	return RuleFunc(func(ctx context.Context, facts Facts) (Outcome, bool, error) {
+		inMeeting, err := calendar.InMeeting(ctx, facts.UserID, facts.Now)
+		if err != nil || !inMeeting {
+			return Outcome{}, false, err
+		}
+		return Outcome{Status: models.StatusBusy, Message: "In a meeting"}, true, nil
+	})
+}
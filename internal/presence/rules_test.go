@@ -0,0 +1,56 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type calendarFunc func(ctx context.Context, userID string, at time.Time) (bool, error)
+
+func (f calendarFunc) InMeeting(ctx context.Context, userID string, at time.Time) (bool, error) {
+	return f(ctx, userID, at)
+}
+
+func TestEngine_FirstRuleThatAppliesDecides(t *testing.T) {
+	// Thursday afternoon in New York
+	now := time.Date(2025, 1, 16, 19, 0, 0, 0, time.UTC)
+	hours, err := ParseWorkingHours("America/New_York", "09:00", "17:00", []string{"monday", "tuesday", "wednesday", "thursday", "friday"})
+	assert.NoError(t, err)
+
+	meetings := map[string]bool{"in-meeting": true}
+	calendar := calendarFunc(func(ctx context.Context, userID string, at time.Time) (bool, error) {
+		if userID == "calendar-down" {
+			return false, errors.New("calendar unavailable")
+		}
+		return meetings[userID], nil
+	})
+	engine := NewEngine(Busy(calendar), OffHours(hours), Idle(10*time.Minute))
+
+	tests := []struct {
+		name  string
+		facts Facts
+		want  Outcome
+	}{
+		{"active", Facts{UserID: "active", LastActiveAt: now.Add(-time.Minute), Now: now}, Outcome{}},
+		{"idle", Facts{UserID: "idle", LastActiveAt: now.Add(-time.Hour), Now: now}, Outcome{Status: models.StatusAway}},
+		{"never active", Facts{UserID: "new", Now: now}, Outcome{Status: models.StatusAway}},
+		{"in a meeting", Facts{UserID: "in-meeting", LastActiveAt: now.Add(-time.Hour), Now: now}, Outcome{Status: models.StatusBusy, Message: "In a meeting"}},
+		{"after hours", Facts{UserID: "active", LastActiveAt: now, Now: now.Add(6 * time.Hour)}, Outcome{Status: models.StatusOffHours}},
+		{"meeting after hours", Facts{UserID: "in-meeting", Now: now.Add(6 * time.Hour)}, Outcome{Status: models.StatusBusy, Message: "In a meeting"}},
+		// a failing rule is skipped rather than failing the others
+		{"calendar down", Facts{UserID: "calendar-down", LastActiveAt: now.Add(-time.Hour), Now: now}, Outcome{Status: models.StatusAway}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, engine.Evaluate(context.Background(), tt.facts))
+		})
+	}
+
+	// without rules everybody is online
+	assert.Equal(t, Outcome{}, NewEngine().Evaluate(context.Background(), Facts{Now: now}))
+}
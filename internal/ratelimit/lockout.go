@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Backoff describes a lockout: a key is locked for Base once it reaches Threshold failures,
+// every further failure locks it again for twice as long as before, up to Max.
+// Failures are forgotten Decay after the last one.
+type Backoff struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Decay     time.Duration
+}
+
+// lockFor is how long a key with the failures is locked, zero below the threshold
+func (b Backoff) lockFor(failures int) time.Duration {
+	if failures < b.Threshold {
+		return 0
+	}
+
+	lock := b.Base
+	for i := b.Threshold; i < failures && lock < b.Max; i++ {
+		lock *= 2
+	}
+	return min(lock, b.Max)
+}
+
+// LockoutStore keeps the failures of keys for a Lockout
+type LockoutStore interface {
+	// LockedFor returns how long the key stays locked, zero if it is not
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Fail records a failure and returns how long the key is locked for now, zero if it is not
+	Fail(ctx context.Context, key string, backoff Backoff) (time.Duration, error)
+	// Reset forgets the failures of a key
+	Reset(ctx context.Context, key string) error
+}
+
+// Lockout locks a key (an account) after repeated failures, the way Backoff describes
+type Lockout struct {
+	store   LockoutStore
+	backoff Backoff
+}
+
+func NewLockout(store LockoutStore, threshold int, base time.Duration, max time.Duration) *Lockout {
+	return &Lockout{
+		store:   store,
+		backoff: Backoff{Threshold: threshold, Base: base, Max: max, Decay: 24 * time.Hour},
+	}
+}
+
+// LockedFor returns how long the key stays locked, zero if it is not
+func (l *Lockout) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	return l.store.LockedFor(ctx, key)
+}
+
+// Fail records a failure and returns how long the key is locked for now, zero if it is not
+func (l *Lockout) Fail(ctx context.Context, key string) (time.Duration, error) {
+	return l.store.Fail(ctx, key, l.backoff)
+}
+
+// Reset forgets the failures of a key, called after a successful login
+func (l *Lockout) Reset(ctx context.Context, key string) error {
+	return l.store.Reset(ctx, key)
+}
+
+type lockoutEntry struct {
+	failures    int
+	lockedUntil time.Time
+	expiresAt   time.Time
+}
+
+// MemoryLockoutStore keeps failures in process memory.
+// Lockouts are per instance, use RedisLockoutStore when running more than one.
+type MemoryLockoutStore struct {
+	mu      sync.Mutex
+	entries map[string]*lockoutEntry
+	now     func() time.Time
+	calls   int
+}
+
+func NewMemoryLockoutStore() *MemoryLockoutStore {
+	return &MemoryLockoutStore{
+		entries: make(map[string]*lockoutEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryLockoutStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return 0, nil
+	}
+
+	now := s.now()
+	if now.After(entry.expiresAt) {
+		delete(s.entries, key)
+		return 0, nil
+	}
+	if now.Before(entry.lockedUntil) {
+		return entry.lockedUntil.Sub(now), nil
+	}
+	return 0, nil
+}
+
+func (s *MemoryLockoutStore) Fail(ctx context.Context, key string, backoff Backoff) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		entry = &lockoutEntry{}
+		s.entries[key] = entry
+	}
+
+	entry.failures++
+	entry.expiresAt = now.Add(backoff.Decay)
+
+	lock := backoff.lockFor(entry.failures)
+	if lock > 0 {
+		entry.lockedUntil = now.Add(lock)
+	}
+	return lock, nil
+}
+
+func (s *MemoryLockoutStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep drops the failures that were forgotten every so often, the keys come from clients
+// and most of them are never checked again
+func (s *MemoryLockoutStore) sweep(now time.Time) {
+	s.calls++
+	if s.calls%1000 != 0 {
+		return
+	}
+
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore keeps buckets in process memory.
+// Limits are per instance, use RedisStore when running more than one.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+	calls   int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now, limit)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+
+	// refill for the time that passed since the last request
+	elapsed := now.Sub(b.last)
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed.Seconds()/limit.interval().Seconds())
+	b.last = now
+
+	if b.tokens < 1 {
+		missing := 1 - b.tokens
+		retryAfter := time.Duration(missing * float64(limit.interval()))
+		return Result{Allowed: false, RetryAfter: retryAfter}, nil
+	}
+
+	b.tokens--
+	return Result{Allowed: true, Remaining: int(b.tokens)}, nil
+}
+
+// sweep drops buckets that have refilled completely every so often so the map does not grow forever
+func (s *MemoryStore) sweep(now time.Time, limit Limit) {
+	s.calls++
+	if s.calls%1000 != 0 {
+		return
+	}
+
+	for key, b := range s.buckets {
+		if now.Sub(b.last) > limit.Per*time.Duration(limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/apperr"
+)
+
+// KeyFunc picks the bucket a request is counted against
+type KeyFunc func(c *gin.Context) string
+
+// KeyByIP counts requests per client ip, name keeps the buckets of different routes apart
+func KeyByIP(name string) KeyFunc {
+	return func(c *gin.Context) string {
+		return name + ":ip:" + c.ClientIP()
+	}
+}
+
+// Middleware rejects requests over the limit with 429 and a Retry-After header.
+// If the store fails the request is let through, a broken limiter should not take auth down with it.
+func Middleware(store Store, limit Limit, key KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := store.Allow(c, key(c), limit)
+		if err != nil {
+			log.Printf("Warning: rate limiter failed, allowing request: %v", err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Rate))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+
+		if !result.Allowed {
+			AbortTooManyRequests(c, result.RetryAfter)
+			return
+		}
+		c.Next()
+	}
+}
+
+// AbortTooManyRequests stops the request with the RATE_LIMIT_EXCEEDED error
+func AbortTooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	c.Error(apperr.TooManyRequests("Too many requests, please try again later", retryAfter))
+	c.Abort()
+}
@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit describes a token bucket: Burst tokens at most, refilled at Rate tokens every Per.
+type Limit struct {
+	Rate  int
+	Per   time.Duration
+	Burst int
+}
+
+// PerMinute allows n requests a minute, all of which may arrive at once
+func PerMinute(n int) Limit {
+	return Limit{Rate: n, Per: time.Minute, Burst: n}
+}
+
+// interval is the time it takes to refill a single token
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Rate)
+}
+
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next token is available, zero when allowed
+	RetryAfter time.Duration
+}
+
+// Store takes one token from the bucket identified by key
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore_RefillsOverTime(t *testing.T) {
+	now := time.Date(2025, 1, 16, 14, 30, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	limit := PerMinute(3)
+
+	for i := 0; i < 3; i++ {
+		result, err := store.Allow(context.Background(), "key", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+
+	result, _ := store.Allow(context.Background(), "key", limit)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 20*time.Second, result.RetryAfter)
+
+	// other keys have their own bucket
+	result, _ = store.Allow(context.Background(), "other", limit)
+	assert.True(t, result.Allowed)
+
+	// one token comes back every 20 seconds
+	now = now.Add(20 * time.Second)
+	result, _ = store.Allow(context.Background(), "key", limit)
+	assert.True(t, result.Allowed)
+
+	result, _ = store.Allow(context.Background(), "key", limit)
+	assert.False(t, result.Allowed)
+}
+
+func TestMiddleware_TooManyRequests(t *testing.T) {
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/auth/login", Middleware(NewMemoryStore(), PerMinute(1), KeyByIP("login")), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/login", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/auth/login", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	var res models.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, "RATE_LIMIT_EXCEEDED", res.Error.Code)
+}
+
+func TestLockout_Progressive(t *testing.T) {
+	now := time.Date(2025, 1, 16, 14, 30, 0, 0, time.UTC)
+	store := NewMemoryLockoutStore()
+	store.now = func() time.Time { return now }
+	lockout := NewLockout(store, 3, time.Minute, 5*time.Minute)
+	ctx := context.Background()
+
+	fail := func() time.Duration {
+		lock, err := lockout.Fail(ctx, "alice")
+		assert.NoError(t, err)
+		return lock
+	}
+	lockedFor := func() time.Duration {
+		lock, err := lockout.LockedFor(ctx, "alice")
+		assert.NoError(t, err)
+		return lock
+	}
+
+	assert.Equal(t, time.Duration(0), fail())
+	assert.Equal(t, time.Duration(0), fail())
+	assert.Equal(t, time.Minute, fail())
+	assert.Equal(t, time.Minute, lockedFor())
+
+	// every further failure doubles the lock, up to the maximum
+	assert.Equal(t, 2*time.Minute, fail())
+	assert.Equal(t, 4*time.Minute, fail())
+	assert.Equal(t, 5*time.Minute, fail())
+
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, time.Duration(0), lockedFor())
+
+	assert.NoError(t, lockout.Reset(ctx, "alice"))
+	assert.Equal(t, time.Duration(0), fail())
+}
+
+func TestMemoryLockoutStore_ForgetsOldFailures(t *testing.T) {
+	now := time.Date(2025, 1, 16, 14, 30, 0, 0, time.UTC)
+	store := NewMemoryLockoutStore()
+	store.now = func() time.Time { return now }
+	lockout := NewLockout(store, 3, time.Minute, 5*time.Minute)
+	ctx := context.Background()
+
+	// identifiers sprayed once are never checked again
+	for i := 0; i < 999; i++ {
+		lockout.Fail(ctx, fmt.Sprintf("login:user%d", i))
+	}
+	assert.Len(t, store.entries, 999)
+
+	now = now.Add(25 * time.Hour)
+	lockout.Fail(ctx, "login:alice")
+	assert.Len(t, store.entries, 1)
+}
+
+func TestRedisStore_ParsesReply(t *testing.T) {
+	client := RedisEvalFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+		assert.Equal(t, []string{"uriel:rl:login:ip:1.2.3.4"}, keys)
+		return []interface{}{int64(0), int64(0), int64(12000)}, nil
+	})
+
+	store := NewRedisStore(client, "uriel:rl:")
+	result, err := store.Allow(context.Background(), "login:ip:1.2.3.4", PerMinute(5))
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 12*time.Second, result.RetryAfter)
+
+	failing := RedisEvalFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+		return nil, errors.New("connection refused")
+	})
+	_, err = NewRedisStore(failing, "").Allow(context.Background(), "key", PerMinute(5))
+	assert.Error(t, err)
+}
+
+func TestRedisLockoutStore_ParsesReply(t *testing.T) {
+	client := RedisEvalFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+		assert.Equal(t, []string{"uriel:lockout:login:alice"}, keys)
+		return int64(120000), nil
+	})
+
+	lockout := NewLockout(NewRedisLockoutStore(client, "uriel:lockout:"), 3, time.Minute, time.Hour)
+	lock, err := lockout.Fail(context.Background(), "login:alice")
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, lock)
+
+	failing := RedisEvalFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+		return nil, errors.New("connection refused")
+	})
+	_, err = NewRedisLockoutStore(failing, "").LockedFor(context.Background(), "login:alice")
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RedisClient is the one call RedisStore needs.
+// With go-redis it is a one line adapter:
+//
+//	ratelimit.RedisEvalFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+//		return rdb.Eval(ctx, script, keys, args...).Result()
+//	})
+type RedisClient interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// RedisEvalFunc turns a function into a RedisClient
+type RedisEvalFunc func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+
+func (f RedisEvalFunc) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return f(ctx, script, keys, args...)
+}
+
+// tokenBucketScript is the same algorithm as MemoryStore, run atomically inside redis.
+// The bucket is stored as a hash and expires once it would be full again.
+// ARGV: burst, interval in ms, now in ms
+// returns: {allowed, remaining, retry after in ms}
+const tokenBucketScript = `
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil then
+	tokens = burst
+	last = now
+end
+
+tokens = math.min(burst, tokens + (now - last) / interval)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) * interval)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tokens, "last", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * interval))
+
+return {allowed, math.floor(tokens), retry}
+`
+
+// RedisStore shares buckets between every instance that uses the same redis
+type RedisStore struct {
+	client RedisClient
+	prefix string
+}
+
+func NewRedisStore(client RedisClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	intervalMs := limit.interval().Milliseconds()
+	if intervalMs < 1 {
+		intervalMs = 1
+	}
+
+	raw, err := s.client.Eval(ctx, tokenBucketScript, []string{s.prefix + key}, limit.Burst, intervalMs, time.Now().UnixMilli())
+	if err != nil {
+		return Result{}, fmt.Errorf("ratelimit: redis eval failed: %w", err)
+	}
+
+	values, ok := raw.([]interface{})
+	if !ok || len(values) != 3 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected redis reply %v", raw)
+	}
+
+	var ints [3]int64
+	for i, value := range values {
+		n, ok := value.(int64)
+		if !ok {
+			return Result{}, fmt.Errorf("ratelimit: unexpected redis reply %v", raw)
+		}
+		ints[i] = n
+	}
+
+	return Result{
+		Allowed:    ints[0] == 1,
+		Remaining:  int(ints[1]),
+		RetryAfter: time.Duration(ints[2]) * time.Millisecond,
+	}, nil
+}
+
+// lockoutFailScript is the same algorithm as MemoryLockoutStore, run atomically inside redis.
+// The failures are stored as a hash that expires decay after the last one.
+// ARGV: threshold, base in ms, max in ms, decay in ms, now in ms
+// returns: the lock in ms, 0 when the key is not locked
+const lockoutFailScript = `
+local threshold = tonumber(ARGV[1])
+local base = tonumber(ARGV[2])
+local max = tonumber(ARGV[3])
+local decay = tonumber(ARGV[4])
+local now = tonumber(ARGV[5])
+
+local failures = redis.call("HINCRBY", KEYS[1], "failures", 1)
+redis.call("PEXPIRE", KEYS[1], decay)
+
+if failures < threshold then
+	return 0
+end
+
+local lock = base
+for i = threshold + 1, failures do
+	if lock >= max then
+		break
+	end
+	lock = lock * 2
+end
+lock = math.min(lock, max)
+
+redis.call("HSET", KEYS[1], "locked_until", now + lock)
+return lock
+`
+
+// lockoutLockedForScript returns how many ms the key stays locked
+// ARGV: now in ms
+const lockoutLockedForScript = `
+local lockedUntil = tonumber(redis.call("HGET", KEYS[1], "locked_until") or "0")
+return math.max(0, lockedUntil - tonumber(ARGV[1]))
+`
+
+const lockoutResetScript = `return redis.call("DEL", KEYS[1])`
+
+// RedisLockoutStore shares lockouts between every instance that uses the same redis
+type RedisLockoutStore struct {
+	client RedisClient
+	prefix string
+}
+
+func NewRedisLockoutStore(client RedisClient, prefix string) *RedisLockoutStore {
+	return &RedisLockoutStore{client: client, prefix: prefix}
+}
+
+func (s *RedisLockoutStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	return s.evalDuration(ctx, lockoutLockedForScript, key, time.Now().UnixMilli())
+}
+
+func (s *RedisLockoutStore) Fail(ctx context.Context, key string, backoff Backoff) (time.Duration, error) {
+	return s.evalDuration(ctx, lockoutFailScript, key, backoff.Threshold, backoff.Base.Milliseconds(),
+		backoff.Max.Milliseconds(), backoff.Decay.Milliseconds(), time.Now().UnixMilli())
+}
+
+func (s *RedisLockoutStore) Reset(ctx context.Context, key string) error {
+	if _, err := s.client.Eval(ctx, lockoutResetScript, []string{s.prefix + key}); err != nil {
+		return fmt.Errorf("ratelimit: redis eval failed: %w", err)
+	}
+	return nil
+}
+
+// evalDuration runs a script that answers with a number of milliseconds
+func (s *RedisLockoutStore) evalDuration(ctx context.Context, script string, key string, args ...interface{}) (time.Duration, error) {
+	raw, err := s.client.Eval(ctx, script, []string{s.prefix + key}, args...)
+	if err != nil {
+		return 0, fmt.Errorf("ratelimit: redis eval failed: %w", err)
+	}
+	ms, ok := raw.(int64)
+	if !ok {
+		return 0, fmt.Errorf("ratelimit: unexpected redis reply %v", raw)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
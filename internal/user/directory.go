@@ -0,0 +1,134 @@
+package user
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/models"
+	"github.com/palSagnik/uriel/internal/pagination"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// directoryField is a field of the user directory and the paths it is loaded from,
+// admin fields are only in the view of those allowed to manage users
+type directoryField struct {
+	paths []string
+	admin bool
+}
+
+// the status shown depends on being signed in, the status chosen and when it expires
+var presencePaths = []string{
+	"is_online",
+	"presence.status",
+	"presence.status_message",
+	"presence.status_emoji",
+	"presence.auto_expire_at",
+}
+
+var directoryFields = map[string]directoryField{
+	"id":               {},
+	"username":         {paths: []string{"username"}},
+	"full_name":        {paths: []string{"full_name"}},
+	"avatar_url":       {paths: []string{"avatar_url"}},
+	"is_online":        {paths: presencePaths},
+	"presence":         {paths: presencePaths},
+	"email":            {paths: []string{"email"}, admin: true},
+	"role":             {paths: []string{"role"}, admin: true},
+	"verified":         {paths: []string{"verified"}, admin: true},
+	"verified_at":      {paths: []string{"verified_at"}, admin: true},
+	"mfa_enabled":      {paths: []string{"mfa.enabled"}, admin: true},
+	"timezone":         {paths: []string{"preferences.timezone"}, admin: true},
+	"linked_providers": {paths: []string{"identities.issuer"}, admin: true},
+	"created_at":       {paths: []string{"created_at"}, admin: true},
+	"updated_at":       {paths: []string{"updated_at"}, admin: true},
+}
+
+// selectFields returns the fields asked for in the comma separated list, all fields
+// of the view when it is empty. The id is always selected. ok is false when a field
+// is not in the view.
+func selectFields(list string, admin bool) (fields []string, unknown string, ok bool) {
+	if strings.TrimSpace(list) == "" {
+		for name, field := range directoryFields {
+			if admin || !field.admin {
+				fields = append(fields, name)
+			}
+		}
+	} else {
+		fields = []string{"id"}
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			field, exists := directoryFields[name]
+			if !exists || (field.admin && !admin) {
+				return nil, name, false
+			}
+			fields = append(fields, name)
+		}
+	}
+
+	slices.Sort(fields)
+	return slices.Compact(fields), "", true
+}
+
+// projectionPaths are the paths the fields are loaded from
+func projectionPaths(fields []string) []string {
+	var paths []string
+	for _, name := range fields {
+		paths = append(paths, directoryFields[name].paths...)
+	}
+	slices.Sort(paths)
+	return slices.Compact(paths)
+}
+
+// listedUser maps user to its view and keeps only the fields
+func listedUser(user *models.User, fields []string, admin bool) (map[string]any, error) {
+	var view any = user.Public()
+	if admin {
+		view = user.Admin()
+	}
+
+	b, err := json.Marshal(view)
+	if err != nil {
+		return nil, err
+	}
+	var listed map[string]any
+	if err := json.Unmarshal(b, &listed); err != nil {
+		return nil, err
+	}
+
+	for name := range listed {
+		if !slices.Contains(fields, name) {
+			delete(listed, name)
+		}
+	}
+	return listed, nil
+}
+
+// cursorAfter is the cursor of the page after user in the order sort
+func cursorAfter(user *models.User, sort string) pagination.Cursor {
+	cursor := pagination.Cursor{Sort: sort, ID: user.ID.Hex(), Value: user.Username}
+	if strings.TrimPrefix(sort, "-") == SortCreatedAt {
+		cursor.Value = user.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// resume sets where the query continues from the cursor
+func resume(query *UserQuery, cursor *pagination.Cursor) error {
+	id, err := primitive.ObjectIDFromHex(cursor.ID)
+	if err != nil {
+		return pagination.ErrInvalidCursor
+	}
+	query.AfterID = id
+	query.AfterKey = cursor.Value
+
+	if query.Sort == SortCreatedAt {
+		createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return pagination.ErrInvalidCursor
+		}
+		query.AfterKey = createdAt
+	}
+	return nil
+}
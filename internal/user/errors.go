@@ -0,0 +1,5 @@
+package user
+
+import "github.com/palSagnik/uriel/internal/apperr"
+
+var ErrUserNotFound = apperr.NotFound("user not found")
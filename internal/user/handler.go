@@ -2,10 +2,16 @@ package user
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/auth"
+	"github.com/palSagnik/uriel/internal/avatar"
+	"github.com/palSagnik/uriel/internal/config"
 	"github.com/palSagnik/uriel/internal/models"
 )
 
@@ -21,14 +27,14 @@ func (h *Handler) UpdateUserAvatar(c *gin.Context) {
 	// retrieve the userId
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please login"})
+		c.Error(apperr.Unauthorized("Please login"))
 		return
 	}
 
-	var req *models.UpdateUserAvatarRequest
+	var req models.UpdateUserAvatarRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(apperr.Invalid(err.Error()))
 		return
 	}
 
@@ -37,7 +43,7 @@ func (h *Handler) UpdateUserAvatar(c *gin.Context) {
 
 	msg, err := h.service.UpdateUserAvatar(ctx, userID.(string), req.AvatarId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		c.Error(apperr.Wrap(err, msg))
 		return
 	}
 
@@ -46,19 +52,24 @@ func (h *Handler) UpdateUserAvatar(c *gin.Context) {
 	})
 }
 
+// GetAllUsers lists a page of the users, those allowed to manage users see emails, roles and account state
 func (h *Handler) GetAllUsers(c *gin.Context) {
+	var req models.ListUsersRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(c, 10*time.Second)
 	defer cancel()
 
-	users, err := h.service.GetUsers(ctx)
+	users, err := h.service.ListUsers(ctx, &req, auth.Granted(c, auth.PermManageUsers))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.Error(apperr.Wrap(err, "failed to get users"))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"users": users,
-	})
+	c.JSON(http.StatusOK, users)
 }
 
 func (h *Handler) GetAllAvatars(c *gin.Context) {
@@ -67,7 +78,7 @@ func (h *Handler) GetAllAvatars(c *gin.Context) {
 
 	avatars, err := h.service.GetAvatars(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(apperr.Wrap(err, "failed to get avatars"))
 		return
 	}
 
@@ -75,3 +86,248 @@ func (h *Handler) GetAllAvatars(c *gin.Context) {
 		Avatars: avatars,
 	})
 }
+
+// CreateAvatar adds an avatar to the catalogue, admin only
+func (h *Handler) CreateAvatar(c *gin.Context) {
+	var req models.CreateAvatarRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	newAvatar, err := h.service.CreateAvatar(ctx, req.Name, req.AvatarUrl)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "failed to create avatar"))
+		return
+	}
+
+	c.JSON(http.StatusCreated, newAvatar)
+}
+
+// DeleteAvatar removes an avatar from the catalogue, admin only
+func (h *Handler) DeleteAvatar(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	if err := h.service.DeleteAvatar(ctx, c.Param("id")); err != nil {
+		c.Error(apperr.Wrap(err, "failed to delete avatar"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "deleted avatar succesfully",
+	})
+}
+
+// GetProfile returns the profile of the logged in user
+func (h *Handler) GetProfile(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(apperr.Unauthorized("Please login"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	profile, err := h.service.GetProfile(ctx, userID.(string))
+	if err != nil {
+		c.Error(apperr.Wrap(err, "failed to get profile"))
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateProfile changes the fields sent in the body and leaves the others alone
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(apperr.Unauthorized("Please login"))
+		return
+	}
+
+	var req models.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	profile, err := h.service.UpdateProfile(ctx, userID.(string), &req)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "failed to update profile"))
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UploadAvatar takes an image from the avatar field of a multipart form and makes it the avatar of the logged in user
+func (h *Handler) UploadAvatar(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(apperr.Unauthorized("Please login"))
+		return
+	}
+
+	// the form around the file gets some room on top of the limit for the file itself
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.MAX_AVATAR_UPLOAD_BYTES+64<<10)
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.Error(avatar.ErrImageTooLarge)
+			return
+		}
+		c.Error(apperr.Invalid("avatar file is required"))
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.Error(apperr.Wrap(err, "failed to read avatar"))
+		return
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(c, 30*time.Second)
+	defer cancel()
+
+	res, err := h.service.UploadAvatar(ctx, userID.(string), file)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "failed to upload avatar"))
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+// GetSettings returns the preferences of the logged in user
+func (h *Handler) GetSettings(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(apperr.Unauthorized("Please login"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	settings, err := h.service.GetSettings(ctx, userID.(string))
+	if err != nil {
+		c.Error(apperr.Wrap(err, "failed to get settings"))
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSettings changes the preferences sent in the body and leaves the others alone
+func (h *Handler) UpdateSettings(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(apperr.Unauthorized("Please login"))
+		return
+	}
+
+	var req models.UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	settings, err := h.service.UpdateSettings(ctx, userID.(string), &req)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "failed to update settings"))
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateStatus sets the status of the logged in user
+func (h *Handler) UpdateStatus(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(apperr.Unauthorized("Please login"))
+		return
+	}
+
+	var req models.UpdateStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperr.Invalid(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	status, err := h.service.UpdateStatus(ctx, userID.(string), &req)
+	if err != nil {
+		c.Error(apperr.Wrap(err, "failed to update status"))
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// Heartbeat tells that the logged in user is active, clients send it while the user is using them
+func (h *Handler) Heartbeat(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.Error(apperr.Unauthorized("Please login"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	status, err := h.service.Heartbeat(ctx, userID.(string))
+	if err != nil {
+		c.Error(apperr.Wrap(err, "failed to record activity"))
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// PresenceEvents streams the presence events as server-sent events until the client goes away.
+// Clients that fall too far behind are disconnected and have to reconnect.
+func (h *Handler) PresenceEvents(c *gin.Context) {
+	events, unsubscribe := h.service.SubscribePresence()
+	defer unsubscribe()
+
+	keepalive := time.NewTicker(config.PRESENCE_KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// nginx would hold the events back otherwise
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			c.SSEvent(event.Type, event)
+		case <-keepalive.C:
+			io.WriteString(c.Writer, ": keepalive\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
@@ -1,15 +1,33 @@
 package user
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"image"
+	"image/png"
+	"io/fs"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/auth"
+	"github.com/palSagnik/uriel/internal/avatar"
+	"github.com/palSagnik/uriel/internal/blob"
+	"github.com/palSagnik/uriel/internal/config"
 	"github.com/palSagnik/uriel/internal/models"
+	"github.com/palSagnik/uriel/internal/pagination"
+	"github.com/palSagnik/uriel/internal/presence"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -50,6 +68,7 @@ func TestGetAvatars_Success(t *testing.T) {
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.GET("/users/avatar", mockAuthMiddleware(), handler.GetAllAvatars)
 
 	w := httptest.NewRecorder()
@@ -76,6 +95,7 @@ func TestGetAvatars_InternalServerError(t *testing.T) {
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.GET("/users/avatar", mockAuthMiddleware(), handler.GetAllAvatars)
 
 	w := httptest.NewRecorder()
@@ -85,10 +105,11 @@ func TestGetAvatars_InternalServerError(t *testing.T) {
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 
-	var res map[string]string
+	var res models.ErrorResponse
 	_ = json.Unmarshal(w.Body.Bytes(), &res)
 
-	assert.Equal(t, "failed to get avatars", res["error"])
+	assert.Equal(t, "INTERNAL_ERROR", res.Error.Code)
+	assert.Equal(t, "failed to get avatars", res.Error.Message)
 	mockAvatarRepo.AssertExpectations(t)
 }
 
@@ -103,6 +124,7 @@ func TestUpdateUserAvatar_Success(t *testing.T) {
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.POST("/users/avatar", mockAuthMiddleware(), handler.UpdateUserAvatar)
 
 	payload := models.UpdateUserAvatarRequest{
@@ -137,6 +159,7 @@ func TestUpdateUserAvatar_Failure(t *testing.T) {
 	handler := NewHandler(service)
 
 	router := gin.New()
+	router.Use(apperr.Middleware())
 	router.POST("/users/avatar", mockAuthMiddleware(), handler.UpdateUserAvatar)
 
 	payload := models.UpdateUserAvatarRequest{
@@ -151,11 +174,827 @@ func TestUpdateUserAvatar_Failure(t *testing.T) {
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 
-	var res map[string]string
+	var res models.ErrorResponse
 	_ = json.Unmarshal(w.Body.Bytes(), &res)
 
-	assert.Equal(t, "failed to update avatar", res["error"])
+	assert.Equal(t, "INTERNAL_ERROR", res.Error.Code)
+	assert.Equal(t, "failed to update avatar", res.Error.Message)
+
+	mockAvatarRepo.AssertExpectations(t)
+	mockUserRepo.AssertExpectations(t)
+}
+func TestCreateAvatar_Success(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	mockAvatarRepo.On("CreateAvatar", mock.Anything, mock.AnythingOfType("models.Avatar")).Return(nil)
+
+	service := NewService(mockUserRepo, mockAvatarRepo)
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/users/avatar/catalogue", mockAuthMiddleware(), handler.CreateAvatar)
+
+	payload := models.CreateAvatarRequest{
+		Name:      "robot",
+		AvatarUrl: "https://uriel.com/avatars/robot.png",
+	}
+	jsonPayload, _ := json.Marshal(payload)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/users/avatar/catalogue", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
 
+	assert.Equal(t, http.StatusCreated, w.Code)
 	mockAvatarRepo.AssertExpectations(t)
+}
+
+func TestCreateAvatar_InvalidUrl(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	service := NewService(mockUserRepo, mockAvatarRepo)
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/users/avatar/catalogue", mockAuthMiddleware(), handler.CreateAvatar)
+
+	payload := models.CreateAvatarRequest{
+		Name:      "robot",
+		AvatarUrl: "javascript:alert(1)",
+	}
+	jsonPayload, _ := json.Marshal(payload)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/users/avatar/catalogue", bytes.NewBuffer(jsonPayload))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockAvatarRepo.AssertNotCalled(t, "CreateAvatar", mock.Anything, mock.Anything)
+}
+
+func TestDeleteAvatar_NotFound(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	mockAvatarRepo.On("DeleteAvatar", mock.Anything, "6592008029c8c3e4dc76256c").Return(avatar.ErrAvatarNotFound)
+
+	service := NewService(mockUserRepo, mockAvatarRepo)
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.DELETE("/users/avatar/catalogue/:id", mockAuthMiddleware(), handler.DeleteAvatar)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodDelete, "/users/avatar/catalogue/6592008029c8c3e4dc76256c", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockAvatarRepo.AssertExpectations(t)
+}
+
+func TestGetAllUsers_ViewDependsOnPermission(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	mockUsers := []models.User{
+		{
+			ID:       primitive.NewObjectID(),
+			Email:    "test@test.com",
+			Username: "test",
+			Password: "$2a$10$secrethash",
+			Role:     config.USER,
+			MFA:      models.MFASettings{Enabled: true, Secret: "TOTPSECRET"},
+		},
+	}
+	mockUserRepo.On("ListUsers", mock.Anything, mock.Anything).Return(mockUsers, nil)
+
+	service := NewService(mockUserRepo, mockAvatarRepo)
+	handler := NewHandler(service)
+
+	list := func(role string) map[string]any {
+		router := gin.New()
+		router.Use(apperr.Middleware())
+		router.GET("/users/user", func(c *gin.Context) {
+			c.Set("role", role)
+			c.Next()
+		}, handler.GetAllUsers)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/users/user", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "secrethash")
+		assert.NotContains(t, w.Body.String(), "TOTPSECRET")
+
+		var res struct {
+			Users []map[string]any `json:"users"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		assert.Len(t, res.Users, 1)
+		return res.Users[0]
+	}
+
+	// members only see the public profile
+	member := list(config.USER)
+	assert.Equal(t, "test", member["username"])
+	assert.NotContains(t, member, "email")
+	assert.NotContains(t, member, "role")
+
+	admin := list(config.ADMIN)
+	assert.Equal(t, "test@test.com", admin["email"])
+	assert.Equal(t, config.USER, admin["role"])
+	assert.Equal(t, true, admin["mfa_enabled"])
+
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestGetAllUsers_Pages(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	first := models.User{ID: primitive.NewObjectID(), Username: "alice", FullName: "Alice Liddell", IsOnline: true}
+	second := models.User{ID: primitive.NewObjectID(), Username: "bob", IsOnline: true}
+	online := true
+
+	// the first page asks for one user more than the limit to know there is a next one
+	mockUserRepo.On("ListUsers", mock.Anything, mock.MatchedBy(func(query UserQuery) bool {
+		return query.AfterKey == nil && query.Limit == 2 && query.Search == "li" && *query.Online == online &&
+			query.Sort == SortUsername && !query.Descending && slices.Equal(query.Fields, []string{"username"})
+	})).Return([]models.User{first, second}, nil)
+	mockUserRepo.On("ListUsers", mock.Anything, mock.MatchedBy(func(query UserQuery) bool {
+		return query.AfterKey == "alice" && query.AfterID == first.ID
+	})).Return([]models.User{second}, nil)
+
+	service := NewService(mockUserRepo, mockAvatarRepo)
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.GET("/users/user", func(c *gin.Context) {
+		c.Set("role", config.USER)
+		c.Next()
+	}, handler.GetAllUsers)
+
+	list := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/users/user?"+query, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := list("limit=1&q=li&online=true&fields=username")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var page struct {
+		Users      []map[string]any `json:"users"`
+		NextCursor *string          `json:"next_cursor"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	assert.Equal(t, []map[string]any{{"id": first.ID.Hex(), "username": "alice"}}, page.Users)
+	assert.NotNil(t, page.NextCursor)
+
+	w = list("limit=1&q=li&online=true&fields=username&cursor=" + *page.NextCursor)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &page)
+	assert.Equal(t, "bob", page.Users[0]["username"])
+	assert.Nil(t, page.NextCursor)
+	assert.Contains(t, w.Body.String(), `"next_cursor":null`)
+
+	for _, query := range []string{
+		"limit=101",
+		"limit=-1",
+		"online=maybe",
+		"role=superuser",
+		"sort=password",
+		"fields=email",
+		"fields=password",
+		"cursor=garbage",
+		// a cursor is only valid for the order it was handed out for
+		"sort=-username&cursor=" + *pagination.Next(pagination.Cursor{Sort: "username", Value: "alice", ID: first.ID.Hex()}).NextCursor,
+	} {
+		assert.Equal(t, http.StatusBadRequest, list(query).Code, query)
+	}
+
 	mockUserRepo.AssertExpectations(t)
-}
\ No newline at end of file
+}
+
+func TestProfile_PartialUpdate(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	userObjectID, _ := primitive.ObjectIDFromHex("6592008029c8c3e4dc76256c")
+	stored := &models.User{ID: userObjectID, Username: "user-player", Email: "test@test.com", AvatarUrl: "https://uriel.com/avatars/1.png"}
+	updated := *stored
+	updated.FullName = "Test Player"
+	updated.UpdatedAt = time.Now().UTC()
+
+	fullName := "Test Player"
+	mockUserRepo.On("GetUserById", mock.Anything, "user-player-id-123").Return(stored, nil)
+	mockUserRepo.On("UpdateUserProfile", mock.Anything, "user-player-id-123", models.UpdateProfileRequest{FullName: &fullName}).Return(&updated, nil)
+
+	service := NewService(mockUserRepo, mockAvatarRepo)
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.GET("/users/profile", mockAuthMiddleware(), handler.GetProfile)
+	router.PUT("/users/profile", mockAuthMiddleware(), handler.UpdateProfile)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPut, "/users/profile", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users/profile", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var profile models.SelfUser
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	assert.Equal(t, "test@test.com", profile.Email)
+	assert.Equal(t, "UTC", profile.Timezone)
+
+	// only the full name is sent, so only the full name is written
+	w = update(`{"full_name": "  Test Player "}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &profile)
+	assert.Equal(t, "Test Player", profile.FullName)
+	assert.Equal(t, "https://uriel.com/avatars/1.png", profile.AvatarUrl)
+	assert.False(t, profile.UpdatedAt.IsZero())
+
+	w = update(`{"full_name": "` + strings.Repeat("a", 101) + `", "avatar_url": "javascript:alert(1)"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var res models.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Contains(t, res.Error.Details["fields"], "full_name")
+	assert.Contains(t, res.Error.Details["fields"], "avatar_url")
+
+	// an empty body changes nothing
+	w = update(`{}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockUserRepo.AssertExpectations(t)
+	mockUserRepo.AssertNumberOfCalls(t, "UpdateUserProfile", 1)
+}
+
+func TestProfile_AvatarUrlMustBeKnown(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	userObjectID, _ := primitive.ObjectIDFromHex("6592008029c8c3e4dc76256c")
+	catalogue := "https://uriel.com/avatars/1.png"
+	upload := "https://media.uriel.com/avatars/user-player-id-123/6592008029c8c3e4dc76256d/256.png"
+
+	mockAvatarRepo.On("GetAvatars", mock.Anything).Return([]models.Avatar{{ID: primitive.NewObjectID(), AvatarUrl: catalogue, Name: "one"}}, nil)
+	for _, avatarUrl := range []string{catalogue, upload} {
+		mockUserRepo.On("UpdateUserProfile", mock.Anything, "user-player-id-123", models.UpdateProfileRequest{AvatarUrl: &avatarUrl}).
+			Return(&models.User{ID: userObjectID, Username: "user-player", AvatarUrl: avatarUrl}, nil)
+	}
+
+	service := NewService(mockUserRepo, mockAvatarRepo, WithMediaURL("https://media.uriel.com/"))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.PUT("/users/profile", mockAuthMiddleware(), handler.UpdateProfile)
+
+	update := func(avatarUrl string) *httptest.ResponseRecorder {
+		jsonPayload, _ := json.Marshal(models.UpdateProfileRequest{AvatarUrl: &avatarUrl})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPut, "/users/profile", bytes.NewBuffer(jsonPayload))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, update(catalogue).Code)
+	assert.Equal(t, http.StatusOK, update(upload).Code)
+
+	// any other server, the upload of someone else, or a way out of the uploads of the user
+	for _, avatarUrl := range []string{
+		"https://tracker.example.com/pixel.png",
+		"https://media.uriel.com/avatars/someone-else/6592008029c8c3e4dc76256d/256.png",
+		"https://media.uriel.com/avatars/user-player-id-123/../someone-else/256.png",
+		"https://media.uriel.com.example.com/avatars/user-player-id-123/256.png",
+	} {
+		w := update(avatarUrl)
+		assert.Equal(t, http.StatusBadRequest, w.Code, avatarUrl)
+		var res models.ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		assert.Contains(t, res.Error.Details["fields"], "avatar_url")
+	}
+
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestSettings_PartialUpdate(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	userObjectID, _ := primitive.ObjectIDFromHex("6592008029c8c3e4dc76256c")
+	preferences := models.DefaultPreferences()
+	preferences.Timezone = "Europe/Berlin"
+	preferences.Notifications.Mentions = false
+	updated := &models.User{ID: userObjectID, Username: "user-player", Preferences: &preferences}
+
+	timezone, mentions := "Europe/Berlin", false
+	want := models.UpdateSettingsRequest{
+		Timezone:      &timezone,
+		Notifications: &models.NotificationSettingsUpdate{Mentions: &mentions},
+	}
+	mockUserRepo.On("GetUserById", mock.Anything, "user-player-id-123").Return(&models.User{ID: userObjectID}, nil)
+	mockUserRepo.On("UpdateUserSettings", mock.Anything, "user-player-id-123", want).Return(updated, nil)
+
+	service := NewService(mockUserRepo, mockAvatarRepo)
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.GET("/users/settings", mockAuthMiddleware(), handler.GetSettings)
+	router.PUT("/users/settings", mockAuthMiddleware(), handler.UpdateSettings)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPut, "/users/settings", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// users that never saved their settings get the defaults
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users/settings", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var settings models.Preferences
+	json.Unmarshal(w.Body.Bytes(), &settings)
+	assert.Equal(t, models.DefaultPreferences(), settings)
+
+	w = update(`{"timezone": "Europe/Berlin", "notification_settings": {"mentions": false}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &settings)
+	assert.Equal(t, "Europe/Berlin", settings.Timezone)
+	assert.False(t, settings.Notifications.Mentions)
+	assert.True(t, settings.Notifications.Meetings)
+
+	for _, body := range []string{
+		`{"timezone": "Mars/Olympus_Mons"}`,
+		`{"timezone": "Local"}`,
+		`{"avatar_settings": {"virtual_background": "../../etc/passwd"}}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, update(body).Code, body)
+	}
+
+	mockUserRepo.AssertExpectations(t)
+	mockUserRepo.AssertNumberOfCalls(t, "UpdateUserSettings", 1)
+}
+
+func TestUpdateStatus_Publishes(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	userObjectID, _ := primitive.ObjectIDFromHex("6592008029c8c3e4dc76256c")
+	autoExpireAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	stored := models.Presence{Status: models.StatusBusy, Message: "In a meeting until 3 PM", Emoji: "📅", AutoExpireAt: &autoExpireAt}
+	updated := &models.User{ID: userObjectID, IsOnline: true, Presence: &stored}
+
+	mockUserRepo.On("UpdateUserPresence", mock.Anything, "user-player-id-123", mock.MatchedBy(func(presence models.Presence) bool {
+		return presence.Status == models.StatusBusy && presence.Message == "In a meeting until 3 PM" &&
+			presence.AutoExpireAt.Equal(autoExpireAt) && !presence.UpdatedAt.IsZero()
+	})).Return(updated, nil)
+
+	broker := presence.NewBroker()
+	events, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	service := NewService(mockUserRepo, mockAvatarRepo, WithPresenceBroker(broker))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.PUT("/users/status", mockAuthMiddleware(), handler.UpdateStatus)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPut, "/users/status", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := update(`{"status": "busy", "message": " In a meeting until 3 PM ", "emoji": "📅", "auto_expire_at": "` + autoExpireAt.Format(time.RFC3339) + `"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var status models.PresenceStatus
+	json.Unmarshal(w.Body.Bytes(), &status)
+	assert.Equal(t, models.StatusBusy, status.Status)
+	assert.Equal(t, "📅", status.Emoji)
+
+	event := <-events
+	assert.Equal(t, presence.EventStatusChanged, event.Type)
+	assert.Equal(t, userObjectID.Hex(), event.UserID)
+	assert.Equal(t, models.StatusBusy, event.Presence.Status)
+
+	for _, body := range []string{
+		`{"status": "sleeping"}`,
+		`{"status": "away", "message": "` + strings.Repeat("a", 101) + `"}`,
+		`{"status": "away", "emoji": "<script>"}`,
+		`{"status": "away", "auto_expire_at": "2020-01-01T00:00:00Z"}`,
+		`{"status": "away", "auto_expire_at": "` + time.Now().AddDate(1, 0, 0).Format(time.RFC3339) + `"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, update(body).Code, body)
+	}
+
+	mockUserRepo.AssertNumberOfCalls(t, "UpdateUserPresence", 1)
+}
+
+func TestExpireStatuses_Publishes(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	reverted := []models.User{
+		{ID: primitive.NewObjectID(), IsOnline: true, Presence: &models.Presence{Status: models.StatusOnline}},
+		{ID: primitive.NewObjectID(), Presence: &models.Presence{Status: models.StatusOnline}},
+	}
+	mockUserRepo.On("ExpireStatuses", mock.Anything, mock.AnythingOfType("time.Time")).Return(reverted, nil)
+
+	broker := presence.NewBroker()
+	events, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	service := NewService(mockUserRepo, mockAvatarRepo, WithPresenceBroker(broker))
+	expired, err := service.ExpireStatuses(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, expired)
+
+	assert.Equal(t, models.StatusOnline, (<-events).Presence.Status)
+	// users that are signed out stay offline
+	assert.Equal(t, models.StatusOffline, (<-events).Presence.Status)
+}
+
+func TestPresenceEvents_Streams(t *testing.T) {
+	broker := presence.NewBroker()
+	service := NewService(new(MockUserRepository), new(MockAvatarRepository), WithPresenceBroker(broker))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.GET("/users/presence/events", mockAuthMiddleware(), handler.PresenceEvents)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/users/presence/events", nil)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	// the headers arrive once the handler subscribed
+	user := &models.User{ID: primitive.NewObjectID(), IsOnline: true, Presence: &models.Presence{Status: models.StatusAway}}
+	broker.Publish(presence.StatusChanged(user))
+
+	reader := bufio.NewReader(res.Body)
+	line, _ := reader.ReadString('\n')
+	assert.Equal(t, "event:"+presence.EventStatusChanged+"\n", line)
+	line, _ = reader.ReadString('\n')
+	assert.Contains(t, line, `"user_id":"`+user.ID.Hex()+`"`)
+	assert.Contains(t, line, `"status":"away"`)
+}
+
+func TestHeartbeat_AppliesRules(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	userObjectID, _ := primitive.ObjectIDFromHex("6592008029c8c3e4dc76256c")
+	recently := time.Now().Add(-10 * time.Second).UTC()
+	idle := &models.User{ID: userObjectID, IsOnline: true, Presence: &models.Presence{AutoStatus: models.StatusAway}}
+	active := &models.User{ID: userObjectID, IsOnline: true, Presence: &models.Presence{LastActiveAt: &recently}}
+
+	mockUserRepo.On("GetUserById", mock.Anything, "user-player-id-123").Return(idle, nil).Once()
+	mockUserRepo.On("UpdateAutoPresence", mock.Anything, userObjectID.Hex(), "", "", mock.AnythingOfType("*time.Time")).Return(active, nil).Once()
+
+	broker := presence.NewBroker()
+	events, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	engine := presence.NewEngine(presence.Idle(10 * time.Minute))
+	service := NewService(mockUserRepo, mockAvatarRepo, WithPresenceBroker(broker), WithPresenceEngine(engine))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/users/heartbeat", mockAuthMiddleware(), handler.Heartbeat)
+
+	heartbeat := func() models.PresenceStatus {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/users/heartbeat", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var status models.PresenceStatus
+		json.Unmarshal(w.Body.Bytes(), &status)
+		return status
+	}
+
+	// an away user is back
+	assert.Equal(t, models.StatusOnline, heartbeat().Status)
+	assert.Equal(t, models.StatusOnline, (<-events).Presence.Status)
+
+	// activity that was written a moment ago is not written again
+	mockUserRepo.On("GetUserById", mock.Anything, "user-player-id-123").Return(active, nil).Once()
+	assert.Equal(t, models.StatusOnline, heartbeat().Status)
+
+	mockUserRepo.AssertExpectations(t)
+	mockUserRepo.AssertNumberOfCalls(t, "UpdateAutoPresence", 1)
+	assert.Empty(t, events)
+}
+
+func TestEvaluatePresence_PublishesChanges(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	mockAvatarRepo := new(MockAvatarRepository)
+
+	longAgo := time.Now().Add(-time.Hour).UTC()
+	recently := time.Now().UTC()
+	idle := models.User{ID: primitive.NewObjectID(), IsOnline: true, Presence: &models.Presence{LastActiveAt: &longAgo}}
+	busy := models.User{ID: primitive.NewObjectID(), IsOnline: true, Presence: &models.Presence{Status: models.StatusBusy, LastActiveAt: &longAgo}}
+	active := models.User{ID: primitive.NewObjectID(), IsOnline: true, Presence: &models.Presence{LastActiveAt: &recently}}
+
+	away := func(user models.User) *models.User {
+		presence := *user.Presence
+		presence.AutoStatus = models.StatusAway
+		user.Presence = &presence
+		return &user
+	}
+	mockUserRepo.On("EachOnlineUser", mock.Anything, mock.Anything).Return([]models.User{idle, busy, active}, nil)
+	mockUserRepo.On("UpdateAutoPresence", mock.Anything, idle.ID.Hex(), models.StatusAway, "", (*time.Time)(nil)).Return(away(idle), nil)
+	mockUserRepo.On("UpdateAutoPresence", mock.Anything, busy.ID.Hex(), models.StatusAway, "", (*time.Time)(nil)).Return(away(busy), nil)
+
+	broker := presence.NewBroker()
+	events, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	engine := presence.NewEngine(presence.Idle(10 * time.Minute))
+	service := NewService(mockUserRepo, mockAvatarRepo, WithPresenceBroker(broker), WithPresenceEngine(engine))
+
+	changed, err := service.EvaluatePresence(context.Background())
+	assert.NoError(t, err)
+	// busy is stored as idle too, but still shows what the user chose
+	assert.Equal(t, 1, changed)
+
+	event := <-events
+	assert.Equal(t, idle.ID.Hex(), event.UserID)
+	assert.Equal(t, models.PresenceStatus{Status: models.StatusAway, Automatic: true}, event.Presence)
+	assert.Empty(t, events)
+
+	mockUserRepo.AssertExpectations(t)
+}
+
+// avatarUpload is a multipart form with the file under the avatar field
+// routesAs registers the routes as main does, for requests authenticated with the values
+func routesAs(handler *Handler, values gin.H) *gin.Engine {
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	authenticated := func(c *gin.Context) {
+		for key, value := range values {
+			c.Set(key, value)
+		}
+		c.Next()
+	}
+	RegisterRoutes(router.Group(""), handler, authenticated,
+		auth.RequirePermission(auth.PermManageAvatars),
+		auth.RequirePermission(auth.PermReadWorkspace),
+		auth.RequirePermission(auth.PermUpdateProfile))
+	return router
+}
+
+type accountRoute struct {
+	method string
+	path   string
+	body   string
+}
+
+// accountReads show the account of the user
+var accountReads = []accountRoute{
+	{http.MethodGet, "/users/profile", ""},
+	{http.MethodGet, "/users/settings", ""},
+}
+
+// accountWrites change the account of the user, the avatar upload is tested on its own
+var accountWrites = []accountRoute{
+	{http.MethodPost, "/users/avatar", `{"avatar_id": "test-avatarId-123"}`},
+	{http.MethodPut, "/users/profile", `{"full_name": "Visitor"}`},
+	{http.MethodPut, "/users/settings", `{"timezone": "UTC"}`},
+	{http.MethodPut, "/users/status", `{"status": "busy"}`},
+	{http.MethodPost, "/users/heartbeat", ""},
+}
+
+// refused checks that every route answers 403 for the router. The mocks behind it have
+// no expectations for the routes, a handler that ran would panic on them.
+func refused(t *testing.T, router *gin.Engine, routes []accountRoute) {
+	for _, route := range routes {
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(route.method, route.path, strings.NewReader(route.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			var res models.ErrorResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, apperr.CodeAuthorization, res.Error.Code)
+		})
+	}
+}
+
+func TestAccountRoutes_RefuseGuests(t *testing.T) {
+	handler := NewHandler(NewService(new(MockUserRepository), new(MockAvatarRepository)))
+	router := routesAs(handler, gin.H{"userID": "guest_Ab12Cd", "role": config.GUEST, "authMethod": "guest"})
+
+	refused(t, router, accountReads)
+	refused(t, router, accountWrites)
+}
+
+func TestAccountRoutes_RespectTokenScopes(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	handler := NewHandler(NewService(mockUserRepo, new(MockAvatarRepository)))
+	router := routesAs(handler, gin.H{
+		"userID":     "user-player-id-123",
+		"role":       config.USER,
+		"scopes":     []string{auth.PermReadWorkspace},
+		"authMethod": "personal_access_token",
+	})
+
+	// a read only token can read the profile
+	mockUserRepo.On("GetUserById", mock.Anything, "user-player-id-123").Return(&models.User{Username: "user-player"}, nil)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/users/profile", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// but changes nothing
+	refused(t, router, accountWrites)
+	mockUserRepo.AssertExpectations(t)
+}
+
+func avatarUpload(t *testing.T, field string, data []byte) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, "me.png")
+	assert.NoError(t, err)
+	part.Write(data)
+	assert.NoError(t, form.Close())
+
+	req, _ := http.NewRequest(http.MethodPost, "/users/profile/avatar", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func testImage(t *testing.T) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 300, 200))))
+	return buf.Bytes()
+}
+
+func TestUploadAvatar_StoresThumbnails(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	dir := t.TempDir()
+	service := NewService(mockUserRepo, new(MockAvatarRepository), WithBlobStore(blob.NewLocalStore(dir, "https://uriel.example/media")))
+	handler := NewHandler(service)
+
+	router := gin.New()
+	router.Use(apperr.Middleware())
+	router.POST("/users/profile/avatar", mockAuthMiddleware(), handler.UploadAvatar)
+
+	mockUserRepo.On("UpdateUserAvatar", mock.Anything, "user-player-id-123", mock.MatchedBy(func(url string) bool {
+		return strings.HasPrefix(url, "https://uriel.example/media/avatars/user-player-id-123/") && strings.HasSuffix(url, "/256.png")
+	})).Return(nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, avatarUpload(t, "avatar", testImage(t)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res models.UploadAvatarResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Len(t, res.Thumbnails, len(avatar.ThumbnailSizes))
+	assert.Equal(t, res.Thumbnails[0].Url, res.AvatarUrl)
+
+	for i, thumbnail := range res.Thumbnails {
+		assert.Equal(t, avatar.ThumbnailSizes[i], thumbnail.Size)
+
+		path := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(thumbnail.Url, "https://uriel.example/media/")))
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		stored, err := png.DecodeConfig(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, thumbnail.Size, stored.Width)
+		assert.Equal(t, thumbnail.Size, stored.Height)
+	}
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestUploadAvatar_Refused(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        func(t *testing.T) *http.Request
+		wantStatus int
+		wantCode   string
+	}{
+		{"not an image", func(t *testing.T) *http.Request {
+			return avatarUpload(t, "avatar", []byte("<svg onload=alert(1)></svg>"))
+		}, http.StatusUnsupportedMediaType, apperr.CodeFileType},
+		{"too large", func(t *testing.T) *http.Request {
+			return avatarUpload(t, "avatar", append(testImage(t), make([]byte, config.MAX_AVATAR_UPLOAD_BYTES)...))
+		}, http.StatusRequestEntityTooLarge, apperr.CodeUploadSize},
+		{"request too large", func(t *testing.T) *http.Request {
+			return avatarUpload(t, "avatar", append(testImage(t), make([]byte, 2*config.MAX_AVATAR_UPLOAD_BYTES)...))
+		}, http.StatusRequestEntityTooLarge, apperr.CodeUploadSize},
+		{"wrong field", func(t *testing.T) *http.Request {
+			return avatarUpload(t, "picture", testImage(t))
+		}, http.StatusBadRequest, apperr.CodeValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserRepo := new(MockUserRepository)
+			dir := t.TempDir()
+			service := NewService(mockUserRepo, new(MockAvatarRepository), WithBlobStore(blob.NewLocalStore(dir, "/media")))
+			handler := NewHandler(service)
+
+			router := gin.New()
+			router.Use(apperr.Middleware())
+			router.POST("/users/profile/avatar", mockAuthMiddleware(), handler.UploadAvatar)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, tt.req(t))
+			assert.Equal(t, tt.wantStatus, w.Code)
+
+			var res models.ErrorResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, tt.wantCode, res.Error.Code)
+
+			entries, _ := os.ReadDir(dir)
+			assert.Empty(t, entries, "nothing is stored")
+			mockUserRepo.AssertNotCalled(t, "UpdateUserAvatar", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestUploadAvatar_CleansUpWhenTheUserIsNotUpdated(t *testing.T) {
+	mockUserRepo := new(MockUserRepository)
+	dir := t.TempDir()
+	service := NewService(mockUserRepo, new(MockAvatarRepository), WithBlobStore(blob.NewLocalStore(dir, "/media")))
+
+	mockUserRepo.On("UpdateUserAvatar", mock.Anything, "user-player-id-123", mock.Anything).Return(errors.New("db down"))
+
+	_, err := service.UploadAvatar(context.Background(), "user-player-id-123", bytes.NewReader(testImage(t)))
+	assert.Error(t, err)
+
+	var files []string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	assert.Empty(t, files)
+}
+
+func TestUploadAvatar_RefusedBeforeDecoding(t *testing.T) {
+	tests := map[string]gin.H{
+		"guest": {"userID": "guest_Ab12Cd", "role": config.GUEST, "authMethod": "guest"},
+		"read only token": {
+			"userID":     "user-player-id-123",
+			"role":       config.USER,
+			"scopes":     []string{auth.PermReadWorkspace},
+			"authMethod": "personal_access_token",
+		},
+	}
+	for name, values := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			service := NewService(new(MockUserRepository), new(MockAvatarRepository), WithBlobStore(blob.NewLocalStore(dir, "/media")))
+			router := routesAs(NewHandler(service), values)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, avatarUpload(t, "avatar", testImage(t)))
+			assert.Equal(t, http.StatusForbidden, w.Code)
+
+			entries, _ := os.ReadDir(dir)
+			assert.Empty(t, entries, "nothing is stored")
+		})
+	}
+}
@@ -2,6 +2,7 @@ package user
 
 import (
 	"context"
+	"time"
 
 	"github.com/palSagnik/uriel/internal/models"
 	"github.com/stretchr/testify/mock"
@@ -16,9 +17,9 @@ type MockAvatarRepository struct {
 }
 
 // Mocking user repository methods
-// GetUsers(ctx context.Context) ([]models.User, error)
-func (m *MockUserRepository) GetUsers(ctx context.Context) ([]models.User, error) {
-	args := m.Called(ctx)
+// ListUsers(ctx context.Context, query UserQuery) ([]models.User, error)
+func (m *MockUserRepository) ListUsers(ctx context.Context, query UserQuery) ([]models.User, error) {
+	args := m.Called(ctx, query)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -32,6 +33,79 @@ func (m *MockUserRepository) UpdateUserAvatar(ctx context.Context, id, avatarUrl
 	return args.Error(0)
 }
 
+// GetUserById(ctx context.Context, id string) (*models.User, error)
+func (m *MockUserRepository) GetUserById(ctx context.Context, id string) (*models.User, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+// UpdateUserProfile(ctx context.Context, id string, update models.UpdateProfileRequest) (*models.User, error)
+func (m *MockUserRepository) UpdateUserProfile(ctx context.Context, id string, update models.UpdateProfileRequest) (*models.User, error) {
+	args := m.Called(ctx, id, update)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+// UpdateUserSettings(ctx context.Context, id string, update models.UpdateSettingsRequest) (*models.User, error)
+func (m *MockUserRepository) UpdateUserSettings(ctx context.Context, id string, update models.UpdateSettingsRequest) (*models.User, error) {
+	args := m.Called(ctx, id, update)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+// UpdateUserPresence(ctx context.Context, id string, presence models.Presence) (*models.User, error)
+func (m *MockUserRepository) UpdateUserPresence(ctx context.Context, id string, presence models.Presence) (*models.User, error) {
+	args := m.Called(ctx, id, presence)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+// ExpireStatuses(ctx context.Context, now time.Time) ([]models.User, error)
+func (m *MockUserRepository) ExpireStatuses(ctx context.Context, now time.Time) ([]models.User, error) {
+	args := m.Called(ctx, now)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]models.User), args.Error(1)
+}
+
+// UpdateAutoPresence(ctx context.Context, id string, status string, message string, lastActiveAt *time.Time) (*models.User, error)
+func (m *MockUserRepository) UpdateAutoPresence(ctx context.Context, id string, status string, message string, lastActiveAt *time.Time) (*models.User, error) {
+	args := m.Called(ctx, id, status, message, lastActiveAt)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+// EachOnlineUser(ctx context.Context, fn func(user *models.User) error) error
+func (m *MockUserRepository) EachOnlineUser(ctx context.Context, fn func(user *models.User) error) error {
+	args := m.Called(ctx, fn)
+	if users, ok := args.Get(0).([]models.User); ok {
+		for i := range users {
+			if err := fn(&users[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return args.Error(1)
+}
+
 // Mocking avatar repository methods
 // GetAvatarUrlById(ctx context.Context, id string) (string, error)
 func (m *MockAvatarRepository) GetAvatarUrlById(ctx context.Context, id string) (string, error) {
@@ -51,4 +125,16 @@ func (m *MockAvatarRepository) GetAvatars(ctx context.Context) ([]models.Avatar,
 	}
 
 	return args.Get(0).([]models.Avatar), args.Error(1)
-}
\ No newline at end of file
+}
+
+// CreateAvatar(ctx context.Context, avatar models.Avatar) error
+func (m *MockAvatarRepository) CreateAvatar(ctx context.Context, avatar models.Avatar) error {
+	args := m.Called(ctx, avatar)
+	return args.Error(0)
+}
+
+// DeleteAvatar(ctx context.Context, id string) error
+func (m *MockAvatarRepository) DeleteAvatar(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
@@ -0,0 +1,43 @@
+package user
+
+import (
+	"strings"
+
+	"github.com/palSagnik/uriel/internal/blob"
+	"github.com/palSagnik/uriel/internal/presence"
+)
+
+// Option configures the optional collaborators of the user Service
+type Option func(*Service)
+
+// WithPresenceBroker sets where status changes are published and streamed from.
+// Defaults to a broker of its own, the auth Service has to share it to publish sign ins.
+func WithPresenceBroker(broker *presence.Broker) Option {
+	return func(s *Service) {
+		s.presenceBroker = broker
+	}
+}
+
+// WithPresenceEngine sets the rules that change the status of users on their own.
+// Defaults to no rules, users are online until they choose otherwise.
+func WithPresenceEngine(engine *presence.Engine) Option {
+	return func(s *Service) {
+		s.presenceEngine = engine
+	}
+}
+
+// WithBlobStore sets where uploaded avatars are kept.
+// Without one avatars can only be picked from the catalogue.
+func WithBlobStore(store blob.Store) Option {
+	return func(s *Service) {
+		s.blobStore = store
+	}
+}
+
+// WithMediaURL sets where the blob store serves uploads from, a profile may point its
+// avatar at an upload of the user under it. Without one only catalogue avatars are accepted.
+func WithMediaURL(mediaURL string) Option {
+	return func(s *Service) {
+		s.mediaURL = strings.TrimSuffix(mediaURL, "/")
+	}
+}
@@ -0,0 +1,169 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"github.com/palSagnik/uriel/internal/presence"
+)
+
+// UpdateStatus replaces the status of the user and publishes the change
+func (s *Service) UpdateStatus(ctx context.Context, userId string, req *models.UpdateStatusRequest) (*models.PresenceStatus, error) {
+	now := time.Now().UTC()
+	if err := validateStatus(req, now); err != nil {
+		return nil, err
+	}
+
+	status := models.Presence{
+		Status:    req.Status,
+		Message:   req.Message,
+		Emoji:     req.Emoji,
+		UpdatedAt: now,
+	}
+	if req.AutoExpireAt != nil {
+		autoExpireAt := req.AutoExpireAt.UTC()
+		status.AutoExpireAt = &autoExpireAt
+	}
+
+	user, err := s.userRepo.UpdateUserPresence(ctx, userId, status)
+	if err != nil {
+		return nil, fmt.Errorf("service: error updating status %v", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	s.presenceBroker.Publish(presence.StatusChanged(user))
+	current := user.CurrentStatus(now)
+	return &current, nil
+}
+
+// SubscribePresence returns the presence events from now on, see presence.Broker.Subscribe
+func (s *Service) SubscribePresence() (<-chan presence.Event, func()) {
+	return s.presenceBroker.Subscribe()
+}
+
+// ExpireStatuses reverts the statuses past their auto_expire_at to online
+// and publishes each of them, it returns how many there were
+func (s *Service) ExpireStatuses(ctx context.Context) (int, error) {
+	users, err := s.userRepo.ExpireStatuses(ctx, time.Now().UTC())
+	for _, user := range users {
+		s.presenceBroker.Publish(presence.StatusChanged(&user))
+	}
+	if err != nil {
+		return len(users), fmt.Errorf("service: error expiring statuses %v", err)
+	}
+	return len(users), nil
+}
+
+// Heartbeat records activity of the user and runs the presence rules for them,
+// it returns the status they show afterwards
+func (s *Service) Heartbeat(ctx context.Context, userId string) (*models.PresenceStatus, error) {
+	user, err := s.getUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if user.Presence == nil {
+		user.Presence = &models.Presence{}
+	}
+	// the last activity only has to be as exact as the idle rule needs it
+	touch := user.Presence.LastActiveAt == nil || now.Sub(*user.Presence.LastActiveAt) >= config.LAST_ACTIVE_WRITE_INTERVAL
+	user.Presence.LastActiveAt = &now
+
+	if err := s.applyRules(ctx, user, now, touch); err != nil {
+		return nil, err
+	}
+	status := user.CurrentStatus(now)
+	return &status, nil
+}
+
+// EvaluatePresence runs the presence rules for every user that is signed in,
+// it returns how many changed their status
+func (s *Service) EvaluatePresence(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	changed := 0
+	err := s.userRepo.EachOnlineUser(ctx, func(user *models.User) error {
+		before := user.CurrentStatus(now)
+		// one user failing does not hold up the others
+		if err := s.applyRules(ctx, user, now, false); err != nil {
+			log.Printf("Error: could not apply presence rules for user %s: %v", user.ID.Hex(), err)
+			return nil
+		}
+		if !before.Equal(user.CurrentStatus(now)) {
+			changed++
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return changed, fmt.Errorf("service: error evaluating presence %v", err)
+	}
+	return changed, nil
+}
+
+// applyRules stores what the presence rules decide for user when it changed, or when touch is set
+// so the last activity is written, and publishes the change of status. user is updated in place.
+func (s *Service) applyRules(ctx context.Context, user *models.User, now time.Time, touch bool) error {
+	if user.Presence == nil {
+		user.Presence = &models.Presence{}
+	}
+	before := user.CurrentStatus(now)
+
+	facts := presence.Facts{UserID: user.ID.Hex(), Now: now}
+	if user.Presence.LastActiveAt != nil {
+		facts.LastActiveAt = *user.Presence.LastActiveAt
+	}
+	outcome := s.presenceEngine.Evaluate(ctx, facts)
+	if outcome.Status == models.StatusOnline {
+		outcome.Status = ""
+	}
+
+	decided := outcome.Status != user.Presence.AutoStatus || outcome.Message != user.Presence.AutoMessage
+	if !decided && !touch {
+		return nil
+	}
+
+	var lastActiveAt *time.Time
+	if touch {
+		lastActiveAt = user.Presence.LastActiveAt
+	}
+	updated, err := s.userRepo.UpdateAutoPresence(ctx, user.ID.Hex(), outcome.Status, outcome.Message, lastActiveAt)
+	if err != nil {
+		return fmt.Errorf("service: error updating presence %v", err)
+	}
+	if updated == nil {
+		return ErrUserNotFound
+	}
+	*user = *updated
+
+	if !before.Equal(user.CurrentStatus(now)) {
+		s.presenceBroker.Publish(presence.StatusChanged(user))
+	}
+	return nil
+}
+
+// RunPresenceScheduler reverts expired statuses and runs the presence rules every interval until ctx is done
+func (s *Service) RunPresenceScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// a failed run is picked up by the next one
+			if _, err := s.ExpireStatuses(ctx); err != nil {
+				log.Printf("Error: %v", err)
+			}
+			if _, err := s.EvaluatePresence(ctx); err != nil {
+				log.Printf("Error: %v", err)
+			}
+		}
+	}
+}
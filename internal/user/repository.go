@@ -2,11 +2,60 @@ package user
 
 import (
 	"context"
+	"time"
 
 	"github.com/palSagnik/uriel/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// the orders of the user directory, they are the paths that are sorted by
+const (
+	SortUsername  = "username"
+	SortCreatedAt = "created_at"
+)
+
+// UserQuery selects a page of the user directory, empty filters match every user
+type UserQuery struct {
+	// Search matches a part of the username or the full name regardless of case
+	Search      string
+	Online      *bool
+	Role        string
+	WorkspaceID string
+	RoomID      string
+
+	// Sort is SortUsername or SortCreatedAt
+	Sort       string
+	Descending bool
+	// AfterKey and AfterID are the sort key and id of the last user of the previous page,
+	// AfterKey is nil for the first page
+	AfterKey any
+	AfterID  primitive.ObjectID
+	Limit    int
+
+	// Fields are the paths that are loaded, the id and the sort key always are
+	Fields []string
+}
+
+// UserRepository never loads password hashes or two factor secrets,
+// those are only read through the auth repository
 type UserRepository interface {
-	GetUsers(ctx context.Context) ([]models.User, error)
+	ListUsers(ctx context.Context, query UserQuery) ([]models.User, error)
+	// GetUserById returns nil if there is no user with the id
+	GetUserById(ctx context.Context, id string) (*models.User, error)
 	UpdateUserAvatar(ctx context.Context, id string, avatarUrl string) error
+	// UpdateUserProfile and UpdateUserSettings only write the fields that are set
+	// and return the user as it is afterwards, nil if there is no user with the id
+	UpdateUserProfile(ctx context.Context, id string, update models.UpdateProfileRequest) (*models.User, error)
+	UpdateUserSettings(ctx context.Context, id string, update models.UpdateSettingsRequest) (*models.User, error)
+	// UpdateUserPresence replaces the status and returns the user as it is afterwards,
+	// nil if there is no user with the id
+	UpdateUserPresence(ctx context.Context, id string, presence models.Presence) (*models.User, error)
+	// ExpireStatuses reverts every status with an auto_expire_at before now to online
+	// and returns the users it reverted
+	ExpireStatuses(ctx context.Context, now time.Time) ([]models.User, error)
+	// UpdateAutoPresence stores what the presence rules decided, and the last activity when it is set.
+	// It returns the user as it is afterwards, nil if there is no user with the id.
+	UpdateAutoPresence(ctx context.Context, id string, status string, message string, lastActiveAt *time.Time) (*models.User, error)
+	// EachOnlineUser calls fn with every user that is signed in until fn fails
+	EachOnlineUser(ctx context.Context, fn func(user *models.User) error) error
 }
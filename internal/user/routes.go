@@ -2,11 +2,27 @@ package user
 
 import "github.com/gin-gonic/gin"
 
-func RegisterRoutes(router *gin.RouterGroup, handler *Handler, middleware gin.HandlerFunc) {
+// adminMiddleware runs after the auth middleware and guards catalogue management,
+// directoryMiddleware keeps guests out of the user directory, the presence events and the
+// reads of their own account, profileMiddleware guards the changes users make to it
+func RegisterRoutes(router *gin.RouterGroup, handler *Handler, middleware gin.HandlerFunc, adminMiddleware gin.HandlerFunc, directoryMiddleware gin.HandlerFunc, profileMiddleware gin.HandlerFunc) {
 	users := router.Group("/users")
 	{
-		users.POST("/avatar", middleware, handler.UpdateUserAvatar)
+		users.POST("/avatar", middleware, profileMiddleware, handler.UpdateUserAvatar)
 		users.GET("/avatar", middleware, handler.GetAllAvatars)
-		users.GET("/user", middleware, handler.GetAllUsers)
+		users.GET("/user", middleware, directoryMiddleware, handler.GetAllUsers)
+
+		users.GET("/profile", middleware, directoryMiddleware, handler.GetProfile)
+		users.PUT("/profile", middleware, profileMiddleware, handler.UpdateProfile)
+		users.POST("/profile/avatar", middleware, profileMiddleware, handler.UploadAvatar)
+		users.GET("/settings", middleware, directoryMiddleware, handler.GetSettings)
+		users.PUT("/settings", middleware, profileMiddleware, handler.UpdateSettings)
+
+		users.PUT("/status", middleware, profileMiddleware, handler.UpdateStatus)
+		users.POST("/heartbeat", middleware, profileMiddleware, handler.Heartbeat)
+		users.GET("/presence/events", middleware, directoryMiddleware, handler.PresenceEvents)
+
+		users.POST("/avatar/catalogue", middleware, adminMiddleware, handler.CreateAvatar)
+		users.DELETE("/avatar/catalogue/:id", middleware, adminMiddleware, handler.DeleteAvatar)
 	}
 }
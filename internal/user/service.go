@@ -1,23 +1,44 @@
 package user
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
 
+	"github.com/palSagnik/uriel/internal/apperr"
 	"github.com/palSagnik/uriel/internal/avatar"
+	"github.com/palSagnik/uriel/internal/blob"
 	"github.com/palSagnik/uriel/internal/models"
+	"github.com/palSagnik/uriel/internal/pagination"
+	"github.com/palSagnik/uriel/internal/presence"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Service struct {
-	userRepo   UserRepository
-	avatarRepo avatar.AvatarRepository
+	userRepo       UserRepository
+	avatarRepo     avatar.AvatarRepository
+	presenceBroker *presence.Broker
+	presenceEngine *presence.Engine
+	blobStore      blob.Store
+	mediaURL       string
 }
 
-func NewService(userRepo UserRepository, avatarRepo avatar.AvatarRepository) *Service {
-	return &Service{
-		userRepo:   userRepo,
-		avatarRepo: avatarRepo,
+func NewService(userRepo UserRepository, avatarRepo avatar.AvatarRepository, opts ...Option) *Service {
+	s := &Service{
+		userRepo:       userRepo,
+		avatarRepo:     avatarRepo,
+		presenceBroker: presence.NewBroker(),
+		presenceEngine: presence.NewEngine(),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *Service) UpdateUserAvatar(ctx context.Context, userId string, avatarId string) (string, error) {
@@ -33,20 +54,222 @@ func (s *Service) UpdateUserAvatar(ctx context.Context, userId string, avatarId
 	return "updated avatar succesfully", nil
 }
 
+// UploadAvatar makes the thumbnails of an uploaded image and uses the largest as the avatar of the user.
+// Every upload is kept under a key of its own so caches never serve the avatar it replaced.
+func (s *Service) UploadAvatar(ctx context.Context, userId string, upload io.Reader) (*models.UploadAvatarResponse, error) {
+	if s.blobStore == nil {
+		return nil, errors.New("service: no blob store for avatar uploads")
+	}
+
+	thumbnails, err := avatar.Thumbnails(upload)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadId := primitive.NewObjectID().Hex()
+	res := &models.UploadAvatarResponse{}
+	var stored []string
+	for _, thumbnail := range thumbnails {
+		key := fmt.Sprintf("avatars/%s/%s/%d.png", userId, uploadId, thumbnail.Size)
+		url, err := s.blobStore.Put(ctx, key, "image/png", bytes.NewReader(thumbnail.Data))
+		if err != nil {
+			s.deleteBlobs(stored)
+			return nil, fmt.Errorf("service: error storing avatar %v", err)
+		}
+		stored = append(stored, key)
+		res.Thumbnails = append(res.Thumbnails, models.AvatarThumbnail{Size: thumbnail.Size, Url: url})
+	}
+	res.AvatarUrl = res.Thumbnails[0].Url
+
+	if err := s.userRepo.UpdateUserAvatar(ctx, userId, res.AvatarUrl); err != nil {
+		s.deleteBlobs(stored)
+		return nil, fmt.Errorf("service: error updating avatar %v", err)
+	}
+
+	return res, nil
+}
+
+// deleteBlobs cleans up after an upload that failed half way, the request context may be gone by then
+func (s *Service) deleteBlobs(keys []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, key := range keys {
+		if err := s.blobStore.Delete(ctx, key); err != nil {
+			log.Printf("Warning: failed to delete blob %s: %v", key, err)
+		}
+	}
+}
+
 func (s *Service) GetAvatars(ctx context.Context) ([]models.Avatar, error) {
 	avatars, err := s.avatarRepo.GetAvatars(ctx)
 	if err != nil {
-		return nil, errors.New("failed to get avatars")
+		return nil, fmt.Errorf("service: error retrieving avatars %v", err)
 	}
 
 	return avatars, nil
 }
 
-func (s *Service) GetUsers(ctx context.Context) ([]models.User, error) {
-	users, err := s.userRepo.GetUsers(ctx)
+func (s *Service) CreateAvatar(ctx context.Context, name string, avatarUrl string) (*models.Avatar, error) {
+	invalid := &apperr.ValidationError{}
+	if name == "" {
+		invalid.Add("name", "is required")
+	}
+	if !validAvatarUrl(avatarUrl) {
+		invalid.Add("avatar_url", "must be a valid http(s) url")
+	}
+	if err := invalid.ErrOrNil(); err != nil {
+		return nil, err
+	}
+
+	newAvatar := models.Avatar{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		AvatarUrl: avatarUrl,
+	}
+	if err := s.avatarRepo.CreateAvatar(ctx, newAvatar); err != nil {
+		return nil, fmt.Errorf("service: error in creating avatar %v", err)
+	}
+
+	return &newAvatar, nil
+}
+
+func (s *Service) DeleteAvatar(ctx context.Context, avatarId string) error {
+	return s.avatarRepo.DeleteAvatar(ctx, avatarId)
+}
+
+// ListUsers returns a page of the user directory, with admin the users have the details only admins see
+func (s *Service) ListUsers(ctx context.Context, req *models.ListUsersRequest, admin bool) (*models.ListUsersResponse, error) {
+	query, fields, sort, err := validateListUsers(req, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	// one user more than the page tells if there is a next one
+	limit := query.Limit
+	query.Limit++
+	users, err := s.userRepo.ListUsers(ctx, *query)
+	if err != nil {
+		return nil, fmt.Errorf("service: error listing users %v", err)
+	}
+
+	response := &models.ListUsersResponse{Users: []map[string]any{}}
+	if len(users) > limit {
+		users = users[:limit]
+		response.Page = pagination.Next(cursorAfter(&users[limit-1], sort))
+	}
+	for _, user := range users {
+		listed, err := listedUser(&user, fields, admin)
+		if err != nil {
+			return nil, fmt.Errorf("service: error listing users %v", err)
+		}
+		response.Users = append(response.Users, listed)
+	}
+	return response, nil
+}
+
+// GetProfile returns the profile of the user themselves
+func (s *Service) GetProfile(ctx context.Context, userId string) (*models.SelfUser, error) {
+	user, err := s.getUser(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 
-	return users, nil
+	profile := user.Self()
+	return &profile, nil
+}
+
+// UpdateProfile changes the profile fields that are set in req
+func (s *Service) UpdateProfile(ctx context.Context, userId string, req *models.UpdateProfileRequest) (*models.SelfUser, error) {
+	if err := validateProfile(req); err != nil {
+		return nil, err
+	}
+	if req.AvatarUrl != nil && *req.AvatarUrl != "" {
+		known, err := s.knownAvatarUrl(ctx, userId, *req.AvatarUrl)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			invalid := &apperr.ValidationError{}
+			invalid.Add("avatar_url", "must be an avatar of the catalogue or one you uploaded")
+			return nil, invalid
+		}
+	}
+	// nothing to write, updated_at stays as it is
+	if req.FullName == nil && req.AvatarUrl == nil {
+		return s.GetProfile(ctx, userId)
+	}
+
+	user, err := s.userRepo.UpdateUserProfile(ctx, userId, *req)
+	if err != nil {
+		return nil, fmt.Errorf("service: error updating profile %v", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	profile := user.Self()
+	return &profile, nil
+}
+
+// knownAvatarUrl tells whether avatarUrl is in the catalogue or an upload of the user,
+// anything else would have clients load images from wherever the user chooses
+func (s *Service) knownAvatarUrl(ctx context.Context, userId string, avatarUrl string) (bool, error) {
+	if s.mediaURL != "" && !strings.Contains(avatarUrl, "..") &&
+		strings.HasPrefix(avatarUrl, s.mediaURL+"/avatars/"+userId+"/") {
+		return true, nil
+	}
+
+	avatars, err := s.avatarRepo.GetAvatars(ctx)
+	if err != nil {
+		return false, fmt.Errorf("service: error retrieving avatars %v", err)
+	}
+	for _, entry := range avatars {
+		if entry.AvatarUrl == avatarUrl {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetSettings returns the preferences of the user, the defaults for those they never changed
+func (s *Service) GetSettings(ctx context.Context, userId string) (*models.Preferences, error) {
+	user, err := s.getUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := user.Settings()
+	return &settings, nil
+}
+
+// UpdateSettings changes the preferences that are set in req
+func (s *Service) UpdateSettings(ctx context.Context, userId string, req *models.UpdateSettingsRequest) (*models.Preferences, error) {
+	if err := validateSettings(req); err != nil {
+		return nil, err
+	}
+	if req.Timezone == nil && req.Notifications == nil && req.Avatar == nil {
+		return s.GetSettings(ctx, userId)
+	}
+
+	user, err := s.userRepo.UpdateUserSettings(ctx, userId, *req)
+	if err != nil {
+		return nil, fmt.Errorf("service: error updating settings %v", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	settings := user.Settings()
+	return &settings, nil
+}
+
+func (s *Service) getUser(ctx context.Context, userId string) (*models.User, error) {
+	user, err := s.userRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("service: error retrieving user %v", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
 }
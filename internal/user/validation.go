@@ -0,0 +1,184 @@
+package user
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	// timezones are checked against the embedded database, the host may not have one
+	_ "time/tzdata"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/palSagnik/uriel/internal/apperr"
+	"github.com/palSagnik/uriel/internal/config"
+	"github.com/palSagnik/uriel/internal/models"
+	"github.com/palSagnik/uriel/internal/pagination"
+)
+
+const (
+	maxFullNameLength  = 100
+	maxAvatarUrlLength = 2048
+	maxSearchLength    = 100
+	maxStatusLength    = 100
+	maxEmojiRunes      = 16
+)
+
+var statuses = []string{models.StatusOnline, models.StatusAway, models.StatusBusy, models.StatusDoNotDisturb, models.StatusOffline}
+
+var roles = []string{config.GUEST, config.USER, config.ADMIN, config.OWNER}
+
+var virtualBackgroundPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// validateProfile checks the fields that are set, the full name is trimmed in place
+func validateProfile(req *models.UpdateProfileRequest) error {
+	invalid := &apperr.ValidationError{}
+
+	if req.FullName != nil {
+		fullName := strings.TrimSpace(*req.FullName)
+		req.FullName = &fullName
+		if utf8.RuneCountInString(fullName) > maxFullNameLength {
+			invalid.Add("full_name", fmt.Sprintf("must be at most %d characters", maxFullNameLength))
+		}
+		if strings.IndexFunc(fullName, unicode.IsControl) >= 0 {
+			invalid.Add("full_name", "must not contain control characters")
+		}
+	}
+	// an empty url removes the avatar
+	if req.AvatarUrl != nil && *req.AvatarUrl != "" && !validAvatarUrl(*req.AvatarUrl) {
+		invalid.Add("avatar_url", "must be a valid http(s) url")
+	}
+
+	return invalid.ErrOrNil()
+}
+
+// validateSettings checks the settings that are set
+func validateSettings(req *models.UpdateSettingsRequest) error {
+	invalid := &apperr.ValidationError{}
+
+	if req.Timezone != nil {
+		// LoadLocation takes "" and "Local" for the timezone of the server
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" || *req.Timezone == "Local" {
+			invalid.Add("timezone", "must be an IANA time zone such as Europe/Berlin")
+		}
+	}
+	if req.Avatar != nil && req.Avatar.VirtualBackground != nil && !virtualBackgroundPattern.MatchString(*req.Avatar.VirtualBackground) {
+		invalid.Add("avatar_settings.virtual_background", "must be 1 to 32 lower case letters, digits, dashes or underscores")
+	}
+
+	return invalid.ErrOrNil()
+}
+
+// validateStatus checks a status set at now, the message is trimmed in place
+func validateStatus(req *models.UpdateStatusRequest, now time.Time) error {
+	invalid := &apperr.ValidationError{}
+
+	if !slices.Contains(statuses, req.Status) {
+		invalid.Add("status", "must be one of "+strings.Join(statuses, ", "))
+	}
+
+	req.Message = strings.TrimSpace(req.Message)
+	if utf8.RuneCountInString(req.Message) > maxStatusLength {
+		invalid.Add("message", fmt.Sprintf("must be at most %d characters", maxStatusLength))
+	}
+	if strings.IndexFunc(req.Message, unicode.IsControl) >= 0 {
+		invalid.Add("message", "must not contain control characters")
+	}
+
+	if req.Emoji != "" && !validEmoji(req.Emoji) {
+		invalid.Add("emoji", "must be a single emoji")
+	}
+
+	if req.AutoExpireAt != nil {
+		if !req.AutoExpireAt.After(now) {
+			invalid.Add("auto_expire_at", "must be in the future")
+		}
+		if req.AutoExpireAt.After(now.Add(config.MAX_STATUS_DURATION)) {
+			invalid.Add("auto_expire_at", fmt.Sprintf("must be at most %d days ahead", int(config.MAX_STATUS_DURATION.Hours()/24)))
+		}
+	}
+
+	return invalid.ErrOrNil()
+}
+
+// validEmoji accepts symbols along with the joiners, variation selectors and
+// keycap mark emoji sequences are made of, text and markup are refused
+func validEmoji(emoji string) bool {
+	if utf8.RuneCountInString(emoji) > maxEmojiRunes {
+		return false
+	}
+	symbols := 0
+	for _, r := range emoji {
+		switch {
+		case unicode.In(r, unicode.So, unicode.Sk):
+			symbols++
+		case r == '\u200d', r == '\ufe0e', r == '\ufe0f', r == '\u20e3':
+		default:
+			return false
+		}
+	}
+	return symbols > 0
+}
+
+// validateListUsers turns the query parameters of the directory into the query for a page,
+// the fields of the view to list and the order of the cursors
+func validateListUsers(req *models.ListUsersRequest, admin bool) (query *UserQuery, fields []string, sort string, err error) {
+	invalid := &apperr.ValidationError{}
+	query = &UserQuery{
+		Search:      strings.TrimSpace(req.Search),
+		Online:      req.Online,
+		Role:        req.Role,
+		WorkspaceID: req.Workspace,
+		RoomID:      req.Room,
+	}
+
+	if query.Limit, err = pagination.Limit(req.Limit); err != nil {
+		invalid.Add("limit", err.Error())
+	}
+	if utf8.RuneCountInString(query.Search) > maxSearchLength {
+		invalid.Add("q", fmt.Sprintf("must be at most %d characters", maxSearchLength))
+	}
+	if req.Role != "" && !slices.Contains(roles, req.Role) {
+		invalid.Add("role", "must be one of "+strings.Join(roles, ", "))
+	}
+
+	sort = req.Sort
+	if sort == "" {
+		sort = SortUsername
+	}
+	query.Sort, query.Descending = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+	if query.Sort != SortUsername && query.Sort != SortCreatedAt {
+		invalid.Add("sort", "must be username or created_at, with a leading - for descending order")
+	}
+
+	fields, unknown, ok := selectFields(req.Fields, admin)
+	if !ok {
+		invalid.Add("fields", fmt.Sprintf("%q is not a field of the directory", unknown))
+	}
+	query.Fields = projectionPaths(fields)
+
+	if err := invalid.ErrOrNil(); err != nil {
+		return nil, nil, "", err
+	}
+
+	if req.Cursor != "" {
+		cursor, err := pagination.Decode(req.Cursor, sort)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if err := resume(query, cursor); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	return query, fields, sort, nil
+}
+
+func validAvatarUrl(avatarUrl string) bool {
+	if len(avatarUrl) > maxAvatarUrlLength {
+		return false
+	}
+	parsed, err := url.Parse(avatarUrl)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}